@@ -148,7 +148,11 @@ func (c *Client) send(call *Call) {
 	c.header.Error = ""
 
 	//编码和发送请求
-	if err := c.cc.Write(&c.header, call.Args); err != nil {
+	err = c.cc.Write(&c.header, call.Args)
+	if err == nil {
+		err = c.cc.Flush()
+	}
+	if err != nil {
 		call := c.removeCall(seq)
 		//调用可能为空，这通常意味着写失败
 		//服务端已经接收请求和处理
@@ -190,18 +194,24 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
-	opt.MagicNumber = DefaultOption.MagicNumber
+	//复制一份，不修改调用方的Option
+	opt := *opts[0]
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = DefaultOption.MagicNumber
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	if err := codec.CheckOption(opt.CodecType, 0, 0); err != nil {
+		return nil, err
+	}
+	return &opt, nil
 }
 
 
 // NewClient 创建Client实例，首先需要一开始的协议交换，即发送Option信息给服务端
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
+	f := codec.Lookup(opt.CodecType)
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
 		log.Println("rpc client: codec error:", err)
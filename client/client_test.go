@@ -0,0 +1,57 @@
+package goRPC
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	opt := &Option{MagicNumber: 0x5eed}
+	got, err := parseOptions(opt)
+	if err != nil {
+		t.Fatalf("failed to parse options: %v", err)
+	}
+	if got == opt || *opt != (Option{MagicNumber: 0x5eed}) {
+		t.Fatalf("caller's option was modified: %+v", *opt)
+	}
+	if got.MagicNumber != 0x5eed || got.CodecType != DefaultOption.CodecType {
+		t.Fatalf("expect the magic number kept and the codec defaulted, got %+v", *got)
+	}
+	if got, _ = parseOptions(&Option{}); got.MagicNumber != MagicNumber {
+		t.Fatalf("expect the default magic number, got %x", got.MagicNumber)
+	}
+}
+
+func TestDial_SharedOption(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	go NewServer().Accept(l)
+
+	opt := &Option{}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := Dial("tcp", l.Addr().String(), opt)
+			if err != nil {
+				t.Errorf("failed to dial: %v", err)
+				return
+			}
+			_ = client.Close()
+		}()
+	}
+	wg.Wait()
+	if *opt != (Option{}) {
+		t.Fatalf("caller's option was modified: %+v", *opt)
+	}
+
+	if _, err = Dial("tcp", l.Addr().String(), &Option{CodecType: "application/unknown"}); err == nil || !strings.Contains(err.Error(), "application/unknown") {
+		t.Fatalf("expect the invalid codec type, got %v", err)
+	}
+}
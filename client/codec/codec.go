@@ -1,7 +1,12 @@
 package codec
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Header 请求头
@@ -9,6 +14,14 @@ type Header struct {
 	ServiceMethod string // 服务名和方法名：通常与Go中的结构体和方法互相映射
 	Seq           uint64 // 请求序号：也可以认为是某个请求的ID，用来区分不同的请求
 	Error         string // 错误信息：客户端置为空，服务端如果发生错误，将错误信息置于Error中
+	Callback      bool   // 方向标志：为true时表示该帧属于服务端发起的回调（回调请求或其响应），序号空间与普通调用相互独立
+	Stream        bool   // 流式标志：为true时表示该帧是流式响应中的一条消息，同序号的普通响应帧表示流结束
+	Token         string // 单次调用的令牌：客户端可选，由服务端的Authenticate校验
+	TraceID       string // 追踪ID：客户端为每次调用生成或沿用调用方的ID，服务端原样带回
+	Oneway        bool   // 单向标志：为true时表示请求不需要响应，服务端处理后不发送任何帧，序号无意义
+	Priority      uint8  // 优先级：0为普通，大于0为高，服务端设置了工作协程上限时高优先级请求先被处理
+	Timeout       int64  // 剩余时间：客户端ctx截止前剩余的纳秒数，0表示没有截止时间，服务端据此设置方法的ctx
+	ErrorCode     int    // 错误码：服务端设置Error时对错误的分类，0表示没有分类，旧版本的对端不发送该字段
 }
 
 // Codec 对消息体进行编解码的接口
@@ -16,7 +29,24 @@ type Codec interface {
 	io.Closer
 	ReadHeader(*Header) error
 	ReadBody(interface{}) error
+	// Write 编码一帧，不带缓冲的实现直接写入连接，带缓冲的实现须调用Flush后才会发出
 	Write(*Header, interface{}) error
+	// Flush 发出已写入的完整帧，由调用方决定何时调用，不带缓冲的实现为空操作
+	Flush() error
+}
+
+// DeadlineCodec 可以为读写设置截止时间的Codec，截止时间之后阻塞的读写以超时错误返回
+// 连接不支持截止时间时（例如内存中的管道）设置为空操作，零值表示不设限
+type DeadlineCodec interface {
+	Codec
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// SizeCodec 可以报告写入连接的累计字节数的Codec，服务端据此在访问日志中记录响应大小
+type SizeCodec interface {
+	Codec
+	Written() int64
 }
 
 // NewCodecFun Codec的构造函数
@@ -30,10 +60,95 @@ const (
 	JsonType Type = "application/json"
 )
 
+// ID 类别的紧凑数字编号，握手时可以代替Type以减少字节数
+type ID uint8
+
+const (
+	GobID  ID = 0
+	JsonID ID = 1
+)
+
 // NewCodecFuncMap NewCodecFuncMao 类别和构造方法之间的映射
+// init之后只能通过RegisterCodec修改，与RegisterCodec并发时须用Lookup读取
 var NewCodecFuncMap map[Type]NewCodecFun
 
+// codecMu 保护运行时对NewCodecFuncMap的读写，见RegisterCodec
+var codecMu sync.RWMutex
+
+// NewCodecFuncByID 数字编号和构造方法之间的映射，与NewCodecFuncMap平行
+var NewCodecFuncByID map[ID]NewCodecFun
+
+// TypeByID 数字编号和类别之间的映射，注册新的Codec时与NewCodecFuncByID一起设置
+var TypeByID map[ID]Type
+
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFun)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+	NewCodecFuncByID = make(map[ID]NewCodecFun)
+	NewCodecFuncByID[GobID] = NewGobCodec
+	NewCodecFuncByID[JsonID] = NewJsonCodec
+	TypeByID = make(map[ID]Type)
+	TypeByID[GobID] = GobType
+	TypeByID[JsonID] = JsonType
+}
+
+// RegisterCodec 注册类别为t的Codec，可以在运行时由其他包调用，并发安全，不依赖包的初始化顺序
+// t已注册时返回错误，不会静默覆盖内置或先注册的Codec，确需替换时使用ForceRegisterCodec
+func RegisterCodec(t Type, f NewCodecFun) error {
+	return registerCodec(t, f, false)
+}
+
+// ForceRegisterCodec 与RegisterCodec相同，t已注册时覆盖，之后建立的连接使用新的构造函数
+func ForceRegisterCodec(t Type, f NewCodecFun) error {
+	return registerCodec(t, f, true)
+}
+
+func registerCodec(t Type, f NewCodecFun, force bool) error {
+	if t == "" || f == nil {
+		return fmt.Errorf("codec: invalid registration of type %q", t)
+	}
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if NewCodecFuncMap[t] != nil && !force {
+		return fmt.Errorf("codec: type %q already registered", t)
+	}
+	NewCodecFuncMap[t] = f
+	return nil
+}
+
+// Lookup 返回类别t的构造函数，未注册时返回nil
+func Lookup(t Type) NewCodecFun {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return NewCodecFuncMap[t]
+}
+
+// CheckOption 校验客户端Option中的Codec类别和超时，供client和loadBalance包的parseOptions共用
+// 所有错误合并为一个返回，没有对应字段的包传入0
+func CheckOption(t Type, connectTimeout, handleTimeout time.Duration) error {
+	var errs []string
+	if Lookup(t) == nil {
+		errs = append(errs, fmt.Sprintf("invalid codec type %q", t))
+	}
+	if connectTimeout < 0 {
+		errs = append(errs, fmt.Sprintf("negative connect timeout %s", connectTimeout))
+	}
+	if handleTimeout < 0 {
+		errs = append(errs, fmt.Sprintf("negative handle timeout %s", handleTimeout))
+	}
+	if len(errs) > 0 {
+		return errors.New("rpc client: invalid options: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// IDOf 返回类别的数字编号，类别没有编号时ok为false
+func IDOf(t Type) (id ID, ok bool) {
+	for id, typ := range TypeByID {
+		if typ == t && NewCodecFuncByID[id] != nil {
+			return id, true
+		}
+	}
+	return 0, false
 }
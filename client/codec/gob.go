@@ -3,20 +3,53 @@ package codec
 import (
 	"bufio"
 	"encoding/gob"
+	"encoding/json"
 	"io"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
-// GobCodec GobCodec结构体
+// BodyCodec 消息体的编解码方式，编码结果作为一个gob的[]byte值紧跟在帧头之后
+// 帧头总是由gob编码，新的消息体格式只需实现BodyCodec，不必关心分帧
+// Unmarshal返回后data会被下一帧复用，实现不能继续持有它
+type BodyCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// maxScratch 复用的消息体缓冲区的最大容量，更大的消息体读取后不保留缓冲区
+const maxScratch = 1 << 20
+
+// GobCodec 帧头用gob编码，消息体交给body编解码，body为nil时消息体也直接用gob编码
+// NewGobCodec和NewJsonCodec返回的都是*GobCodec，自定义的消息体格式由NewHeaderCodec构造
 type GobCodec struct {
 	conn io.ReadWriteCloser //通过TCP或UNIX建立socket时得到的链接实例
 	buf  *bufio.Writer      //为了防止阻塞而创建的带缓冲的Writer，提升性能
 	dec  *gob.Decoder       //gob的译码器
 	enc  *gob.Encoder       //gob的编码器
+	body BodyCodec          //消息体的编解码方式
+	data []byte             //读取body编码的消息体时复用的缓冲区，只在读取协程中使用
+	// written 已写入连接的字节数，见Written
+	written int64
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
 }
 
 // 目的是为了确保接口被实现调用。即利用强制类型转换，确保struct GobCodec实现了接口Codec。这样IDE和编译期间就可以检查，而不是等到使用的时候
 var _ Codec = (*GobCodec)(nil)
+var _ DeadlineCodec = (*GobCodec)(nil)
+var _ SizeCodec = (*GobCodec)(nil)
 
 // Close 实现连接关闭
 func (g *GobCodec) Close() error {
@@ -28,15 +61,29 @@ func (g *GobCodec) ReadHeader(h *Header) error {
 	return g.dec.Decode(h)
 }
 
-// ReadBody 读取请求体
+// ReadBody 读取请求体，body为nil时丢弃
+// 消息体由gob编码时，body为容量足够的*[]byte则直接解码进其底层数组，调用方可以复用缓冲区减少分配
 func (g *GobCodec) ReadBody(body interface{}) error {
-	return g.dec.Decode(body)
+	if g.body == nil {
+		return g.dec.Decode(body)
+	}
+	g.data = g.data[:0]
+	err := g.dec.Decode(&g.data)
+	if err == nil && body != nil {
+		err = g.body.Unmarshal(g.data, body)
+	}
+	if cap(g.data) > maxScratch {
+		g.data = nil
+	}
+	return err
 }
 
-func (g GobCodec) Write(h *Header, body interface{}) (err error) {
+// Write 将一帧编码进缓冲区，由Flush发出
+// 编码失败时丢弃缓冲区中已编码的部分并关闭连接，不会发出不完整的帧
+func (g *GobCodec) Write(h *Header, body interface{}) (err error) {
 	defer func() {
-		_ = g.buf.Flush()
 		if err != nil {
+			g.buf.Reset(countWriter{w: g.conn, n: &g.written})
 			_ = g.Close()
 		}
 	}()
@@ -44,8 +91,8 @@ func (g GobCodec) Write(h *Header, body interface{}) (err error) {
 		log.Println("rpc mainCodec: gob error encoding header:", err)
 		return err
 	}
-	if err := g.enc.Encode(body); err != nil {
-		log.Println("rpc mainCodec: gob error encoding body:", err)
+	if err := g.writeBody(body); err != nil {
+		log.Println("rpc mainCodec: error encoding body:", err)
 		return err
 	}
 
@@ -53,12 +100,74 @@ func (g GobCodec) Write(h *Header, body interface{}) (err error) {
 
 }
 
-func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
-	return &GobCodec{
-		conn: conn,
-		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
+func (g *GobCodec) writeBody(body interface{}) error {
+	if g.body == nil {
+		return g.enc.Encode(body)
+	}
+	data, err := g.body.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return g.enc.Encode(data)
+}
+
+// Flush 将缓冲区中的完整帧写入连接
+func (g *GobCodec) Flush() error {
+	return g.buf.Flush()
+}
+
+// Written 返回已写入连接的字节数，Flush之前缓冲区中的帧不计入
+func (g *GobCodec) Written() int64 {
+	return atomic.LoadInt64(&g.written)
+}
+
+// SetReadDeadline 设置连接的读截止时间，连接不支持时为空操作
+func (g *GobCodec) SetReadDeadline(t time.Time) error {
+	if c, ok := g.conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return c.SetReadDeadline(t)
 	}
+	return nil
+}
+
+// SetWriteDeadline 设置连接的写截止时间，作用于Flush，连接不支持时为空操作
+func (g *GobCodec) SetWriteDeadline(t time.Time) error {
+	if c, ok := g.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return c.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// NewHeaderCodec 返回帧头用gob编码、消息体用body编解码的Codec构造函数，可以用RegisterCodec注册
+func NewHeaderCodec(body BodyCodec) NewCodecFun {
+	return func(conn io.ReadWriteCloser) Codec {
+		g := &GobCodec{
+			conn: conn,
+			dec:  gob.NewDecoder(conn),
+			body: body,
+		}
+		g.buf = bufio.NewWriter(countWriter{w: conn, n: &g.written})
+		g.enc = gob.NewEncoder(g.buf)
+		return g
+	}
+}
+
+// NewGobCodec 帧头和消息体都用gob编码
+func NewGobCodec(conn io.ReadWriteCloser) Codec {
+	return NewHeaderCodec(nil)(conn)
+}
+
+// jsonBody 用JSON编码消息体
+type jsonBody struct{}
+
+func (jsonBody) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonBody) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// NewJsonCodec 帧头用gob编码，消息体用JSON编码
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return NewHeaderCodec(jsonBody{})(conn)
 }
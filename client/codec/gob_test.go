@@ -0,0 +1,303 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// recorder records what is written to the connection.
+type recorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (r *recorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestGobCodec_Flush(t *testing.T) {
+	t.Parallel()
+	conn := &recorder{}
+	cc := NewGobCodec(conn)
+	if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 3); err != nil {
+		t.Fatal("failed to write:", err)
+	}
+	if conn.Len() != 0 {
+		t.Fatalf("expect nothing written before Flush, got %d bytes", conn.Len())
+	}
+	if err := cc.Flush(); err != nil {
+		t.Fatal("failed to flush:", err)
+	}
+	if n := cc.(SizeCodec).Written(); n != int64(conn.Len()) {
+		t.Fatalf("expect %d bytes written, got %d", conn.Len(), n)
+	}
+
+	r := NewGobCodec(struct {
+		io.Reader
+		io.WriteCloser
+	}{&conn.Buffer, conn})
+	var h Header
+	var body int
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("failed to read header: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&body); err != nil || body != 3 {
+		t.Fatalf("failed to read body: %v %d", err, body)
+	}
+}
+
+func TestGobCodec_WriteError(t *testing.T) {
+	t.Parallel()
+	conn := &recorder{}
+	cc := NewGobCodec(conn)
+	// the header is encoded but the body can't be
+	if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, func() {}); err == nil {
+		t.Fatal("expect an encoding error")
+	}
+	_ = cc.Flush()
+	if conn.Len() != 0 || !conn.closed {
+		t.Fatalf("expect no partial frame and a closed connection, got %d bytes", conn.Len())
+	}
+}
+
+func TestGobCodec_WriteDeadline(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		// accept but never read, so that the socket buffers fill up
+		conn, err := l.Accept()
+		if err == nil {
+			defer func() { _ = conn.Close() }()
+			time.Sleep(time.Second * 2)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := NewGobCodec(conn).(DeadlineCodec)
+	defer func() { _ = cc.Close() }()
+	if err := cc.SetWriteDeadline(time.Now().Add(time.Millisecond * 100)); err != nil {
+		t.Fatal("failed to set the deadline:", err)
+	}
+	body := make([]byte, 1<<20)
+	start := time.Now()
+	for err == nil && time.Since(start) < time.Second {
+		if err = cc.Write(&Header{ServiceMethod: "Foo.Sum"}, body); err == nil {
+			err = cc.Flush()
+		}
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("expect the slow write to time out, got %v", err)
+	}
+}
+
+func TestGobCodec_DeadlineUnsupported(t *testing.T) {
+	t.Parallel()
+	cc := NewGobCodec(&recorder{}).(DeadlineCodec)
+	if cc.SetReadDeadline(time.Now()) != nil || cc.SetWriteDeadline(time.Now()) != nil {
+		t.Fatal("expect deadlines to be a no-op without support from the connection")
+	}
+}
+
+func TestGobCodec_Exported(t *testing.T) {
+	t.Parallel()
+	for _, f := range []NewCodecFun{NewGobCodec, NewJsonCodec, NewHeaderCodec(jsonBody{})} {
+		if _, ok := f(&recorder{}).(*GobCodec); !ok {
+			t.Fatal("expect the built-in codecs to be a *GobCodec")
+		}
+	}
+}
+
+func TestJsonCodec_GobHeader(t *testing.T) {
+	t.Parallel()
+	type Args struct{ Num1, Num2 int }
+	conn := &recorder{}
+	cc := NewJsonCodec(conn)
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: seq}, Args{Num1: 1, Num2: int(seq)}); err != nil {
+			t.Fatal("failed to write:", err)
+		}
+	}
+	_ = cc.Flush()
+	wire := conn.Bytes()
+
+	// the header is plain gob, the body a gob []byte holding JSON
+	dec := gob.NewDecoder(bytes.NewReader(wire))
+	var h Header
+	var data []byte
+	if err := dec.Decode(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("failed to decode the header with gob: %v %+v", err, h)
+	}
+	if err := dec.Decode(&data); err != nil || string(data) != `{"Num1":1,"Num2":1}` {
+		t.Fatalf("expect a JSON body, got %q %v", data, err)
+	}
+
+	r := NewJsonCodec(struct {
+		io.Reader
+		io.WriteCloser
+	}{bytes.NewReader(wire), conn})
+	var args Args
+	if err := r.ReadHeader(&h); err != nil {
+		t.Fatal("failed to read header:", err)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatal("failed to discard body:", err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("failed to read header: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&args); err != nil || args.Num2 != 2 {
+		t.Fatalf("failed to read body: %v %+v", err, args)
+	}
+}
+
+// repeater replays head once, then frame forever.
+type repeater struct {
+	head, frame []byte
+	off         int
+}
+
+func (r *repeater) Read(p []byte) (int, error) {
+	if len(r.head) > 0 {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	n := copy(p, r.frame[r.off:])
+	r.off = (r.off + n) % len(r.frame)
+	return n, nil
+}
+
+func (r *repeater) Write(p []byte) (int, error) { return len(p), nil }
+func (r *repeater) Close() error                { return nil }
+
+// newRepeater returns a connection serving endless frames carrying
+// a 64KB []byte body encoded by newCodec.
+func newRepeater(b *testing.B, newCodec NewCodecFun) *repeater {
+	conn := &recorder{}
+	cc := newCodec(conn)
+	payload := make([]byte, 64<<10)
+	var frames [][]byte
+	for i := 0; i < 2; i++ {
+		if err := cc.Write(&Header{ServiceMethod: "Blob.Get", Seq: 1}, payload); err != nil {
+			b.Fatal(err)
+		}
+		_ = cc.Flush()
+		frames = append(frames, append([]byte(nil), conn.Bytes()...))
+		conn.Reset()
+	}
+	// the first frame carries the gob type definitions
+	return &repeater{head: frames[0], frame: frames[1]}
+}
+
+func BenchmarkReadBody_Bytes(b *testing.B) {
+	for _, c := range []struct {
+		name     string
+		newCodec NewCodecFun
+		reuse    bool
+	}{
+		{"gob/alloc", NewGobCodec, false},
+		{"gob/reuse", NewGobCodec, true},
+		{"json/alloc", NewJsonCodec, false},
+		{"json/reuse", NewJsonCodec, true},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			cc := c.newCodec(newRepeater(b, c.newCodec))
+			var h Header
+			buf := make([]byte, 0, 64<<10)
+			b.ReportAllocs()
+			b.SetBytes(64 << 10)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !c.reuse {
+					buf = nil
+				}
+				if err := cc.ReadHeader(&h); err != nil {
+					b.Fatal(err)
+				}
+				if err := cc.ReadBody(&buf); err != nil || len(buf) != 64<<10 {
+					b.Fatal(err, len(buf))
+				}
+			}
+		})
+	}
+}
+
+// oldHeader is Header as sent by peers without ErrorCode.
+type oldHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+func TestCodec_ErrorCode(t *testing.T) {
+	t.Parallel()
+	for _, newCodec := range []NewCodecFun{NewGobCodec, NewJsonCodec} {
+		conn := &recorder{}
+		cc := newCodec(struct {
+			io.Reader
+			io.WriteCloser
+		}{&conn.Buffer, conn})
+		_ = cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1, Error: "not found", ErrorCode: 2}, struct{}{})
+		_ = cc.Flush()
+		var h Header
+		if err := cc.ReadHeader(&h); err != nil || h.ErrorCode != 2 || h.Error != "not found" {
+			t.Fatalf("expect the error code to round-trip: %v %+v", err, h)
+		}
+		if err := cc.ReadBody(nil); err != nil {
+			t.Fatal("failed to read body:", err)
+		}
+	}
+
+	// headers are gob encoded by both codecs, missing fields decode as zero
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	_ = enc.Encode(&oldHeader{ServiceMethod: "Foo.Sum", Seq: 1, Error: "failed"})
+	var h Header
+	if err := gob.NewDecoder(&buf).Decode(&h); err != nil || h.ErrorCode != 0 || h.Error != "failed" {
+		t.Fatalf("expect an old header to decode without a code: %v %+v", err, h)
+	}
+	_ = enc.Encode(&Header{ServiceMethod: "Foo.Sum", Seq: 2, ErrorCode: 4})
+	var old oldHeader
+	if err := gob.NewDecoder(&buf).Decode(&old); err != nil || old.Seq != 2 {
+		t.Fatalf("expect an old peer to ignore the code: %v %+v", err, old)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	t.Parallel()
+	if err := RegisterCodec(GobType, NewJsonCodec); err == nil {
+		t.Fatal("expect a built-in codec not to be overwritten")
+	}
+	if Lookup(GobType) == nil || Lookup(JsonType) == nil {
+		t.Fatal("expect the built-in codecs to stay registered")
+	}
+	custom := Type("application/x-register-test")
+	if err := RegisterCodec(custom, NewJsonCodec); err != nil {
+		t.Fatal("failed to register:", err)
+	}
+	if err := RegisterCodec(custom, NewGobCodec); err == nil {
+		t.Fatal("expect a registered codec not to be overwritten")
+	}
+	if err := ForceRegisterCodec(custom, NewGobCodec); err != nil {
+		t.Fatal("failed to force the registration:", err)
+	}
+	if err := RegisterCodec("", NewGobCodec); err == nil {
+		t.Fatal("expect an empty type to be rejected")
+	}
+	if err := RegisterCodec("application/x-nil", nil); err == nil || Lookup("application/x-nil") != nil {
+		t.Fatal("expect a nil constructor to be rejected")
+	}
+}
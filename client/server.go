@@ -76,7 +76,7 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
 		return
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
+	f := codec.Lookup(opt.CodecType)
 	if f == nil {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
 		return
@@ -139,7 +139,11 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 func (server Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
+	err := cc.Write(h, body)
+	if err == nil {
+		err = cc.Flush()
+	}
+	if err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
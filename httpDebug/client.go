@@ -119,7 +119,11 @@ func (client *Client) send(call *Call) {
 	client.header.Error = ""
 
 	// encode and send the request
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	err = client.cc.Write(&client.header, call.Args)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
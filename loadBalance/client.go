@@ -119,7 +119,11 @@ func (client *Client) send(call *Call) {
 	client.header.Error = ""
 
 	// encode and send the request
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	err = client.cc.Write(&client.header, call.Args)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
@@ -198,12 +202,18 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
-	opt.MagicNumber = DefaultOption.MagicNumber
+	// copy the option so that the caller's one is never modified
+	opt := *opts[0]
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = DefaultOption.MagicNumber
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	if err := codec.CheckOption(opt.CodecType, opt.ConnectTimeout, opt.HandleTimeout); err != nil {
+		return nil, err
+	}
+	return &opt, nil
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -68,6 +69,34 @@ func TestClient_Call(t *testing.T) {
 	})
 }
 
+func TestDial_SharedOption(t *testing.T) {
+	// not parallel: parallel tests only start after the serial ones, and TestXDial below can block
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+
+	opt := &Option{HandleTimeout: time.Second}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := Dial("tcp", addr, opt)
+			_assert(err == nil, "failed to dial: %v", err)
+			_ = client.Close()
+		}()
+	}
+	wg.Wait()
+	_assert(*opt == Option{HandleTimeout: time.Second}, "caller's option was modified: %+v", *opt)
+
+	_, err := Dial("tcp", addr, &Option{CodecType: "application/unknown", ConnectTimeout: -time.Second})
+	_assert(err != nil && strings.Contains(err.Error(), "application/unknown") && strings.Contains(err.Error(), "negative connect timeout"),
+		"expect both validation errors, got %v", err)
+
+	got, err := parseOptions(&Option{MagicNumber: 0x5eed})
+	_assert(err == nil && got.MagicNumber == 0x5eed, "expect a non-zero magic number to be kept, got %+v", got)
+}
+
 func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" {
 		ch := make(chan struct{})
@@ -167,7 +167,11 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 func (server Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
+	err := cc.Write(h, body)
+	if err == nil {
+		err = cc.Flush()
+	}
+	if err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrUnauthenticated 服务端的Authenticate拒绝了握手时的AuthToken或调用携带的令牌
+var ErrUnauthenticated = errors.New("rpc: unauthenticated")
+
+// ErrInsecureToken 连接不是TLS连接且未设置Option.AllowInsecureAuth时，客户端拒绝发送令牌
+var ErrInsecureToken = errors.New("rpc client: refusing to send a token over an insecure connection")
+
+// AuthFunc 握手时校验Option.AuthToken的钩子，remoteAddr为客户端地址，连接不能提供时为nil
+// 返回的身份保存在连接上，见Server.SetAuthFunc
+type AuthFunc func(token string, remoteAddr net.Addr) (identity string, err error)
+
+// identityKey 在连接上下文中保存AuthFunc返回的身份的键
+type identityKey struct{}
+
+// SetAuthFunc 设置握手时的校验钩子，须在开始服务之前设置，f为nil时取消
+// ServeConn读取Option后调用f，返回错误时握手失败并关闭连接，客户端的Dial返回ErrUnauthenticated
+// 成功时返回的身份在该连接的请求中可以通过IdentityFromContext取得，也写入访问日志
+// f中需要客户端证书时，在Authenticate中用CommonNameFromContext取得
+// 与Authenticate可以同时设置，f先被调用，Authenticate的ctx中已有身份
+func (server *Server) SetAuthFunc(f AuthFunc) {
+	server.authFunc = f
+}
+
+// IdentityFromContext 返回SetAuthFunc设置的钩子为连接返回的身份
+// 没有设置钩子时为经过校验的客户端证书的CommonName，都没有时ok为false，ctx须来自接收context.Context的服务方法
+func IdentityFromContext(ctx context.Context) (identity string, ok bool) {
+	identity, ok = ctx.Value(identityKey{}).(string)
+	return
+}
+
+// authorize 调用SetAuthFunc设置的钩子，成功时返回保存了身份的ctx
+// 未设置钩子时以客户端证书的CommonName为身份，没有证书时ctx不变
+func (server *Server) authorize(ctx context.Context, conn io.ReadWriteCloser, token string) (context.Context, error) {
+	if server.authFunc == nil {
+		if cn, ok := CommonNameFromContext(ctx); ok {
+			return context.WithValue(ctx, identityKey{}, cn), nil
+		}
+		return ctx, nil
+	}
+	var addr net.Addr
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		addr = c.RemoteAddr()
+	}
+	identity, err := server.authFunc(token, addr)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, identityKey{}, identity), nil
+}
+
+// callTokenKey 在调用方上下文中保存单次调用令牌的键
+type callTokenKey struct{}
+
+// WithCallToken 返回携带令牌的ctx，Client.Call使用它发起的调用都会附带该令牌
+// 服务端用Authenticate校验，校验失败的调用返回ErrUnauthenticated对应的错误，连接不受影响
+func WithCallToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, callTokenKey{}, token)
+}
+
+func callToken(ctx context.Context) string {
+	token, _ := ctx.Value(callTokenKey{}).(string)
+	return token
+}
+
+// isSecure 连接是否为TLS连接
+func isSecure(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// checkToken 令牌只能通过TLS连接发送，除非显式设置了AllowInsecureAuth
+func checkToken(token string, secure bool, opt *Option) error {
+	if token != "" && !secure && !opt.AllowInsecureAuth {
+		return ErrInsecureToken
+	}
+	return nil
+}
+
+// authenticate 调用服务端的校验钩子，未设置Authenticate时接受所有连接
+func (server *Server) authenticate(ctx context.Context, token string) error {
+	if server.Authenticate == nil {
+		return nil
+	}
+	return server.Authenticate(ctx, token)
+}
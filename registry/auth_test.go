@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func startAuthServer(lis func(l net.Listener) net.Listener) string {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.Authenticate = func(ctx context.Context, token string) error {
+		if token != "secret" && token != "call-token" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(lis(l))
+	return l.Addr().String()
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	t.Parallel()
+	addr := startAuthServer(func(l net.Listener) net.Listener { return l })
+
+	client, err := Dial("tcp", addr, &Option{AuthToken: "secret", AllowInsecureAuth: true})
+	_assert(err == nil, "failed to dial with a good token: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	_, err = Dial("tcp", addr, &Option{AuthToken: "wrong", AllowInsecureAuth: true})
+	_assert(err == ErrUnauthenticated, "expect ErrUnauthenticated for a bad token, got %v", err)
+	_, err = Dial("tcp", addr)
+	_assert(err == ErrUnauthenticated, "expect ErrUnauthenticated for a missing token, got %v", err)
+	_, err = Dial("tcp", addr, &Option{AuthToken: "secret"})
+	_assert(err == ErrInsecureToken, "expect ErrInsecureToken over plain TCP, got %v", err)
+
+	// per-call tokens
+	err = client.Call(WithCallToken(context.Background(), "call-token"), "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+	_assert(err == nil && reply == 2, "failed to call with a good token: %v", err)
+	err = client.Call(WithCallToken(context.Background(), "wrong"), "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), ErrUnauthenticated.Error()), "expect the call to be rejected, got %v", err)
+	_assert(client.IsAvailable(), "expect the client to stay usable")
+}
+
+func TestServer_AuthenticateTLS(t *testing.T) {
+	t.Parallel()
+	ca := newCert("test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newCert("server", &ca)
+	addr := startAuthServer(func(l net.Listener) net.Listener {
+		return tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	})
+
+	client, err := DialWith("tcp", addr, WithTLS(&tls.Config{RootCAs: pool}), WithAuthToken("secret", false))
+	_assert(err == nil, "failed to dial over TLS: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(WithCallToken(context.Background(), "call-token"), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+}
+
+func TestClient_InsecureCallToken(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(WithCallToken(context.Background(), "secret"), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == ErrInsecureToken, "expect ErrInsecureToken, got %v", err)
+}
+
+type AuthIdentity int
+
+func (i AuthIdentity) Get(ctx context.Context, _ int, reply *string) error {
+	*reply, _ = IdentityFromContext(ctx)
+	return nil
+}
+
+func TestServer_SetAuthFunc(t *testing.T) {
+	t.Parallel()
+	start := func(f AuthFunc) string {
+		server := NewServer()
+		_ = server.Register(new(AuthIdentity))
+		server.SetAuthFunc(f)
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go server.Accept(l)
+		return l.Addr().String()
+	}
+	remotes := make(chan net.Addr, 1)
+	addr := start(func(token string, remoteAddr net.Addr) (string, error) {
+		if token != "secret" {
+			return "", errors.New("bad token")
+		}
+		remotes <- remoteAddr
+		return "alice", nil
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{AuthToken: "secret", AllowInsecureAuth: true})
+		_assert(err == nil, "failed to dial with a good token: %v", err)
+		defer func() { _ = client.Close() }()
+		remote := <-remotes
+		_assert(remote != nil && strings.HasPrefix(remote.String(), "127.0.0.1:"), "expect the client address, got %v", remote)
+		var identity string
+		err = client.Call(context.Background(), "AuthIdentity.Get", 0, &identity)
+		_assert(err == nil && identity == "alice", "expect the identity on the connection, got %q (%v)", identity, err)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &Option{AuthToken: "wrong", AllowInsecureAuth: true})
+		_assert(err == ErrUnauthenticated, "expect ErrUnauthenticated for a bad token, got %v", err)
+		_, err = Dial("tcp", addr)
+		_assert(err == ErrUnauthenticated, "expect ErrUnauthenticated for a missing token, got %v", err)
+	})
+
+	t.Run("no hook", func(t *testing.T) {
+		client, err := Dial("tcp", start(nil))
+		_assert(err == nil, "failed to dial without a hook: %v", err)
+		defer func() { _ = client.Close() }()
+		var identity string
+		err = client.Call(context.Background(), "AuthIdentity.Get", 0, &identity)
+		_assert(err == nil && identity == "", "expect no identity without a hook, got %q (%v)", identity, err)
+	})
+}
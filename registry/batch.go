@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"context"
+	"errors"
+)
+
+// BatchCall is one call of a batch sent by CallBatch. Error holds the
+// outcome of the call once CallBatch returns.
+type BatchCall struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{} // nil to discard the response body, as with Go
+	Error         error
+}
+
+// CallBatch sends all calls through Go without waiting for their replies,
+// so that the requests are pipelined on the connection, then waits for
+// all of them on a single Done channel. The outcome of each call is set
+// in its Error field, and CallBatch returns the first error in the order
+// of calls. If ctx is done first, the calls still waiting for a reply are
+// abandoned and fail with the error of ctx.
+func (client *Client) CallBatch(ctx context.Context, calls []BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	done := make(chan *Call, len(calls))
+	index := make(map[*Call]int, len(calls))
+	for i := range calls {
+		bc := &calls[i]
+		index[client.Go(bc.ServiceMethod, bc.Args, bc.Reply, done)] = i
+	}
+	for len(index) > 0 {
+		select {
+		case call := <-done:
+			calls[index[call]].Error = call.Error
+			delete(index, call)
+		case <-ctx.Done():
+			err := errors.New("rpc client: call failed: " + ctx.Err().Error())
+			for call, i := range index {
+				client.removeCall(call.Seq)
+				calls[i].Error = err
+			}
+			index = nil
+		}
+	}
+	for i := range calls {
+		if calls[i].Error != nil {
+			return calls[i].Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_CallBatch(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(new(Shapes))
+	_ = server.Register(new(Slow))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	replies := make([]int, 10)
+	calls := make([]BatchCall, len(replies))
+	for i := range calls {
+		calls[i] = BatchCall{ServiceMethod: "Foo.Sum", Args: Args{Num1: i, Num2: i}, Reply: &replies[i]}
+	}
+	err := client.CallBatch(context.Background(), calls)
+	_assert(err == nil, "failed to call the batch: %v", err)
+	for i, reply := range replies {
+		_assert(calls[i].Error == nil && reply == 2*i, "expect %d for call %d, got %d (%v)", 2*i, i, reply, calls[i].Error)
+	}
+
+	// per-call errors don't stop the others
+	var sum int
+	calls = []BatchCall{
+		{ServiceMethod: "Foo.Sum", Args: Args{Num1: 1, Num2: 2}, Reply: &sum},
+		{ServiceMethod: "Shapes.Fail", Args: 0},
+	}
+	err = client.CallBatch(context.Background(), calls)
+	_assert(err != nil && err == calls[1].Error, "expect the failed call's error, got %v", err)
+	_assert(calls[0].Error == nil && sum == 3, "expect the other call to succeed, got %d (%v)", sum, calls[0].Error)
+
+	// a done ctx abandons the calls still waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls = []BatchCall{{ServiceMethod: "Slow.Sleep", Args: 1000, Reply: new(int)}}
+	start := time.Now()
+	err = client.CallBatch(ctx, calls)
+	_assert(err != nil && strings.Contains(err.Error(), "deadline exceeded"), "expect the ctx error, got %v", err)
+	_assert(time.Since(start) < 500*time.Millisecond, "expect CallBatch to return with ctx")
+}
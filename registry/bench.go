@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BenchResult summarizes a Benchmark run.
+type BenchResult struct {
+	Calls      int           // calls made
+	Errors     int           // calls that returned an error
+	Duration   time.Duration // wall time of the whole run
+	Throughput float64       // calls per second
+	P50        time.Duration // latency percentiles, errors included
+	P95        time.Duration
+	P99        time.Duration
+}
+
+// Benchmark makes total calls to serviceMethod through client from
+// concurrency goroutines and reports their latencies. argsFn returns the
+// args of the i-th call, replyFn a fresh reply for each call.
+func Benchmark(client *Client, serviceMethod string, argsFn func(i int) interface{}, replyFn func() interface{},
+	concurrency, total int) (BenchResult, error) {
+	if concurrency <= 0 || total < 0 {
+		return BenchResult{}, errors.New("rpc client: benchmark needs a positive concurrency and a non-negative total")
+	}
+	latencies := make([]time.Duration, total)
+	errs := make([]bool, total)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				var reply interface{}
+				if replyFn != nil {
+					reply = replyFn()
+				}
+				t := time.Now()
+				err := client.Call(context.Background(), serviceMethod, argsFn(i), reply)
+				latencies[i], errs[i] = time.Since(t), err != nil
+			}
+		}()
+	}
+	for i := 0; i < total; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	r := BenchResult{Calls: total, Duration: time.Since(start)}
+	for _, failed := range errs {
+		if failed {
+			r.Errors++
+		}
+	}
+	if r.Duration > 0 {
+		r.Throughput = float64(total) / r.Duration.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50, r.P95, r.P99 = percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99)
+	return r, nil
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
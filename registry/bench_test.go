@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBenchmark(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	args := func(i int) interface{} { return Args{Num1: i, Num2: i} }
+	reply := func() interface{} { return new(int) }
+	r, err := Benchmark(client, "Foo.Sum", args, reply, 8, 200)
+	_assert(err == nil, "failed to run the benchmark: %v", err)
+	_assert(r.Calls == 200 && r.Errors == 0, "expect 200 calls without errors, got %+v", r)
+	_assert(r.P50 > 0 && r.P50 <= r.P95 && r.P95 <= r.P99 && r.Throughput > 0, "unexpected result %+v", r)
+
+	r, _ = Benchmark(client, "Foo.Unknown", args, reply, 2, 10)
+	_assert(r.Errors == 10, "expect every call to fail, got %+v", r)
+	_, err = Benchmark(client, "Foo.Sum", args, reply, 0, 10)
+	_assert(err != nil, "expect an error for zero concurrency")
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	_assert(percentile(sorted, 50) == 50 && percentile(sorted, 99) == 99 && percentile(sorted, 100) == 100, "unexpected percentiles")
+	_assert(percentile(nil, 50) == 0, "expect 0 for no samples")
+}
+
+// benchmarkDispatch 测量服务端从创建参数到调用方法的开销，不含编解码和网络
+func benchmarkDispatch(b *testing.B, server *Server, serviceMethod string) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.callContext(ctx, mtype, mtype.newArgv(), mtype.newReplyv()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDispatch_Reflective(b *testing.B) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	benchmarkDispatch(b, server, "Foo.Sum")
+}
+
+func BenchmarkDispatch_Direct(b *testing.B) {
+	server := NewServer()
+	_ = server.RegisterHandler("Foo.Sum", func() interface{} { return new(Args) }, func(args interface{}) (interface{}, error) {
+		a := args.(*Args)
+		return a.Num1 + a.Num2, nil
+	})
+	benchmarkDispatch(b, server, "Foo.Sum")
+}
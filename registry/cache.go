@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the number of replies a client caches
+// unless WithCacheSize is given.
+const DefaultCacheSize = 1024
+
+// cacheRule configures the caching of one method.
+type cacheRule struct {
+	ttl   time.Duration
+	keyFn func(args interface{}) string
+}
+
+type cacheEntry struct {
+	key     string
+	reply   reflect.Value // private copy of the reply, never handed out
+	expires time.Time
+}
+
+// responseCache keeps the successful replies of the methods configured
+// by WithCache, evicting the least recently used ones beyond maxEntries.
+type responseCache struct {
+	mu         sync.Mutex
+	rules      map[string]cacheRule
+	maxEntries int
+	ll         *list.List // front is the most recently used
+	entries    map[string]*list.Element
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		rules:      make(map[string]cacheRule),
+		maxEntries: DefaultCacheSize,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// WithCache caches the successful replies of serviceMethod for ttl.
+// Calls are told apart by keyFn(args), or by their encoded args if keyFn
+// is nil. Only Call uses the cache, and only for idempotent methods
+// should it be enabled.
+func WithCache(serviceMethod string, ttl time.Duration, keyFn func(args interface{}) string) DialOption {
+	return func(opt *Option) error {
+		if ttl <= 0 {
+			return fmt.Errorf("non-positive cache ttl %s for %s", ttl, serviceMethod)
+		}
+		opt.cache = opt.cache.clone()
+		opt.cache.rules[serviceMethod] = cacheRule{ttl: ttl, keyFn: keyFn}
+		return nil
+	}
+}
+
+// WithCacheSize bounds the number of replies cached by WithCache,
+// DefaultCacheSize by default.
+func WithCacheSize(n int) DialOption {
+	return func(opt *Option) error {
+		if n <= 0 {
+			return fmt.Errorf("non-positive cache size %d", n)
+		}
+		opt.cache = opt.cache.clone()
+		opt.cache.maxEntries = n
+		return nil
+	}
+}
+
+// clone returns an empty cache with the same configuration, so that
+// options derived from one another never share cached replies.
+func (c *responseCache) clone() *responseCache {
+	n := newResponseCache()
+	if c != nil {
+		for method, rule := range c.rules {
+			n.rules[method] = rule
+		}
+		n.maxEntries = c.maxEntries
+	}
+	return n
+}
+
+// key returns the cache key of a call, ok is false if the method
+// is not cached or its args can't be encoded.
+func (c *responseCache) key(serviceMethod string, args, reply interface{}) (key string, rule cacheRule, ok bool) {
+	if c == nil || discardsReply(reply) {
+		return "", rule, false
+	}
+	if rule, ok = c.rules[serviceMethod]; !ok {
+		return "", rule, false
+	}
+	var k string
+	if rule.keyFn != nil {
+		k = rule.keyFn(args)
+	} else {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return "", rule, false
+		}
+		k = string(b)
+	}
+	return fmt.Sprintf("%s|%T|%s", serviceMethod, reply, k), rule, true
+}
+
+// get copies the cached reply of the call into reply, if any.
+func (c *responseCache) get(serviceMethod string, args, reply interface{}) bool {
+	key, _, ok := c.key(serviceMethod, args, reply)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return false
+	}
+	c.ll.MoveToFront(e)
+	deepCopy(reflect.ValueOf(reply).Elem(), entry.reply)
+	return true
+}
+
+// put caches a copy of reply.
+func (c *responseCache) put(serviceMethod string, args, reply interface{}) {
+	key, rule, ok := c.key(serviceMethod, args, reply)
+	if !ok {
+		return
+	}
+	v := reflect.New(reflect.ValueOf(reply).Elem().Type()).Elem()
+	deepCopy(v, reflect.ValueOf(reply).Elem())
+	entry := &cacheEntry{key: key, reply: v, expires: time.Now().Add(rule.ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+	}
+}
+
+// remove drops an entry, c.mu must be held.
+func (c *responseCache) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startCacheServer() (*Server, string) {
+	var s Store
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func TestClient_CacheExpiry(t *testing.T) {
+	t.Parallel()
+	server, addr := startCacheServer()
+	client, err := DialWith("tcp", addr, WithCache("Store.List", time.Millisecond*500, nil))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var a, b []string
+	_ = client.Call(context.Background(), "Store.List", "k", &a)
+	_ = client.Call(context.Background(), "Store.List", "k", &b)
+	_assert(len(b) == 2 && b[0] == "k1", "unexpected cached reply %v", b)
+	_assert(numCalls(server, "Store", "List") == 1, "expect 1 call on server, got %d", numCalls(server, "Store", "List"))
+	// the cached reply is never shared
+	b[0] = "changed"
+	var c []string
+	_ = client.Call(context.Background(), "Store.List", "k", &c)
+	_assert(a[0] == "k1" && c[0] == "k1", "replies share memory: %v %v", a, c)
+
+	time.Sleep(time.Millisecond * 600)
+	_ = client.Call(context.Background(), "Store.List", "k", &c)
+	_assert(numCalls(server, "Store", "List") == 2, "expect the entry to expire, got %d calls", numCalls(server, "Store", "List"))
+
+	// errors and methods not configured are not cached
+	_ = client.Call(context.Background(), "Store.Fail", "k", new(string))
+	_ = client.Call(context.Background(), "Store.Fail", "k", new(string))
+	_assert(numCalls(server, "Store", "Fail") == 2, "expect 2 calls on server, got %d", numCalls(server, "Store", "Fail"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Call(ctx, "Store.List", "k", &c)
+	_assert(err != nil, "expect a cached call to respect ctx")
+}
+
+func TestClient_CacheEviction(t *testing.T) {
+	t.Parallel()
+	server, addr := startCacheServer()
+	keyFn := func(args interface{}) string { return args.(string) }
+	client, err := DialWith("tcp", addr, WithCache("Store.Get", time.Minute, keyFn), WithCacheSize(2))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		_ = client.Call(context.Background(), "Store.Get", key, &reply)
+		_assert(reply == "value of "+key, "unexpected reply %q for %s", reply, key)
+	}
+	// b is evicted by c, as a was used more recently
+	_assert(numCalls(server, "Store", "Get") == 4, "expect 4 calls on server, got %d", numCalls(server, "Store", "Get"))
+
+	_, err = DialWith("tcp", addr, WithCache("Store.Get", 0, nil), WithCacheSize(0))
+	_assert(err != nil, "expect invalid cache options to be rejected")
+}
+
+func TestClient_CacheConcurrentReaders(t *testing.T) {
+	t.Parallel()
+	server, addr := startCacheServer()
+	client, err := DialWith("tcp", addr, WithCache("Store.List", time.Minute, nil))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var first []string
+	_ = client.Call(context.Background(), "Store.List", "k", &first)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply []string
+			err := client.Call(context.Background(), "Store.List", "k", &reply)
+			_assert(err == nil && reply[1] == "k2", "unexpected cached reply %v: %v", reply, err)
+			reply[1] = "changed"
+		}()
+	}
+	wg.Wait()
+	_assert(numCalls(server, "Store", "List") == 1, "expect 1 call on server, got %d", numCalls(server, "Store", "List"))
+}
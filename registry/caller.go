@@ -0,0 +1,15 @@
+package registry
+
+import "context"
+
+// Caller is the part of Client that application code calls through.
+// Depend on it instead of *Client so that tests can substitute a fake,
+// see package clienttest. *Client and xclient.XClient implement it.
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+	Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call
+	Close() error
+	IsAvailable() bool
+}
+
+var _ Caller = (*Client)(nil)
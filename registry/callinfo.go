@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"context"
+	"time"
+)
+
+// CallInfo tells where a call spent its time. Zero times mean the call
+// never reached that point, e.g. Received for a call that timed out.
+// It is complete once the call is done.
+type CallInfo struct {
+	Server   string    // the server that served the call, set by XClient
+	Enqueued time.Time // when Go or Call was called
+	Sent     time.Time // when the request was registered and written
+	Received time.Time // when the response arrived
+}
+
+// Queued returns how long the call waited before it was sent.
+func (info CallInfo) Queued() time.Duration {
+	if info.Sent.IsZero() {
+		return 0
+	}
+	return info.Sent.Sub(info.Enqueued)
+}
+
+// RoundTrip returns how long the call was on the wire.
+func (info CallInfo) RoundTrip() time.Duration {
+	if info.Received.IsZero() {
+		return 0
+	}
+	return info.Received.Sub(info.Sent)
+}
+
+// callInfoKey holds the *CallInfo to fill in, see WithCallInfo.
+type callInfoKey struct{}
+
+// WithCallInfo returns a copy of ctx that makes Call record the timings
+// of the call into info. info may be read once Call returns.
+func WithCallInfo(ctx context.Context, info *CallInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, info)
+}
+
+func callInfo(ctx context.Context) *CallInfo {
+	info, _ := ctx.Value(callInfoKey{}).(*CallInfo)
+	return info
+}
@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCallInfo(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	monotonic := func(info CallInfo) bool {
+		return !info.Enqueued.IsZero() && !info.Sent.Before(info.Enqueued) && !info.Received.Before(info.Sent)
+	}
+
+	var reply int
+	call := <-client.Go("Slow.Sleep", 20, &reply, nil).Done
+	_assert(call.Error == nil && monotonic(call.Info), "unexpected info %+v: %v", call.Info, call.Error)
+	_assert(call.Info.RoundTrip() >= 20*time.Millisecond, "expect the round trip to include the handler, got %s", call.Info.RoundTrip())
+
+	var info CallInfo
+	err := client.Call(WithCallInfo(context.Background(), &info), "Slow.Sleep", 1, &reply)
+	_assert(err == nil && monotonic(info), "unexpected info %+v: %v", info, err)
+
+	client.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		return next(ctx, serviceMethod, args, reply)
+	})
+	call = <-client.Go("Slow.Sleep", 1, &reply, nil).Done
+	_assert(call.Error == nil && monotonic(call.Info), "unexpected info through interceptors %+v: %v", call.Info, call.Error)
+}
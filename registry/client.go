@@ -3,6 +3,7 @@ package registry
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,23 +12,43 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Call represents an active RPC.
 type Call struct {
 	Seq           uint64
-	ServiceMethod string      // format "<service>.<method>"
-	Args          interface{} // arguments to the function
-	Reply         interface{} // reply from the function
-	Error         error       // if error occurs, it will be set
-	Done          chan *Call  // Strobes when call is complete.
+	ServiceMethod string        // format "<service>.<method>"
+	Args          interface{}   // arguments to the function
+	Reply         interface{}   // reply from the function, nil to discard it
+	Error         error         // if error occurs, it will be set
+	Done          chan *Call    // Strobes when call is complete.
+	Info          CallInfo      // timings of the call, complete once Done strobes
+	TraceID       string        // sent with the request, see WithTraceID
+	token         string        // per-call token, see WithCallToken
+	priority      uint8         // see WithPriority
+	deadline      time.Time     // of the caller's ctx, sent as Header.Timeout
+	items         reflect.Value // channel of a streaming call, see GoStream
+	itemsMu       sync.Mutex    // serializes sending to items with closing it
+	stream        *ClientStream // bidirectional stream, see NewStream
 }
 
-func (call *Call) done() {
-	call.Done <- call
+// done delivers the call to its Done channel. It never blocks: if the
+// channel is full the call is dropped and false is returned, so that a
+// slow consumer can't stall the receive loop (same as net/rpc).
+func (call *Call) done() bool {
+	select {
+	case call.Done <- call:
+		return true
+	default:
+		log.Println("rpc client: discarding Call reply due to insufficient Done chan capacity")
+		return false
+	}
 }
 
 // Client represents an RPC Client.
@@ -42,25 +63,283 @@ type Client struct {
 	mu       sync.Mutex // protect following
 	seq      uint64
 	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	dropped  uint64        // calls dropped by done, accessed atomically
+	late     uint64        // responses to calls already completed, accessed atomically
+	unknown  uint64        // responses to seqs never issued, accessed atomically
+	closing  bool          // user has called Close
+	shutdown bool          // server has told us to stop
+	callback bool          // requests are server-initiated callbacks, see Peer
+	id       string        // the client ID accepted by the server, see ID
+	codec    codec.Type    // the codec negotiated in the handshake
+	secure   bool          // the connection is a TLS connection, see ErrInsecureToken
+	err      error         // why the client became unusable, see Err
+	stopped  chan struct{} // closed once the client is unusable, see Done
+	// duplicate responses are told from late ones by the seqs answered
+	// recently, see orphan. received, next and wrapped are protected by mu.
+	duplicate uint64             // accessed atomically
+	notified  uint64             // one-way requests sent by Notify, accessed atomically
+	dupLogged uint32             // set once a duplicate has been logged, accessed atomically
+	received  [recentSeqs]uint64 // ring of the seqs most recently answered
+	next      int                // next slot of received
+	wrapped   bool               // seq has wrapped around, any seq may have been issued
+	// callbacks holds the receivers the server may call back into.
+	callbacks sync.Map
+	flights   map[string]*flight // in-flight shared calls, see Option.SingleFlight
+	// idempotent holds the methods whose calls may be shared, see MarkIdempotent.
+	idempotent sync.Map
+	lazy       *lazyDial     // non-nil if the connection is made on first use
+	slots      chan struct{} // one per pending call, nil if Option.MaxPendingCalls is 0
+	// shedding is set once pending reaches Option.ShedHighWater and
+	// cleared once it drops below Option.ShedLowWater. Protected by mu.
+	shedding bool
+	// interceptors wrap every call, see Use. Protected by mu.
+	interceptors []ClientInterceptor
+	// state and the transitions not yet delivered to stateFns,
+	// see OnStateChange. Protected by mu.
+	state     State
+	stateFns  []func(old, new State)
+	changes   []stateChange
+	notifying bool // a goroutine is delivering changes
+	// drained is closed once pending empties during CloseGracefully.
+	// Protected by mu.
+	drained chan struct{}
 }
 
 var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// ErrTooManyPendingCalls is returned by Go when Option.MaxPendingCalls
+// is reached and Option.FailOnMaxPending is set.
+var ErrTooManyPendingCalls = errors.New("rpc client: too many pending calls")
+
+// ErrOverloaded is returned by the calls shed while the pending calls
+// are above the high-water mark, see Option.ShedHighWater.
+var ErrOverloaded = errors.New("rpc client: overloaded")
+
+// ErrClientClosed is reported by Err once the user has called Close.
+var ErrClientClosed = errors.New("rpc client: client closed")
+
+// ErrProtocol is wrapped by the errors reporting a response the client
+// can't make sense of. A response for a seq the client never issued
+// breaks the connection with it.
+var ErrProtocol = errors.New("rpc client: protocol error")
+
+// ErrIllFormedMethod is wrapped by the error of a call whose name is not
+// of the form "Service.Method". Such calls fail before anything is sent,
+// unless the client was built with WithPlainMethodNames.
+var ErrIllFormedMethod = errors.New("rpc client: service/method ill-formed")
+
+// recentSeqs is how many answered seqs are remembered to tell
+// duplicate responses from late ones.
+const recentSeqs = 64
+
 // Close the connection
 func (client *Client) Close() error {
+	defer client.notifyState()
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	if client.closing {
 		return ErrShutdown
 	}
 	client.closing = true
+	client.stop(ErrClientClosed)
+	client.setState(StateClosing)
+	if client.cc == nil {
+		// a lazy client that has never been used
+		client.setState(StateShutdown)
+		return nil
+	}
 	return client.cc.Close()
 }
 
+// CloseGracefully stops accepting new calls like Close, but waits for
+// the pending calls to complete before closing the connection. If ctx
+// is done first the connection is closed anyway, failing the calls
+// still pending, and ctx.Err() is returned.
+func (client *Client) CloseGracefully(ctx context.Context) error {
+	defer client.notifyState()
+	client.mu.Lock()
+	if client.closing {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	client.stop(ErrClientClosed)
+	client.setState(StateClosing)
+	if client.cc == nil {
+		// a lazy client that has never been used
+		client.setState(StateShutdown)
+		client.mu.Unlock()
+		return nil
+	}
+	drained := make(chan struct{})
+	if len(client.pending) == 0 {
+		close(drained)
+	} else {
+		client.drained = drained
+	}
+	client.mu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if cerr := client.cc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// checkDrained signals CloseGracefully once no call is pending.
+// client.mu must be held.
+func (client *Client) checkDrained() {
+	if client.drained != nil && len(client.pending) == 0 {
+		close(client.drained)
+		client.drained = nil
+	}
+}
+
+// stop records why the client became unusable, the first reason wins.
+// client.mu must be held.
+func (client *Client) stop(err error) {
+	if client.err != nil {
+		return
+	}
+	if err == nil {
+		err = ErrShutdown
+	}
+	client.err = err
+	close(client.stopped)
+}
+
+// Err returns the reason why the client became unusable: ErrClientClosed
+// after Close, or the error that broke the connection. It returns nil
+// while the client is available.
+func (client *Client) Err() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.err
+}
+
+// Done returns a channel that is closed when the client becomes unusable,
+// so that supervisors can log Err and reconnect.
+func (client *Client) Done() <-chan struct{} {
+	return client.stopped
+}
+
+// complete delivers call to its Done channel and counts it if dropped.
+func (client *Client) complete(call *Call) {
+	if call.items.IsValid() {
+		call.itemsMu.Lock()
+		call.items.Close()
+		call.itemsMu.Unlock()
+	}
+	if call.stream != nil {
+		close(call.stream.ended)
+	}
+	if call.Error != nil {
+		client.logger().Errorf("rpc client: call %s (trace %s) failed: %v", call.ServiceMethod, call.TraceID, call.Error)
+	}
+	if !call.done() {
+		atomic.AddUint64(&client.dropped, 1)
+	}
+}
+
+// logger returns the Logger set by WithLogger, by default the standard log
+// package with debug lines dropped.
+func (client *Client) logger() Logger {
+	if client.opt != nil && client.opt.logger != nil {
+		return client.opt.logger
+	}
+	return stdLogger{}
+}
+
+// DroppedCalls returns the number of completed calls that could not be
+// delivered because their Done channel was full.
+func (client *Client) DroppedCalls() uint64 {
+	return atomic.LoadUint64(&client.dropped)
+}
+
+// answered remembers that a response for seq was received.
+// client.mu must be held.
+func (client *Client) answered(seq uint64) {
+	client.received[client.next] = seq
+	client.next = (client.next + 1) % recentSeqs
+}
+
+// orphan classifies a response matching no pending call. A seq answered
+// recently is a duplicate, which is logged once. Another seq already
+// issued is late, e.g. its call timed out. Any other seq was never sent
+// by this client, orphan returns an ErrProtocol error for it.
+func (client *Client) orphan(h *codec.Header) error {
+	client.mu.Lock()
+	duplicate := false
+	for _, seq := range client.received {
+		if seq != 0 && seq == h.Seq {
+			duplicate = true
+			break
+		}
+	}
+	// generated seqs can't be told from ones never issued
+	issued := h.Seq != 0 && (h.Seq < client.seq || client.wrapped || client.seqGenerator() != nil)
+	if issued && !duplicate {
+		client.answered(h.Seq)
+	}
+	client.mu.Unlock()
+	switch {
+	case duplicate:
+		atomic.AddUint64(&client.duplicate, 1)
+		if atomic.CompareAndSwapUint32(&client.dupLogged, 0, 1) {
+			client.logger().Infof("rpc client: duplicate response %s for seq %d", h.ServiceMethod, h.Seq)
+		}
+	case issued:
+		atomic.AddUint64(&client.late, 1)
+	default:
+		atomic.AddUint64(&client.unknown, 1)
+		return fmt.Errorf("%w: response %s for seq %d never issued", ErrProtocol, h.ServiceMethod, h.Seq)
+	}
+	return nil
+}
+
+// OrphanedResponses returns the number of responses that matched no
+// pending call: late ones answer calls already completed, unknown ones
+// carry a seq this client never issued. Duplicates count as late.
+func (client *Client) OrphanedResponses() (late, unknown uint64) {
+	s := client.Stats()
+	return s.Late + s.Duplicate, s.Unknown
+}
+
+// ClientStats counts the anomalies seen by a client.
+type ClientStats struct {
+	Dropped   uint64 // calls not delivered because their Done channel was full
+	Late      uint64 // responses to calls already completed, e.g. timed out
+	Duplicate uint64 // responses repeating one received recently
+	Unknown   uint64 // responses to seqs never issued, each breaks the connection
+	Notified  uint64 // one-way requests sent, see Notify
+}
+
+// Stats returns the anomaly counters of the client.
+func (client *Client) Stats() ClientStats {
+	return ClientStats{
+		Dropped:   atomic.LoadUint64(&client.dropped),
+		Late:      atomic.LoadUint64(&client.late),
+		Duplicate: atomic.LoadUint64(&client.duplicate),
+		Unknown:   atomic.LoadUint64(&client.unknown),
+		Notified:  atomic.LoadUint64(&client.notified),
+	}
+}
+
+// ID returns the client ID announced to the server, as sanitized by it.
+// Before a lazy client connects it returns the ID it will announce.
+func (client *Client) ID() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.id
+}
+
 // IsAvailable return true if the client does work
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
@@ -74,33 +353,154 @@ func (client *Client) registerCall(call *Call) (uint64, error) {
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
-	call.Seq = client.seq
+	if client.overloaded() {
+		return 0, ErrOverloaded
+	}
+	seq, err := client.nextSeq()
+	if err != nil {
+		return 0, err
+	}
+	call.Seq = seq
+	call.Info.Sent = time.Now()
 	client.pending[call.Seq] = call
-	client.seq++
 	return call.Seq, nil
 }
 
+// overloaded reports whether a new call must be shed. Shedding starts
+// once pending reaches Option.ShedHighWater and stops only once it drops
+// below Option.ShedLowWater, so the client doesn't flap around a single
+// threshold. client.mu must be held.
+func (client *Client) overloaded() bool {
+	if client.opt == nil || client.opt.ShedHighWater <= 0 {
+		return false
+	}
+	if n := len(client.pending); n >= client.opt.ShedHighWater {
+		client.shedding = true
+	} else if n < client.opt.ShedLowWater {
+		client.shedding = false
+	}
+	return client.shedding
+}
+
+// maxSeqDraws bounds how many seqs are drawn from a seq generator
+// before giving up on finding one that is not pending.
+const maxSeqDraws = 8
+
+// nextSeq returns the seq of a new call. client.mu must be held.
+func (client *Client) nextSeq() (uint64, error) {
+	if gen := client.seqGenerator(); gen != nil {
+		for i := 0; i < maxSeqDraws; i++ {
+			if seq := gen(); seq != 0 && client.pending[seq] == nil {
+				return seq, nil
+			}
+		}
+		return 0, errors.New("rpc client: seq generator returned no usable seq")
+	}
+	// 0 means invalid call, and a wrapped seq must not reuse one still pending
+	for client.seq == 0 || client.pending[client.seq] != nil {
+		client.seq++
+	}
+	seq := client.seq
+	client.seq++
+	if client.seq == 0 {
+		client.wrapped = true
+	}
+	return seq, nil
+}
+
+// seqGenerator returns the generator set by WithSeqGenerator, if any.
+func (client *Client) seqGenerator() func() uint64 {
+	if client.opt == nil {
+		return nil
+	}
+	return client.opt.seqGen
+}
+
 func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	call := client.takeCall(seq)
+	client.checkDrained()
+	return call
+}
+
+// takeCall removes the call pending under seq without signaling
+// CloseGracefully, for a caller that still has to read its reply.
+// client.mu must be held.
+func (client *Client) takeCall(seq uint64) *Call {
 	call := client.pending[seq]
-	delete(client.pending, seq)
+	if call != nil {
+		delete(client.pending, seq)
+		client.releaseSlot()
+	}
 	return call
 }
 
+// acquireSlot reserves room for a pending call when Option.MaxPendingCalls
+// is set. If there is none it fails with ErrTooManyPendingCalls unless
+// block is true, in which case it waits until a call completes or ctx is done.
+func (client *Client) acquireSlot(ctx context.Context, block bool) error {
+	if client.slots == nil {
+		return nil
+	}
+	select {
+	case client.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if !block {
+		return ErrTooManyPendingCalls
+	}
+	select {
+	case client.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-client.stopped:
+		return ErrShutdown
+	}
+}
+
+// releaseSlot frees the room of a call that left, or never entered, pending.
+func (client *Client) releaseSlot() {
+	if client.slots != nil {
+		<-client.slots
+	}
+}
+
 func (client *Client) terminateCalls(err error) {
+	defer client.notifyState()
 	client.sending.Lock()
 	defer client.sending.Unlock()
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	client.stop(err)
+	client.setState(StateShutdown)
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		client.releaseSlot()
 		call.Error = err
-		call.done()
+		client.complete(call)
 	}
+	client.checkDrained()
 }
 
 func (client *Client) send(call *Call) {
+	if err := client.checkMethod(call.ServiceMethod); err != nil {
+		client.releaseSlot()
+		call.Error = err
+		client.complete(call)
+		return
+	}
+	if client.lazy != nil {
+		if err := client.connect(); err != nil {
+			client.releaseSlot()
+			call.Error = err
+			client.complete(call)
+			return
+		}
+	}
 	// make sure that the client will send a complete request
 	client.sending.Lock()
 	defer client.sending.Unlock()
@@ -108,8 +508,9 @@ func (client *Client) send(call *Call) {
 	// register this call.
 	seq, err := client.registerCall(call)
 	if err != nil {
+		client.releaseSlot()
 		call.Error = err
-		call.done()
+		client.complete(call)
 		return
 	}
 
@@ -117,15 +518,35 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.ErrorCode = 0
+	client.header.Callback = client.callback
+	client.header.Stream = call.stream != nil
+	client.header.Token = call.token
+	client.header.TraceID = call.TraceID
+	client.header.Priority = call.priority
+	client.header.Timeout = 0
+	if !call.deadline.IsZero() {
+		// a relative timeout is immune to clock skew between the hosts
+		client.header.Timeout = int64(time.Until(call.deadline))
+		if client.header.Timeout <= 0 {
+			client.header.Timeout = 1
+		}
+	}
+
+	client.logger().Debugf("rpc client: send method=%s seq=%d trace=%s", call.ServiceMethod, seq, call.TraceID)
 
 	// encode and send the request
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	err = client.cc.Write(&client.header, call.Args)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		// call may be nil, it usually means that Write partially failed,
 		// client has received the response and handled
 		if call != nil {
 			call.Error = err
-			call.done()
+			client.complete(call)
 		}
 	}
 }
@@ -137,57 +558,259 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
-		call := client.removeCall(h.Seq)
-		switch {
-		case call == nil:
-			// it usually means that Write partially failed
-			// and call was already removed.
-			err = client.cc.ReadBody(nil)
-		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadBody(nil)
-			call.done()
-		default:
-			err = client.cc.ReadBody(call.Reply)
-			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
-			}
-			call.done()
+		if h.Callback {
+			err = client.handleCallback(&h)
+			continue
 		}
+		err = client.handleResponse(&h)
 	}
 	// error occurs, so terminateCalls pending calls
 	client.terminateCalls(err)
 }
 
+// handleResponse reads the body of the response described by h
+// and completes the matching pending call.
+func (client *Client) handleResponse(h *codec.Header) (err error) {
+	if h.Stream {
+		return client.handleStreamItem(h)
+	}
+	client.mu.Lock()
+	call := client.takeCall(h.Seq)
+	if call != nil {
+		call.Info.Received = time.Now()
+		client.answered(h.Seq)
+	}
+	client.mu.Unlock()
+	if call != nil {
+		// the server echoes the trace ID of the request
+		client.logger().Debugf("rpc client: response method=%s seq=%d trace=%s error=%q", h.ServiceMethod, h.Seq, h.TraceID, h.Error)
+	}
+	switch {
+	case call == nil:
+		// it usually means that Write partially failed
+		// and call was already removed.
+		err = client.cc.ReadBody(nil)
+		if err == nil {
+			err = client.orphan(h)
+		}
+	case h.ServiceMethod != call.ServiceMethod:
+		// never decode a reply into a call it doesn't belong to
+		call.Error = protocolError(h, call)
+		err = client.cc.ReadBody(nil)
+		client.complete(call)
+	case h.Error != "":
+		call.Error = responseError(h)
+		err = client.cc.ReadBody(nil)
+		client.complete(call)
+	case discardsReply(call.Reply):
+		err = client.cc.ReadBody(nil)
+		client.complete(call)
+	default:
+		err = client.cc.ReadBody(call.Reply)
+		if err != nil {
+			call.Error = errors.New("reading body " + err.Error())
+		}
+		client.complete(call)
+	}
+	if call != nil {
+		// the reply is read, CloseGracefully may close the connection now
+		client.mu.Lock()
+		client.checkDrained()
+		client.mu.Unlock()
+	}
+	return err
+}
+
+// protocolError reports a response whose header doesn't match the call
+// pending under its seq.
+func protocolError(h *codec.Header, call *Call) error {
+	return fmt.Errorf("%w: response %s (seq %d) doesn't match call %s",
+		ErrProtocol, h.ServiceMethod, h.Seq, call.ServiceMethod)
+}
+
+// RegisterCallback publishes the methods of rcvr so that the server
+// can invoke them over this connection through its Peer.
+// The methods must satisfy the same rules as Server.Register.
+func (client *Client) RegisterCallback(rcvr interface{}) error {
+	s := newService(rcvr)
+	if _, dup := client.callbacks.LoadOrStore(s.name, s); dup {
+		return errors.New("rpc client: callback already defined: " + s.name)
+	}
+	return nil
+}
+
+// handleCallback reads a server-initiated request and dispatches it
+// to the registered callback receiver. The response is written back
+// with the Callback flag set so the server routes it to its Peer.
+func (client *Client) handleCallback(h *codec.Header) error {
+	var svc *service
+	var mtype *methodType
+	if dot := strings.LastIndex(h.ServiceMethod, "."); dot >= 0 {
+		if svci, ok := client.callbacks.Load(h.ServiceMethod[:dot]); ok {
+			svc = svci.(*service)
+			mtype = svc.method[h.ServiceMethod[dot+1:]]
+		}
+	}
+	if mtype != nil && mtype.stream {
+		// callbacks can't stream their replies
+		mtype = nil
+	}
+	if mtype == nil {
+		if err := client.cc.ReadBody(nil); err != nil {
+			return err
+		}
+		h.Error = "rpc client: can't find callback " + h.ServiceMethod
+		h.ErrorCode = int(CodeNotFound)
+		go client.sendCallbackResponse(h, invalidRequest)
+		return nil
+	}
+	argv, replyv := mtype.newArgv(), mtype.newReplyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err := client.cc.ReadBody(argvi); err != nil {
+		return err
+	}
+	go func() {
+		reply, err := client.callCallback(h, svc, mtype, argv, replyv)
+		if err != nil {
+			h.Error = encodeError(err)
+			h.ErrorCode = int(errorCode(err))
+			client.sendCallbackResponse(h, invalidRequest)
+			return
+		}
+		client.sendCallbackResponse(h, reply.Interface())
+	}()
+	return nil
+}
+
+// callCallback calls a callback method and turns a panic in it into
+// an error with CodeInternal, so the server can't crash the client.
+func (client *Client) callCallback(h *codec.Header, svc *service, mtype *methodType, argv, replyv reflect.Value) (reply reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			client.logger().Errorf("rpc client: panic in callback %s (trace %s): %v", h.ServiceMethod, h.TraceID, r)
+			err = withCode(CodeInternal, fmt.Errorf("rpc client: panic in callback %s: %v", h.ServiceMethod, r))
+		}
+	}()
+	return svc.call(mtype, argv, replyv)
+}
+
+func (client *Client) sendCallbackResponse(h *codec.Header, body interface{}) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	err := client.cc.Write(h, body)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	if err != nil {
+		client.logger().Errorf("rpc client: write callback response error: %v", err)
+	}
+}
+
+// checkMethod rejects a serviceMethod that the server can't split at its
+// last dot into a non-empty service and method name, saving the round trip.
+// Service names may contain dots, e.g. "_goRPC.Reflect.ListServices".
+func (client *Client) checkMethod(serviceMethod string) error {
+	if client.opt != nil && client.opt.plainNames {
+		return nil
+	}
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return fmt.Errorf("%w: %q", ErrIllFormedMethod, serviceMethod)
+	}
+	return nil
+}
+
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
+// When Option.MaxPendingCalls is reached, Go blocks until a call
+// completes, or fails with ErrTooManyPendingCalls if Option.FailOnMaxPending is set.
+// A nil reply means the caller doesn't care about the response body:
+// the call still runs on the server and reports its error, but the
+// reply is discarded.
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	call := newCall(serviceMethod, args, reply, done)
+	block := client.opt == nil || !client.opt.FailOnMaxPending
+	if invoke := client.intercept(client.invoker(block)); invoke != nil {
+		go func() {
+			ctx := WithTraceID(WithCallInfo(context.Background(), &call.Info), call.TraceID)
+			call.Error = invoke(ctx, serviceMethod, args, reply)
+			client.complete(call)
+		}()
+		return call
+	}
+	if err := client.acquireSlot(context.Background(), block); err != nil {
+		call.Error = err
+		client.complete(call)
+		return call
+	}
+	client.start(call)
+	return call
+}
+
+// discardsReply reports whether reply is nil, or a nil pointer,
+// so that the response body must be read but not decoded.
+func discardsReply(reply interface{}) bool {
+	if reply == nil {
+		return true
+	}
+	v := reflect.ValueOf(reply)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func newCall(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
 		log.Panic("rpc client: done channel is unbuffered")
 	}
-	call := &Call{
+	return &Call{
 		ServiceMethod: serviceMethod,
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		Info:          CallInfo{Enqueued: time.Now()},
+		TraceID:       newTraceID(),
+	}
+}
+
+// start sends a call whose pending slot is already acquired.
+func (client *Client) start(call *Call) {
+	// calls carrying their own token are never shared
+	if call.token == "" && client.shared(call.ServiceMethod) {
+		client.goShared(call)
+		return
 	}
 	client.send(call)
-	return call
 }
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
+// When Option.MaxPendingCalls is reached, Call waits for room until ctx is done.
+// Replies of the methods configured by WithCache may come from the cache.
+// As with Go, reply may be nil to discard the response body.
+// With the gob codec, a *[]byte reply with enough capacity is decoded
+// into its backing array, so a buffer can be reused across calls.
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
-	select {
-	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
-	case call := <-call.Done:
-		return call.Error
+	var cache *responseCache
+	if client.opt != nil {
+		cache = client.opt.cache
+	}
+	// a done ctx fails the call even if its reply is cached
+	if ctx.Err() == nil && cache.get(serviceMethod, args, reply) {
+		return nil
 	}
+	invoke := client.invoker(true)
+	if chain := client.intercept(invoke); chain != nil {
+		invoke = chain
+	}
+	err := invoke(ctx, serviceMethod, args, reply)
+	if err == nil {
+		cache.put(serviceMethod, args, reply)
+	}
+	return err
 }
 
 func parseOptions(opts ...*Option) (*Option, error) {
@@ -198,28 +821,159 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
-	opt.MagicNumber = DefaultOption.MagicNumber
+	// copy the option so that the caller's one is never modified
+	opt := *opts[0]
+	// a server built with NewServerWithMagic expects its own magic number
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = DefaultOption.MagicNumber
+	}
+	if opt.Version == 0 {
+		opt.Version = DefaultOption.Version
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	if opt.ClientID == "" {
+		opt.ClientID = DefaultOption.ClientID
+	}
+	if err := validate(&opt); err != nil {
+		return nil, err
+	}
+	return &opt, nil
 }
 
+// NewClient runs the option exchange on conn and returns a client
+// sending its calls over it. opt is copied and never modified, the
+// values negotiated with the server are kept on the client.
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
+	o := *opt
+	hs, err := handshakeTimeout(conn, &o)
+	if err != nil {
+		return nil, err
+	}
+	client := newClientCodec(hs.cc, &o)
+	client.codec, client.id = hs.codec, hs.clientID
+	client.secure = isSecure(conn)
+	return client, nil
+}
+
+// handshakeTimeout runs handshake within Option.HandshakeTimeout, so that
+// a server accepting the connection without reading the options can't
+// stall NewClient. On expiry conn is closed, which also unblocks handshake.
+func handshakeTimeout(conn net.Conn, opt *Option) (*handshakeResult, error) {
+	if opt.HandshakeTimeout <= 0 {
+		return handshake(conn, opt)
+	}
+	type result struct {
+		hs  *handshakeResult
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		hs, err := handshake(conn, opt)
+		ch <- result{hs: hs, err: err}
+	}()
+	t := time.NewTimer(opt.HandshakeTimeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r.hs, r.err
+	case <-t.C:
+		_ = conn.Close()
+		return nil, fmt.Errorf("rpc client: handshake timeout: expect within %s", opt.HandshakeTimeout)
+	}
+}
+
+// handshakeResult is what the handshake settled with the server. It is
+// kept on the Client rather than written back into the Option, which
+// may be shared by other dials, e.g. DefaultOption.
+type handshakeResult struct {
+	cc       codec.Codec // the codec to use on conn afterwards
+	codec    codec.Type  // the negotiated codec type
+	clientID string      // the client ID accepted, possibly sanitized, by the server
+}
+
+// handshake sends the options to the server and returns
+// the codec to use on conn afterwards. Since protocol version 2
+// the server replies with the negotiated codec type.
+func handshake(conn net.Conn, opt *Option) (*handshakeResult, error) {
+	// the server end agreed out of band to skip the option exchange
+	// and serves conn with ServeConnNoHandshake
+	if opt.SkipHandshake {
+		if opt.AuthToken != "" || (opt.CodecType != "" && opt.CodecType != codec.GobType) {
+			_ = conn.Close()
+			return nil, errors.New("rpc client: SkipHandshake requires the gob codec and no auth token")
+		}
+		return &handshakeResult{cc: codec.NewGobCodec(conn), codec: codec.GobType, clientID: opt.ClientID}, nil
+	}
+	if err := checkVersion(opt.Version); err != nil {
+		log.Println("rpc client: options error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := checkToken(opt.AuthToken, isSecure(conn), opt); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// the codecs offered must be known locally, servers before
+	// version 2 don't negotiate and use CodecType
+	offer := opt
+	if opt.Version < 2 {
+		offer = &Option{CodecType: opt.CodecType}
+	} else if id, ok := codec.IDOf(opt.CodecType); ok && opt.Version >= 5 && opt.CodecID == nil && len(opt.AcceptedCodecs) == 0 {
+		// since version 5 the codec is sent by its numeric ID, which is shorter
+		o := *opt
+		o.CodecType, o.CodecID = "", &id
+		offer = &o
+	}
+	if _, err := negotiateCodec(offer); err != nil {
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
+	sent := opt
+	if opt.Version >= 2 {
+		sent = offer
+	}
 	// send options with server
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	if err := json.NewEncoder(conn).Encode(sent); err != nil {
 		log.Println("rpc client: options error: ", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	if opt.Version < 2 {
+		return &handshakeResult{cc: codec.Lookup(opt.CodecType)(conn), codec: opt.CodecType, clientID: opt.ClientID}, nil
+	}
+	dec := json.NewDecoder(conn)
+	reply, err := readHandshakeReply(dec)
+	if err == nil && codec.Lookup(reply.CodecType) == nil {
+		err = fmt.Errorf("invalid codec type %s", reply.CodecType)
+	}
+	if err != nil {
+		log.Println("rpc client: handshake error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	hs := &handshakeResult{cc: codec.Lookup(reply.CodecType)(newHandshakeConn(conn, dec)), codec: reply.CodecType, clientID: opt.ClientID}
+	if reply.ClientID != "" {
+		hs.clientID = reply.ClientID
+	}
+	return hs, nil
+}
+
+// NewClientWithCodec returns a client sending its calls over cc, for
+// transports that are not dialed, e.g. an SSH channel or a net.Pipe.
+// There is no option exchange: the server end must be served with
+// Server.ServeCodec using the same codec, and neither the protocol
+// version, the codec, the client ID nor an auth token are negotiated.
+// Every frame is a header followed by its body, written by one Write
+// and sent by Flush. opt may be nil for DefaultOption; only its client
+// side settings apply. Closing the client closes cc, as with Dial.
+func NewClientWithCodec(cc codec.Codec, opt *Option) *Client {
+	o := *DefaultOption
+	if opt != nil {
+		o = *opt
+	}
+	return newClientCodec(cc, &o)
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -227,12 +981,24 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		seq:     1, // seq starts with 1, 0 means invalid call
 		cc:      cc,
 		opt:     opt,
+		id:      opt.ClientID,
 		pending: make(map[uint64]*Call),
+		stopped: make(chan struct{}),
+		slots:   newSlots(opt),
+		state:   StateConnected,
 	}
 	go client.receive()
 	return client
 }
 
+// newSlots returns the semaphore bounding the pending calls, if any.
+func newSlots(opt *Option) chan struct{} {
+	if opt == nil || opt.MaxPendingCalls <= 0 {
+		return nil
+	}
+	return make(chan struct{}, opt.MaxPendingCalls)
+}
+
 type clientResult struct {
 	client *Client
 	err    error
@@ -249,6 +1015,10 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	if err != nil {
 		return nil, err
 	}
+	if opt.tlsConfig != nil {
+		// the TLS handshake runs on the first write, within the connect timeout
+		conn = tls.Client(conn, tlsConfig(opt.tlsConfig, address))
+	}
 	// close the connection if client is nil
 	defer func() {
 		if err != nil {
@@ -272,14 +1042,58 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	}
 }
 
-// Dial connects to an RPC server at the specified network address
+// tlsConfig returns config with ServerName set to the host of address
+// unless it is already set.
+func tlsConfig(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" || config.InsecureSkipVerify {
+		return config
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// Dial connects to an RPC server at the specified network address.
+// It is kept for compatibility, see DialWith for the functional options.
 func Dial(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return DialWith(network, address, withOption(opt))
+}
+
+// DialMulti tries the addresses in order, each within the connect timeout
+// of the option, and returns a client for the first server that accepts
+// the connection. Shuffle addresses beforehand to spread the load.
+func DialMulti(network string, addresses []string, opts ...*Option) (*Client, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("rpc client: no address to dial")
+	}
+	errs := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		client, err := Dial(network, address, opts...)
+		if err == nil {
+			return client, nil
+		}
+		errs = append(errs, address+": "+err.Error())
+	}
+	return nil, errors.New("rpc client: all addresses failed: " + strings.Join(errs, "; "))
 }
 
 // NewHTTPClient new a Client instance via HTTP as transport protocol
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
+	return newHTTPClient(conn, defaultRPCPath, opt)
+}
+
+// newHTTPClient issues a CONNECT to rpcPath on conn and then runs the
+// option handshake, as NewClient does.
+func newHTTPClient(conn net.Conn, rpcPath string, opt *Option) (*Client, error) {
+	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", rpcPath))
 
 	// Require successful HTTP response
 	// before switching to RPC protocol.
@@ -299,12 +1113,56 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 	return dialTimeout(NewHTTPClient, network, address, opts...)
 }
 
+// DialHTTPPath connects to an HTTP RPC server at the specified network
+// address listening on rpcPath, see Server.HandleHTTP.
+func DialHTTPPath(network, address, rpcPath string, opts ...*Option) (*Client, error) {
+	newClient := func(conn net.Conn, opt *Option) (*Client, error) {
+		return newHTTPClient(conn, rpcPath, opt)
+	}
+	return dialTimeout(newClient, network, address, opts...)
+}
+
+// DialWS connects to an RPC server through a WebSocket upgrade of rawURL,
+// ws://host/path or wss://host/path, see Server.ServeWS. A wss URL uses
+// the TLS config of WithTLS if any, otherwise the system roots.
+func DialWS(rawURL string, opts ...*Option) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("rpc client: unsupported websocket scheme %q", u.Scheme)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	newClient := func(conn net.Conn, opt *Option) (*Client, error) {
+		if _, ok := conn.(*tls.Conn); !ok && u.Scheme == "wss" {
+			conn = tls.Client(conn, tlsConfig(&tls.Config{}, address))
+		}
+		ws, err := wsHandshake(conn, u.Host, u.RequestURI())
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(ws, opt)
+	}
+	return dialTimeout(newClient, "tcp", address, opts...)
+}
+
 // XDial calls different functions to connect to a RPC server
 // according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
-// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock,
+// ws@10.0.0.1:7002/ws
+// Only the first @ separates the protocol, so the address of a Linux
+// abstract socket keeps its leading @, e.g. unix@@geerpc.
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
-	parts := strings.Split(rpcAddr, "@")
+	parts := strings.SplitN(rpcAddr, "@", 2)
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
 	}
@@ -312,8 +1170,10 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "ws", "wss":
+		return DialWS(protocol+"://"+addr, opts...)
 	default:
 		// tcp, unix or other transport protocol
 		return Dial(protocol, addr, opts...)
 	}
-}
\ No newline at end of file
+}
@@ -2,10 +2,20 @@ package registry
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"io"
+	"math"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,12 +55,59 @@ func TestClientDialTimeout(t *testing.T) {
 	})
 }
 
+func TestClient_HandshakeTimeout(t *testing.T) {
+	t.Parallel()
+	// the server accepts the connections but never reads the options
+	l, _ := net.Listen("tcp", ":0")
+	defer func() { _ = l.Close() }()
+	var conns []net.Conn
+	var mu sync.Mutex
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err := Dial("tcp", l.Addr().String(), &Option{ConnectTimeout: 0, HandshakeTimeout: 100 * time.Millisecond})
+	_assert(err != nil && strings.Contains(err.Error(), "handshake timeout"), "expect a handshake timeout, got %v", err)
+	_assert(time.Since(start) < time.Second, "expect Dial to return within the timeout, took %s", time.Since(start))
+
+	conn, _ := net.Dial("tcp", l.Addr().String())
+	_, err = NewClient(conn, &Option{MagicNumber: MagicNumber, Version: ProtocolVersion, CodecType: codec.GobType, HandshakeTimeout: 100 * time.Millisecond})
+	_assert(err != nil && strings.Contains(err.Error(), "handshake timeout"), "expect NewClient to time out, got %v", err)
+	_, err = conn.Write([]byte{0})
+	_assert(err != nil, "expect the connection to be closed")
+	_, err = DialWith("tcp", l.Addr().String(), WithHandshakeTimeout(-time.Second))
+	_assert(err != nil, "expect a negative handshake timeout to be rejected")
+
+	// a lazy client bounds the handshake of its first call the same way
+	lazy := NewLazyClient("tcp", l.Addr().String(), &Option{ConnectTimeout: 0, HandshakeTimeout: 100 * time.Millisecond})
+	defer func() { _ = lazy.Close() }()
+	start = time.Now()
+	err = lazy.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "handshake timeout"), "expect the lazy client to time out, got %v", err)
+	_assert(time.Since(start) < time.Second, "expect the first call to return within the timeout, took %s", time.Since(start))
+}
+
 func TestClient_Call(t *testing.T) {
 	t.Parallel()
 	addrCh := make(chan string)
 	go startServer(addrCh)
 	addr := <-addrCh
-	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
 		ctx, _ := context.WithTimeout(context.Background(), time.Second)
@@ -71,12 +128,14 @@ func TestClient_Call(t *testing.T) {
 func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" {
 		ch := make(chan struct{})
-		addr := "tmp/geerpc.sock"
+		addr := filepath.Join(t.TempDir(), "geerpc.sock")
 		go func() {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix",addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				close(ch)
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
@@ -85,4 +144,600 @@ func TestXDial(t *testing.T) {
 		_, err := XDial("unix@" + addr)
 		_assert(err == nil,"failed to connect unix socket")
 	}
-}
\ No newline at end of file
+}
+
+func TestServer_Unix(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	var s Slow
+	_ = server.Register(&s)
+	addrs := []string{filepath.Join(t.TempDir(), "rpc.sock")}
+	if runtime.GOOS == "linux" {
+		// abstract socket, no file involved
+		addrs = append(addrs, fmt.Sprintf("@goRPC-test-%d", os.Getpid()))
+	}
+	for _, addr := range addrs {
+		l, err := net.Listen("unix", addr)
+		_assert(err == nil, "failed to listen on %s: %v", addr, err)
+		go server.Accept(l)
+
+		client, err := XDial("unix@"+addr, &Option{HandleTimeout: time.Millisecond * 50})
+		_assert(err == nil, "failed to dial %s: %v", addr, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect 3 over %s, got %d: %v", addr, reply, err)
+
+		// deadlines work on any connection, not only TCP
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		err = client.Call(ctx, "Slow.Sleep", 200, &reply)
+		cancel()
+		_assert(err != nil, "expect the call over %s to time out", addr)
+		err = client.Call(context.Background(), "Slow.Sleep", 200, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect the server to time out over %s, got %v", addr, err)
+		_ = client.Close()
+		_ = l.Close()
+	}
+}
+
+func TestDialMulti(t *testing.T) {
+	t.Parallel()
+	dead, _ := net.Listen("tcp", ":0")
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := DialMulti("tcp", []string{deadAddr, l.Addr().String()}, &Option{ConnectTimeout: time.Second})
+	_assert(err == nil, "failed to dial the second address: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	_, err = DialMulti("tcp", []string{deadAddr})
+	_assert(err != nil && strings.Contains(err.Error(), deadAddr), "expect an error naming the dead address")
+}
+
+func TestClient_SlowDoneConsumer(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	// nobody reads done, so all but one call overflow it
+	done := make(chan *Call, 1)
+	for i := 0; i < 100; i++ {
+		client.Go("Foo.Sum", Args{Num1: i, Num2: i}, new(int), done)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var reply int
+	err := client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "unrelated call should complete, got %v", err)
+	// responses may come back in any order
+	for i := 0; i < 50 && client.DroppedCalls() < 99; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	_assert(client.DroppedCalls() == 99, "expect 99 dropped calls, got %d", client.DroppedCalls())
+}
+
+// startRawServer 接受一个连接，完成握手后交给serve处理
+func startRawServer(serve func(conn net.Conn)) string {
+	l, _ := net.Listen("tcp", ":0")
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		var opt Option
+		_ = json.NewDecoder(conn).Decode(&opt)
+		t, err := negotiateCodec(&opt)
+		_ = replyHandshake(conn, &opt, t, err)
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+// startCodecServer 接受一个连接，完成握手后以gob编解码交给serve处理
+func startCodecServer(serve func(cc codec.Codec)) string {
+	l, _ := net.Listen("tcp", ":0")
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		var opt Option
+		dec := json.NewDecoder(conn)
+		_ = dec.Decode(&opt)
+		_ = json.NewEncoder(conn).Encode(&handshakeReply{CodecType: codec.GobType})
+		serve(codec.NewGobCodec(newHandshakeConn(conn, dec)))
+	}()
+	return l.Addr().String()
+}
+
+func TestClient_Err(t *testing.T) {
+	t.Parallel()
+	t.Run("server crash", func(t *testing.T) {
+		addr := startRawServer(func(conn net.Conn) { _ = conn.Close() })
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "failed to dial: %v", err)
+		select {
+		case <-client.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expect Done to be closed")
+		}
+		_assert(client.Err() == io.EOF, "expect io.EOF, got %v", client.Err())
+	})
+	t.Run("codec corruption", func(t *testing.T) {
+		addr := startRawServer(func(conn net.Conn) { _, _ = conn.Write([]byte("\x05hello")) })
+		client, _ := Dial("tcp", addr)
+		<-client.Done()
+		_assert(client.Err() != nil && strings.Contains(client.Err().Error(), "gob"), "expect a gob error, got %v", client.Err())
+		_ = client.Close()
+		_assert(client.Err() != ErrClientClosed, "the first reason should be kept")
+	})
+	t.Run("close", func(t *testing.T) {
+		addr := startRawServer(func(conn net.Conn) {})
+		client, _ := Dial("tcp", addr)
+		_assert(client.Err() == nil, "expect no error while available, got %v", client.Err())
+		_ = client.Close()
+		<-client.Done()
+		_assert(client.Err() == ErrClientClosed, "expect ErrClientClosed, got %v", client.Err())
+	})
+}
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(ms))
+	*reply = ms
+	return nil
+}
+
+func TestClient_CloseGracefully(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	t.Run("drain", func(t *testing.T) {
+		client, _ := Dial("tcp", l.Addr().String())
+		var reply int
+		call := client.Go("Slow.Sleep", 200, &reply, nil)
+		err := client.CloseGracefully(context.Background())
+		_assert(err == nil, "failed to close gracefully: %v", err)
+		<-call.Done
+		_assert(call.Error == nil && reply == 200, "expect the pending call to complete, got %v", call.Error)
+		_assert(!client.IsAvailable(), "expect a closed client")
+		err = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+		_assert(err == ErrShutdown, "expect new calls to be refused, got %v", err)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		client, _ := Dial("tcp", l.Addr().String())
+		call := client.Go("Slow.Sleep", 1000, new(int), nil)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		err := client.CloseGracefully(ctx)
+		_assert(err == context.DeadlineExceeded, "expect the deadline to expire, got %v", err)
+		<-call.Done
+		_assert(call.Error != nil, "expect the pending call to fail")
+	})
+}
+
+func pendingCount(client *Client) int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
+}
+
+func TestClient_MaxPendingCalls(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	t.Run("block", func(t *testing.T) {
+		client, _ := Dial("tcp", l.Addr().String(), &Option{MaxPendingCalls: 100})
+		defer func() { _ = client.Close() }()
+		stop := make(chan struct{})
+		maxSeen := make(chan int)
+		go func() {
+			max := 0
+			for {
+				select {
+				case <-stop:
+					maxSeen <- max
+					return
+				default:
+				}
+				if n := pendingCount(client); n > max {
+					max = n
+				}
+			}
+		}()
+		calls := make([]*Call, 10000)
+		for i := range calls {
+			calls[i] = client.Go("Slow.Sleep", 10, new(int), make(chan *Call, 1))
+		}
+		for _, call := range calls {
+			<-call.Done
+			_assert(call.Error == nil, "failed to call Slow.Sleep: %v", call.Error)
+		}
+		close(stop)
+		max := <-maxSeen
+		_assert(max <= 100, "expect at most 100 pending calls, got %d", max)
+	})
+	t.Run("fail", func(t *testing.T) {
+		client, _ := Dial("tcp", l.Addr().String(), &Option{MaxPendingCalls: 10, FailOnMaxPending: true})
+		defer func() { _ = client.Close() }()
+		var rejected int
+		for i := 0; i < 20; i++ {
+			call := client.Go("Slow.Sleep", 500, new(int), nil)
+			if call.Error == ErrTooManyPendingCalls {
+				rejected++
+			}
+		}
+		_assert(rejected == 10, "expect 10 rejected calls, got %d", rejected)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		err := client.Call(ctx, "Slow.Sleep", 0, new(int))
+		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect Call to wait until ctx is done, got %v", err)
+		err = client.Call(context.Background(), "Slow.Sleep", 0, new(int))
+		_assert(err == nil, "expect Call to get a slot once calls complete, got %v", err)
+	})
+	t.Run("never responding", func(t *testing.T) {
+		addr := startCodecServer(func(cc codec.Codec) {
+			var h codec.Header
+			for cc.ReadHeader(&h) == nil {
+				_ = cc.ReadBody(nil)
+			}
+		})
+		client, _ := Dial("tcp", addr, &Option{MaxPendingCalls: 3})
+		for i := 0; i < 3; i++ {
+			client.Go("Slow.Sleep", 0, new(int), nil)
+		}
+		blocked := make(chan *Call, 1)
+		go func() { blocked <- client.Go("Slow.Sleep", 0, new(int), nil) }()
+		select {
+		case <-blocked:
+			t.Fatal("expect the 4th call to block")
+		case <-time.After(time.Millisecond * 100):
+		}
+		_assert(pendingCount(client) == 3, "expect 3 pending calls, got %d", pendingCount(client))
+		_ = client.Close()
+		call := <-blocked
+		_assert(call.Error == ErrShutdown, "expect the blocked call to fail on Close, got %v", call.Error)
+	})
+}
+
+func TestClient_SeqWraparound(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	stale := &Call{ServiceMethod: "Foo.Sum", Done: make(chan *Call, 1)}
+	client.mu.Lock()
+	client.seq = math.MaxUint64
+	client.pending[1] = stale
+	client.mu.Unlock()
+
+	var seqs []uint64
+	for i := 0; i < 2; i++ {
+		var reply int
+		call := client.Go("Foo.Sum", Args{Num1: i, Num2: 1}, &reply, nil)
+		<-call.Done
+		_assert(call.Error == nil && reply == i+1, "failed to call Foo.Sum: %v", call.Error)
+		seqs = append(seqs, call.Seq)
+	}
+	// 0 is skipped and 1 is still pending
+	_assert(seqs[0] == math.MaxUint64 && seqs[1] == 2, "unexpected seqs %v", seqs)
+	_assert(len(stale.Done) == 0, "expect the stale call to be left alone")
+}
+
+func TestClient_SeqGenerator(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// shared by both clients, the seqs are unique across them and not ordered
+	var n uint64
+	gen := func() uint64 { return atomic.AddUint64(&n, 1) * 0x9E3779B97F4A7C15 }
+	var mu sync.Mutex
+	seqs := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for c := 0; c < 2; c++ {
+		client, err := DialWith("tcp", l.Addr().String(), WithSeqGenerator(gen))
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				call := <-client.Go("Foo.Sum", Args{Num1: i, Num2: i}, &reply, nil).Done
+				_assert(call.Error == nil && reply == 2*i, "expect the reply of call %d, got %d %v", i, reply, call.Error)
+				mu.Lock()
+				_assert(!seqs[call.Seq], "duplicate seq %d", call.Seq)
+				seqs[call.Seq] = true
+				mu.Unlock()
+			}(i)
+		}
+	}
+	wg.Wait()
+	_assert(len(seqs) == 40 && atomic.LoadUint64(&n) == 40, "expect every seq to come from the generator, got %d of %d", len(seqs), n)
+
+	_, err := DialWith("tcp", l.Addr().String(), WithSeqGenerator(nil))
+	_assert(err != nil, "expect an error for a nil generator")
+}
+
+func TestClient_MismatchedResponse(t *testing.T) {
+	t.Parallel()
+	addr := startCodecServer(func(cc codec.Codec) {
+		var h codec.Header
+		for cc.ReadHeader(&h) == nil {
+			_ = cc.ReadBody(nil)
+			h.ServiceMethod = "Foo.Other"
+			_ = cc.Write(&h, 42)
+			_ = cc.Flush()
+		}
+	})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "protocol error"), "expect a protocol error, got %v", err)
+	_assert(reply == 0, "expect the reply to be left alone, got %d", reply)
+	_assert(client.IsAvailable(), "expect the client to stay usable")
+}
+
+// pipeClient returns a client talking to serve over a net.Pipe,
+// without the option exchange.
+func pipeClient(serve func(cc codec.Codec)) *Client {
+	c, s := net.Pipe()
+	go serve(codec.NewGobCodec(s))
+	return newClientCodec(codec.NewGobCodec(c), DefaultOption)
+}
+
+func TestClient_OrphanedResponses(t *testing.T) {
+	t.Parallel()
+	sum := func(client *Client, a, b int) (int, error) {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: a, Num2: b}, &reply)
+		return reply, err
+	}
+	t.Run("duplicate", func(t *testing.T) {
+		client := pipeClient(func(cc codec.Codec) {
+			var h codec.Header
+			for cc.ReadHeader(&h) == nil {
+				var args Args
+				_ = cc.ReadBody(&args)
+				// answer every call three times
+				for i := 0; i < 3; i++ {
+					_ = cc.Write(&h, args.Num1+args.Num2)
+				}
+				_ = cc.Flush()
+			}
+		})
+		defer func() { _ = client.Close() }()
+		reply, err := sum(client, 1, 2)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+		// the duplicates are read after the reply, check on a second call
+		reply, err = sum(client, 2, 2)
+		_assert(err == nil && reply == 4, "expect the duplicates not to be mistaken for this reply, got %d: %v", reply, err)
+		s := client.Stats()
+		_assert(s.Duplicate >= 2 && s.Late == 0 && s.Unknown == 0, "unexpected stats %+v", s)
+		_assert(client.IsAvailable(), "expect duplicates not to break the connection")
+	})
+	t.Run("late", func(t *testing.T) {
+		release := make(chan struct{})
+		client := pipeClient(func(cc codec.Codec) {
+			var h codec.Header
+			for cc.ReadHeader(&h) == nil {
+				var args Args
+				_ = cc.ReadBody(&args)
+				if args.Num1 == 0 {
+					<-release
+				}
+				_ = cc.Write(&h, args.Num1+args.Num2)
+				_ = cc.Flush()
+			}
+		})
+		defer func() { _ = client.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		var reply int
+		err := client.Call(ctx, "Foo.Sum", Args{Num1: 0, Num2: 1}, &reply)
+		_assert(err != nil, "expect the call to time out")
+		close(release)
+		r, err := sum(client, 1, 2)
+		_assert(err == nil && r == 3, "failed to call Foo.Sum: %v", err)
+		s := client.Stats()
+		_assert(s.Late == 1 && s.Duplicate == 0 && s.Unknown == 0, "unexpected stats %+v", s)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		client := pipeClient(func(cc codec.Codec) {
+			var h codec.Header
+			for cc.ReadHeader(&h) == nil {
+				_ = cc.ReadBody(nil)
+				h.Seq += 100
+				_ = cc.Write(&h, 0)
+				_ = cc.Flush()
+			}
+		})
+		defer func() { _ = client.Close() }()
+		_, err := sum(client, 1, 2)
+		_assert(errors.Is(err, ErrProtocol), "expect a protocol error, got %v", err)
+		<-client.Done()
+		_assert(errors.Is(client.Err(), ErrProtocol), "expect the connection to break with a protocol error, got %v", client.Err())
+		late, unknown := client.OrphanedResponses()
+		_assert(late == 0 && unknown == 1, "expect 1 unknown response, got %d late %d unknown", late, unknown)
+	})
+	t.Run("unknown stream item", func(t *testing.T) {
+		client := pipeClient(func(cc codec.Codec) {
+			var h codec.Header
+			for cc.ReadHeader(&h) == nil {
+				_ = cc.ReadBody(nil)
+				h.Seq += 100
+				h.Stream = true
+				_ = cc.Write(&h, 0)
+				_ = cc.Flush()
+			}
+		})
+		defer func() { _ = client.Close() }()
+		_, err := sum(client, 1, 2)
+		_assert(errors.Is(err, ErrProtocol), "expect a protocol error, got %v", err)
+		<-client.Done()
+		_assert(errors.Is(client.Err(), ErrProtocol), "expect the connection to break with a protocol error, got %v", client.Err())
+		_, unknown := client.OrphanedResponses()
+		_assert(unknown == 1, "expect 1 unknown stream item, got %d", unknown)
+	})
+}
+
+func TestClient_NilReply(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, nil)
+	_assert(err == nil, "expect no error for a nil reply, got %v", err)
+	call := <-client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, (*int)(nil), nil).Done
+	_assert(call.Error == nil, "expect no error for a nil pointer reply, got %v", call.Error)
+	_assert(numCalls(server, "Foo", "Sum") == 2, "expect 2 calls on server, got %d", numCalls(server, "Foo", "Sum"))
+
+	// the discarded bodies must not leave the connection out of sync
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 3}, &reply)
+	_assert(err == nil && reply == 5, "failed to call Foo.Sum: %v", err)
+}
+
+func TestNewClientWithCodec_Close(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	c, sc := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		server.ServeCodec(codec.NewGobCodec(sc))
+		close(served)
+	}()
+	client := NewClientWithCodec(codec.NewGobCodec(c), nil)
+	_assert(client.ID() == DefaultOption.ClientID, "expect the default options, got ID %q", client.ID())
+
+	var reply int
+	call := client.Go("Slow.Sleep", 200, &reply, nil)
+	_assert(client.Close() == nil && client.Close() == ErrShutdown, "expect Close to behave as with Dial")
+	<-call.Done
+	_assert(call.Error != nil, "expect the pending call to fail")
+	<-client.Done()
+	_assert(client.Err() == ErrClientClosed, "expect ErrClientClosed, got %v", client.Err())
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("expect ServeCodec to return once the client is closed and the call is handled")
+	}
+}
+
+func TestClient_IllFormedMethod(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	for _, name := range []string{"FooSum", "", "Foo.", ".Sum"} {
+		call := client.Go(name, Args{Num1: 1, Num2: 2}, &reply, nil)
+		select {
+		case <-call.Done:
+			_assert(errors.Is(call.Error, ErrIllFormedMethod), "expect %q to be rejected, got %v", name, call.Error)
+		default:
+			t.Fatalf("expect %q to fail before being sent", name)
+		}
+	}
+	err := client.Call(context.Background(), "FooSum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrIllFormedMethod), "expect Call to be rejected, got %v", err)
+	err = client.Notify("FooSum", Args{Num1: 1, Num2: 2})
+	_assert(errors.Is(err, ErrIllFormedMethod), "expect Notify to be rejected, got %v", err)
+	_assert(numCalls(server, "Foo", "Sum") == 0 && server.CallsByClient()[client.ID()] == 0, "expect nothing to reach the server")
+
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the client to stay usable: %v", err)
+}
+
+func TestClient_DialKeepsOption(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	server.HandleHTTP("/_goRPC_/keeps_option")
+	tcp, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(tcp)
+	web, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() { _ = http.Serve(web, nil) }()
+	before := *DefaultOption
+
+	// dials without options share DefaultOption, run them with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client, err := DialHTTPPath("tcp", web.Addr().String(), "/_goRPC_/keeps_option")
+			_assert(err == nil, "failed to dial: %v", err)
+			_ = client.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			client := NewLazyClient("tcp", tcp.Addr().String())
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+			_ = client.Close()
+		}()
+	}
+	wg.Wait()
+	_assert(DefaultOption.CodecType == before.CodecType && DefaultOption.ClientID == before.ClientID,
+		"expect DefaultOption to be unchanged, got %+v", *DefaultOption)
+
+	// the caller's Option is not modified either, the negotiated codec is kept on the client
+	conn, _ := net.Dial("tcp", tcp.Addr().String())
+	opt := &Option{MagicNumber: MagicNumber, Version: ProtocolVersion, AcceptedCodecs: []codec.Type{codec.JsonType}}
+	client, err := NewClient(conn, opt)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(opt.CodecType == "" && client.codec == codec.JsonType, "expect json on the client only, got %q and %q", opt.CodecType, client.codec)
+}
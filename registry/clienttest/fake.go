@@ -0,0 +1,166 @@
+// Package clienttest provides a fake registry.Caller for unit testing
+// code that makes RPC calls, without running a server.
+//
+// Make the code under test depend on registry.Caller instead of
+// *registry.Client, then script the responses it should see:
+//
+//	fake := clienttest.NewFakeCaller()
+//	fake.On("Foo.Sum", clienttest.Response{Reply: 3})
+//	fake.On("Foo.Fail", clienttest.Response{Err: errors.New("boom")})
+//	fake.On("Foo.Slow", clienttest.Response{Reply: 1, Delay: time.Second})
+//	useTheCaller(fake)
+//	calls := fake.Calls()
+//
+// Like the real client, the fake copies the scripted reply into the
+// caller's reply through an encoding round trip, so callers never share
+// memory with the script, and a scripted error leaves the reply untouched.
+package clienttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"goRPC/registry"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Response is a scripted outcome of a call. Err takes precedence over
+// Reply, which must be a value of the type the caller's reply points to.
+// Delay holds the call back, as a slow server would.
+type Response struct {
+	Reply interface{}
+	Err   error
+	Delay time.Duration
+}
+
+// Invocation records a call made through a FakeCaller.
+type Invocation struct {
+	ServiceMethod string
+	Args          interface{}
+}
+
+// FakeCaller is a registry.Caller returning scripted responses.
+// It is safe for concurrent use.
+type FakeCaller struct {
+	mu        sync.Mutex
+	responses map[string][]Response
+	calls     []Invocation
+	closed    bool
+}
+
+var _ registry.Caller = (*FakeCaller)(nil)
+
+// NewFakeCaller returns a FakeCaller with nothing scripted.
+func NewFakeCaller() *FakeCaller {
+	return &FakeCaller{responses: make(map[string][]Response)}
+}
+
+// On scripts the responses to the next calls to serviceMethod, in order.
+// The last response is repeated once the others are used up.
+func (f *FakeCaller) On(serviceMethod string, responses ...Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[serviceMethod] = append(f.responses[serviceMethod], responses...)
+}
+
+// Calls returns the calls made so far, in order.
+func (f *FakeCaller) Calls() []Invocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Invocation(nil), f.calls...)
+}
+
+// next records a call and returns its scripted response.
+func (f *FakeCaller) next(serviceMethod string, args interface{}) (Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return Response{}, registry.ErrShutdown
+	}
+	f.calls = append(f.calls, Invocation{ServiceMethod: serviceMethod, Args: args})
+	rs := f.responses[serviceMethod]
+	if len(rs) == 0 {
+		return Response{}, fmt.Errorf("clienttest: no response scripted for %s", serviceMethod)
+	}
+	if len(rs) > 1 {
+		f.responses[serviceMethod] = rs[1:]
+	}
+	return rs[0], nil
+}
+
+// Call returns the next scripted response to serviceMethod.
+func (f *FakeCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	r, err := f.next(serviceMethod, args)
+	if err != nil {
+		return err
+	}
+	if r.Delay > 0 {
+		t := time.NewTimer(r.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		}
+	}
+	if r.Err != nil {
+		return r.Err
+	}
+	return copyReply(reply, r.Reply)
+}
+
+// Go calls Call asynchronously.
+func (f *FakeCaller) Go(serviceMethod string, args, reply interface{}, done chan *registry.Call) *registry.Call {
+	if done == nil {
+		done = make(chan *registry.Call, 10)
+	} else if cap(done) == 0 {
+		panic("rpc client: done channel is unbuffered")
+	}
+	call := &registry.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	go func() {
+		call.Error = f.Call(context.Background(), serviceMethod, args, reply)
+		done <- call
+	}()
+	return call
+}
+
+// Close makes further calls fail with registry.ErrShutdown.
+func (f *FakeCaller) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return registry.ErrShutdown
+	}
+	f.closed = true
+	return nil
+}
+
+// IsAvailable reports whether Close hasn't been called.
+func (f *FakeCaller) IsAvailable() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.closed
+}
+
+// copyReply decodes a gob encoding of v into reply, as the real client
+// decodes the response body. A nil reply, or nil pointer, discards v.
+func copyReply(reply, v interface{}) error {
+	if reply == nil || v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(reply); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return errors.New("reading body " + err.Error())
+	}
+	if err := gob.NewDecoder(&buf).Decode(reply); err != nil {
+		return errors.New("reading body " + err.Error())
+	}
+	return nil
+}
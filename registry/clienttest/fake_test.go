@@ -0,0 +1,107 @@
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"goRPC/registry"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Pair struct {
+	Keys []string
+	N    int
+}
+
+// sum stands for application code depending on a Caller.
+func sum(c registry.Caller, a, b int) (int, error) {
+	var reply int
+	err := c.Call(context.Background(), "Foo.Sum", [2]int{a, b}, &reply)
+	return reply, err
+}
+
+func TestFakeCaller_Script(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeCaller()
+	fake.On("Foo.Sum", Response{Reply: 3}, Response{Reply: 7})
+	for _, want := range []int{3, 7, 7} {
+		if got, err := sum(fake, 1, 2); err != nil || got != want {
+			t.Fatalf("expect %d, got %d: %v", want, got, err)
+		}
+	}
+	calls := fake.Calls()
+	if len(calls) != 3 || calls[0].ServiceMethod != "Foo.Sum" || calls[0].Args != [2]int{1, 2} {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	if err := fake.Call(context.Background(), "Foo.Unknown", 0, nil); err == nil || !strings.Contains(err.Error(), "Foo.Unknown") {
+		t.Fatalf("expect an error for an unscripted method, got %v", err)
+	}
+}
+
+func TestFakeCaller_ReplyCopy(t *testing.T) {
+	t.Parallel()
+	scripted := Pair{Keys: []string{"a"}, N: 1}
+	fake := NewFakeCaller()
+	fake.On("Store.Get", Response{Reply: scripted})
+	var reply Pair
+	if err := fake.Call(context.Background(), "Store.Get", 0, &reply); err != nil || !reflect.DeepEqual(reply, scripted) {
+		t.Fatalf("expect %+v, got %+v: %v", scripted, reply, err)
+	}
+	reply.Keys[0] = "b"
+	if scripted.Keys[0] != "a" {
+		t.Fatal("the reply must not share memory with the script")
+	}
+	if err := fake.Call(context.Background(), "Store.Get", 0, (*Pair)(nil)); err != nil {
+		t.Fatalf("expect a nil reply to discard the response, got %v", err)
+	}
+	var wrong string
+	if err := fake.Call(context.Background(), "Store.Get", 0, &wrong); err == nil || !strings.HasPrefix(err.Error(), "reading body") {
+		t.Fatalf("expect a decoding error, got %v", err)
+	}
+}
+
+func TestFakeCaller_Error(t *testing.T) {
+	t.Parallel()
+	boom := errors.New("boom")
+	fake := NewFakeCaller()
+	fake.On("Foo.Sum", Response{Reply: 3, Err: boom})
+	reply := 42
+	if err := fake.Call(context.Background(), "Foo.Sum", 0, &reply); err != boom || reply != 42 {
+		t.Fatalf("expect the error to take precedence and leave the reply alone, got %d: %v", reply, err)
+	}
+}
+
+func TestFakeCaller_Delay(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeCaller()
+	fake.On("Foo.Slow", Response{Reply: 1, Delay: time.Millisecond * 200})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var reply int
+	if err := fake.Call(ctx, "Foo.Slow", 0, &reply); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("expect a timeout, got %v", err)
+	}
+
+	start := time.Now()
+	call := <-fake.Go("Foo.Slow", 0, &reply, nil).Done
+	if call.Error != nil || reply != 1 || time.Since(start) < time.Millisecond*200 {
+		t.Fatalf("expect a delayed reply, got %d after %s: %v", reply, time.Since(start), call.Error)
+	}
+}
+
+func TestFakeCaller_Close(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeCaller()
+	fake.On("Foo.Sum", Response{Reply: 3})
+	if !fake.IsAvailable() || fake.Close() != nil {
+		t.Fatal("expect the first Close to succeed")
+	}
+	if fake.IsAvailable() || fake.Close() != registry.ErrShutdown {
+		t.Fatal("expect the fake to be closed")
+	}
+	if _, err := sum(fake, 1, 2); err != registry.ErrShutdown {
+		t.Fatalf("expect ErrShutdown, got %v", err)
+	}
+}
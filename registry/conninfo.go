@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+)
+
+// ConnInfo 服务端一侧连接的信息，握手认证后建立，连接上的所有请求共享，不应修改
+type ConnInfo struct {
+	RemoteAddr net.Addr             // 客户端地址，连接不能提供时为nil
+	ClientID   string               // 客户端在握手时声明的标识，见ClientIDFromContext
+	Identity   string               // SetAuthFunc返回的身份或经过校验的客户端证书的CommonName，见IdentityFromContext
+	TLS        *tls.ConnectionState // TLS握手的结果，包括客户端证书，不是TLS连接时为nil
+}
+
+// connInfoKey 在连接上下文中保存ConnInfo的键
+type connInfoKey struct{}
+
+// withConnInfo 由连接及其上下文中的客户端标识和身份建立ConnInfo并保存在ctx中
+func withConnInfo(ctx context.Context, conn io.ReadWriteCloser) context.Context {
+	info := &ConnInfo{ClientID: ClientIDFromContext(ctx)}
+	info.Identity, _ = IdentityFromContext(ctx)
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		info.RemoteAddr = c.RemoteAddr()
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		state := c.ConnectionState()
+		info.TLS = &state
+	}
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFromContext 返回发起请求的连接的信息，ctx须来自接收context.Context的服务方法或Authenticate
+// 不接收context.Context的方法使用ConnInfoOf，ServeCodec提供服务的连接没有ConnInfo，ok为false
+func ConnInfoFromContext(ctx context.Context) (info ConnInfo, ok bool) {
+	p, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return *p, true
+}
+
+// inflight 不接收context.Context的方法正在处理的请求，以请求的回复指针为键
+// 服务端为每个请求分配新的回复，方法返回前该指针只属于这一个请求
+var inflight = struct {
+	sync.Mutex
+	m map[interface{}]*ConnInfo
+}{m: make(map[interface{}]*ConnInfo)}
+
+// ConnInfoOf 为形如func (T) M(args, *reply) error的方法提供发起请求的连接的信息，reply须是方法收到的回复指针
+// 只在方法返回前有效，可以被并发调用。回复类型大小为0时各请求的回复指针可能相同，无法区分请求，ok总为false
+func ConnInfoOf(reply interface{}) (info ConnInfo, ok bool) {
+	inflight.Lock()
+	p, ok := inflight.m[reply]
+	inflight.Unlock()
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return *p, true
+}
+
+// trackConnInfo 在方法处理期间登记回复所属连接的信息，返回取消登记的函数
+// 方法返回、panic或超时后仍在执行的方法结束时都须调用它，不需要登记时返回空操作
+func trackConnInfo(ctx context.Context, reply reflect.Value) func() {
+	info, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	if !ok || reply.Kind() != reflect.Ptr || reply.IsNil() || reply.Type().Elem().Size() == 0 {
+		return func() {}
+	}
+	key := reply.Interface()
+	inflight.Lock()
+	inflight.m[key] = info
+	inflight.Unlock()
+	return func() {
+		inflight.Lock()
+		delete(inflight.m, key)
+		inflight.Unlock()
+	}
+}
+
+// OnConnect 设置连接建立时的钩子，须在开始服务之前设置，f为nil时取消
+// f在握手认证通过后、回复握手结果前调用，返回错误时拒绝该连接，客户端的Dial返回包含该错误的握手错误
+// f在连接的处理协程中同步执行，不阻塞Accept，但会推迟该连接的握手，ServeCodec提供服务的连接不调用f
+func (server *Server) OnConnect(f func(info ConnInfo) error) {
+	server.onConnect = f
+}
+
+// OnDisconnect 设置连接结束时的钩子，须在开始服务之前设置，f为nil时取消
+// OnConnect接受的每个连接在ServeConn返回前恰好调用一次f，此时连接上的请求都已处理完毕，
+// reason为结束读取的错误，客户端关闭连接时为io.EOF，此外还可能是解码失败或空闲超时等错误
+func (server *Server) OnDisconnect(f func(info ConnInfo, reason error)) {
+	server.onDisconnect = f
+}
+
+// connect 调用OnConnect设置的钩子，拒绝连接时返回错误
+func (server *Server) connect(ctx context.Context) error {
+	info, ok := ConnInfoFromContext(ctx)
+	if server.onConnect == nil || !ok {
+		return nil
+	}
+	if err := server.onConnect(info); err != nil {
+		return fmt.Errorf("connection rejected: %w", err)
+	}
+	return nil
+}
+
+// disconnect 调用OnDisconnect设置的钩子
+func (server *Server) disconnect(ctx context.Context, reason error) {
+	info, ok := ConnInfoFromContext(ctx)
+	if server.onDisconnect == nil || !ok {
+		return
+	}
+	server.onDisconnect(info, reason)
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Callee 返回调用方连接的信息，Whoami接收ctx，Legacy不接收
+type Callee int
+
+type CallerInfo struct {
+	RemoteAddr, ClientID, Identity, CommonName string
+}
+
+func newCallerInfo(info ConnInfo) CallerInfo {
+	c := CallerInfo{RemoteAddr: info.RemoteAddr.String(), ClientID: info.ClientID, Identity: info.Identity}
+	if info.TLS != nil && len(info.TLS.PeerCertificates) > 0 {
+		c.CommonName = info.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return c
+}
+
+func (c Callee) Whoami(ctx context.Context, _ int, reply *CallerInfo) error {
+	if info, ok := ConnInfoFromContext(ctx); ok {
+		*reply = newCallerInfo(info)
+	}
+	return nil
+}
+
+func (c Callee) Legacy(ms int, reply *CallerInfo) error {
+	if ms < 0 {
+		panic("negative sleep")
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	if info, ok := ConnInfoOf(reply); ok {
+		*reply = newCallerInfo(info)
+	}
+	return nil
+}
+
+func TestServer_ConnInfo(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Callee))
+	server.SetAuthFunc(func(token string, _ net.Addr) (string, error) { return "user-" + token, nil })
+	authenticated := make(chan ConnInfo, 1)
+	server.Authenticate = func(ctx context.Context, _ string) error {
+		info, _ := ConnInfoFromContext(ctx)
+		authenticated <- info
+		return nil
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	conn, _ := net.Dial("tcp", l.Addr().String())
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, Version: ProtocolVersion, CodecType: codec.GobType, ClientID: "billing", AuthToken: "alice", AllowInsecureAuth: true})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	local := conn.LocalAddr().String()
+	info := <-authenticated
+	_assert(info.RemoteAddr.String() == local && info.Identity == "user-alice", "expect the ConnInfo in Authenticate, got %+v", info)
+
+	// concurrent calls on different connections each see their own connection
+	other, _ := Dial("tcp", l.Addr().String(), &Option{ClientID: "search", AuthToken: "bob", AllowInsecureAuth: true})
+	defer func() { _ = other.Close() }()
+	<-authenticated
+	done := make(chan error, 2)
+	for _, c := range []*Client{client, other} {
+		go func(c *Client) {
+			for i := 0; i < 100; i++ {
+				var reply CallerInfo
+				method := "Callee.Whoami"
+				if i%2 == 1 {
+					method = "Callee.Legacy"
+				}
+				if err := c.Call(context.Background(), method, 0, &reply); err != nil {
+					done <- err
+					return
+				}
+				want := CallerInfo{RemoteAddr: local, ClientID: "billing", Identity: "user-alice"}
+				if c == other {
+					want = CallerInfo{RemoteAddr: reply.RemoteAddr, ClientID: "search", Identity: "user-bob"}
+				}
+				_assert(reply == want && reply.RemoteAddr != "", "expect %+v, got %+v", want, reply)
+			}
+			done <- nil
+		}(c)
+	}
+	for i := 0; i < 2; i++ {
+		_assert(<-done == nil, "failed to call Callee")
+	}
+
+	// the lookup is removed after a panic and once a timed out method returns
+	err = client.Call(context.Background(), "Callee.Legacy", -1, new(CallerInfo))
+	_assert(err != nil && strings.Contains(err.Error(), "panic"), "expect the panic, got %v", err)
+	short, _ := Dial("tcp", l.Addr().String(), &Option{ClientID: "short", HandleTimeout: 10 * time.Millisecond, AllowInsecureAuth: true})
+	defer func() { _ = short.Close() }()
+	<-authenticated
+	err = short.Call(context.Background(), "Callee.Legacy", 50, new(CallerInfo))
+	_assert(ErrorCodeOf(err) == CodeDeadlineExceeded, "expect a timeout, got %v", err)
+	time.Sleep(100 * time.Millisecond)
+	// other tests run in parallel, count only the requests of this server's clients
+	inflight.Lock()
+	n := 0
+	for _, info := range inflight.m {
+		if info.ClientID == "billing" || info.ClientID == "short" {
+			n++
+		}
+	}
+	inflight.Unlock()
+	_assert(n == 0, "expect no request in flight, got %d", n)
+	_, ok := ConnInfoOf(new(CallerInfo))
+	_assert(!ok, "expect no ConnInfo for a reply not in flight")
+}
+
+func TestServer_ConnInfoTLS(t *testing.T) {
+	t.Parallel()
+	ca := newCert("test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert, clientCert := newCert("server", &ca), newCert("alice", &ca)
+
+	server := NewServer()
+	_ = server.Register(new(Callee))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.AcceptTLS(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	client, err := DialWith("tcp", l.Addr().String(), WithTLS(&tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+	}))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply CallerInfo
+	err = client.Call(context.Background(), "Callee.Whoami", 0, &reply)
+	_assert(err == nil && reply.CommonName == "alice" && reply.Identity == "alice", "expect the client certificate, got %+v: %v", reply, err)
+}
+
+func TestServer_OnConnect(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	events := make(chan string, 10)
+	server.OnConnect(func(info ConnInfo) error {
+		events <- "connect " + info.ClientID
+		if info.ClientID == "banned" {
+			return errors.New("client is banned")
+		}
+		return nil
+	})
+	server.OnDisconnect(func(info ConnInfo, reason error) {
+		events <- fmt.Sprintf("disconnect %s %v", info.ClientID, reason)
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-events:
+				_assert(got == w, "expect %q, got %q", w, got)
+			case <-time.After(time.Second):
+				t.Fatalf("expect %q", w)
+			}
+		}
+		select {
+		case got := <-events:
+			t.Fatalf("unexpected %q", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{ClientID: "worker"})
+	_assert(err == nil, "failed to dial: %v", err)
+	expect("connect worker")
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	_ = client.Close()
+	expect("disconnect worker EOF")
+
+	// a rejected connection is never served and not reported as disconnected
+	_, err = Dial("tcp", l.Addr().String(), &Option{ClientID: "banned"})
+	_assert(err != nil && strings.Contains(err.Error(), "client is banned"), "expect the rejection, got %v", err)
+	expect("connect banned")
+}
@@ -1,56 +1,105 @@
 package registry
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
+	"time"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	Uptime {{.Uptime}}, {{.ActiveConnections}} active connections, {{.RecoveredPanics}} recovered panics, {{.ReapedConnections}} reaped connections
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>ArgType</th><th align=center>ReplyType</th><th align=center>Calls</th><th align=center>Notifications</th>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{.Name}}{{.Signature}}</td>
+			<td align=left font=fixed>{{.ArgType}}</td>
+			<td align=left font=fixed>{{.ReplyType}}</td>
+			<td align=center>{{.Calls}}</td>
+			<td align=center>{{.Notifications}}</td>
 			</tr>
 		{{end}}
 		</table>
+	{{else}}
+	<hr>
+	No services registered
 	{{end}}
 	</body>
 	</html>`
 
 var debug = template.Must(template.New("RPC debug").Parse(debugText))
 
-type debugHTTP struct {
-	*Server
+// debugPage 调试页面的内容，?format=json时以JSON返回
+type debugPage struct {
+	Uptime            time.Duration
+	ActiveConnections int64
+	RecoveredPanics   uint64
+	ReapedConnections uint64         // 因握手或读取超时被关闭的连接数
+	Services          []debugService // 按服务名排序，没有注册服务时为空数组
 }
 
 type debugService struct {
-	Name string
-	Method map[string]*methodType
+	Name    string
+	Methods []debugMethod // 按方法名排序
+}
+
+// debugMethod 方法的签名和调用次数，类型为Go的类型名，不需要导入用户的包
+type debugMethod struct {
+	Name          string
+	Signature     string
+	ArgType       string
+	ReplyType     string
+	Calls         uint64
+	Notifications uint64
 }
 
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request)  {
-	var services []debugService
-	server.serviceMap.Range(func(namei, svci interface{}) bool {
-		svc := svci.(*service)
-		services = append(services,debugService{
-			Name: namei.(string),
-			Method: svc.method,
-		})
+// DebugHTTP 展示服务器的运行时间、活跃连接数、恢复的panic数以及各方法的类型和调用次数，HandleHTTP将其注册在/debug/goRPC
+// 每次请求时从注册的服务重新生成，?format=json时以JSON返回相同的内容，Uptime为纳秒数
+func (server *Server) DebugHTTP(w http.ResponseWriter, req *http.Request) {
+	services := make([]debugService, 0)
+	collect := func(namei, svci interface{}) bool {
+		s := debugService{Name: namei.(string)}
+		for name, m := range svci.(*service).method {
+			s.Methods = append(s.Methods, debugMethod{
+				Name:          name,
+				Signature:     m.Signature(),
+				ArgType:       m.ArgType.String(),
+				ReplyType:     m.ReplyType.String(),
+				Calls:         m.NumCalls(),
+				Notifications: m.NumNotifies(),
+			})
+		}
+		sort.Slice(s.Methods, func(i, j int) bool { return s.Methods[i].Name < s.Methods[j].Name })
+		services = append(services, s)
 		return true
-	})
-	err := debug.Execute(w, services)
-	if err != nil {
+	}
+	server.serviceMap.Range(collect)
+	server.funcMap.Range(collect)
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	page := debugPage{
+		Uptime:            time.Since(server.startTime()).Round(time.Second),
+		ActiveConnections: server.ActiveConnections(),
+		RecoveredPanics:   server.RecoveredPanics(),
+		ReapedConnections: server.ReapedConnections(),
+		Services:          services,
+	}
+	if req.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&page); err != nil {
+			server.logger().Errorf("rpc server: debug page error: %v", err)
+		}
+		return
+	}
+	if err := debug.Execute(w, &page); err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
-
-
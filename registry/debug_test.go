@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_DebugHTTP(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	for i := 0; i < 3; i++ {
+		_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+
+	w := httptest.NewRecorder()
+	server.DebugHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	page := w.Body.String()
+	_assert(strings.Contains(page, "Service Foo") && strings.Contains(page, "Sum(registry.Args, *int) error"), "expect Foo.Sum on the page:\n%s", page)
+	_assert(strings.Contains(page, "<td align=center>3</td>"), "expect 3 calls of Foo.Sum:\n%s", page)
+	_assert(strings.Contains(page, "1 active connections") && strings.Contains(page, "Uptime"), "expect the server stats:\n%s", page)
+}
+
+func TestServer_DebugHTTPJSON(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+
+	w := httptest.NewRecorder()
+	server.DebugHTTP(w, httptest.NewRequest("GET", defaultDebugPath+"?format=json", nil))
+	_assert(w.Header().Get("Content-Type") == "application/json", "expect json, got %q", w.Header().Get("Content-Type"))
+	var page debugPage
+	err := json.Unmarshal(w.Body.Bytes(), &page)
+	_assert(err == nil && len(page.Services) == 1 && page.Services[0].Name == "Foo", "expect the Foo service, got %+v (%v)", page, err)
+	var sum *debugMethod
+	for i, m := range page.Services[0].Methods {
+		if m.Name == "Sum" {
+			sum = &page.Services[0].Methods[i]
+		}
+	}
+	_assert(sum != nil && sum.ArgType == "registry.Args" && sum.ReplyType == "*int", "expect the types of Foo.Sum, got %+v", sum)
+	_assert(sum.Calls == 1, "expect 1 call of Foo.Sum, got %d", sum.Calls)
+
+	// no services at all
+	empty := NewServer()
+	w = httptest.NewRecorder()
+	empty.DebugHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	_assert(w.Code == 200 && strings.Contains(w.Body.String(), "No services registered"), "expect an empty page:\n%s", w.Body.String())
+	w = httptest.NewRecorder()
+	empty.DebugHTTP(w, httptest.NewRequest("GET", defaultDebugPath+"?format=json", nil))
+	_assert(strings.Contains(w.Body.String(), `"Services":[]`), "expect an empty list:\n%s", w.Body.String())
+}
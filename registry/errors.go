@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"goRPC/client/codec"
+	"strconv"
+	"strings"
+)
+
+// ErrorCode 响应头中错误的分类，随codec.Header.ErrorCode传给客户端，客户端据此判断错误的来源
+type ErrorCode int
+
+const (
+	CodeOK                ErrorCode = iota // 没有错误
+	CodeNotFound                           // 找不到服务、方法或回调
+	CodeInvalidArgument                    // 请求格式错误、参数无法解码或没有通过Validate
+	CodeDeadlineExceeded                   // 处理超时或方法的ctx到期
+	CodeInternal                           // 方法返回的其他错误或panic，旧版本的对端不发送错误码时也按此处理
+	CodeUnavailable                        // 服务端暂时无法处理，例如排队已满或超过速率限制，可以稍后重试
+	CodeUnauthenticated                    // 调用携带的令牌没有通过校验
+	CodeResourceExhausted                  // 方法的并发数或速率超过SetMethodLimit的限制，可以稍后重试
+)
+
+var errorCodeNames = [...]string{"OK", "NotFound", "InvalidArgument", "DeadlineExceeded", "Internal", "Unavailable", "Unauthenticated", "ResourceExhausted"}
+
+func (c ErrorCode) String() string {
+	if c >= 0 && int(c) < len(errorCodeNames) {
+		return errorCodeNames[c]
+	}
+	return "ErrorCode(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Error 客户端收到的服务端错误，Code来自响应头，Message与服务端错误的Error()相同
+// 服务方法返回的CodedError被包装在其中，CodedErrorCode(err)和IsRetryable仍然适用
+type Error struct {
+	Code    ErrorCode
+	Message string
+	err     error // 被包装的错误，可以为nil
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// withCode 以code包装err，错误信息不变
+func withCode(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), err: err}
+}
+
+// knownCode 将CodeOK和超出已定义范围的错误码归为CodeInternal
+func knownCode(c ErrorCode) ErrorCode {
+	if c <= CodeOK || int(c) >= len(errorCodeNames) {
+		return CodeInternal
+	}
+	return c
+}
+
+// ErrorCodeOf 返回err的错误码，err为nil时返回CodeOK
+// err链中没有*Error时，例如连接断开或没有发出的调用，返回CodeInternal
+func ErrorCodeOf(err error) ErrorCode {
+	var e *Error
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &e):
+		return e.Code
+	}
+	return CodeInternal
+}
+
+// errorCode 服务端为err选择错误码：*Error的错误码、实现了Code() int的错误给出的错误码、CodedError.ErrorCode，
+// ctx到期为CodeDeadlineExceeded，可重试的CodedError为CodeUnavailable，其余为CodeInternal，未定义的错误码按CodeInternal处理
+func errorCode(err error) ErrorCode {
+	var e *Error
+	var coder interface{ Code() int }
+	var coded *CodedError
+	switch {
+	case errors.As(err, &e):
+		return knownCode(e.Code)
+	case errors.As(err, &coder):
+		return knownCode(ErrorCode(coder.Code()))
+	case errors.As(err, &coded) && coded.ErrorCode != CodeOK:
+		return knownCode(coded.ErrorCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	case errors.As(err, &coded) && coded.Retryable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// setError 将err及其错误码写入响应头h，错误信息带有追踪ID
+func setError(h *codec.Header, err error) {
+	h.ErrorCode = int(errorCode(err))
+	h.Error = traceError(h, encodeError(err))
+}
+
+// responseError 还原响应头h中的错误，没有错误码的响应来自旧版本的对端，与未定义的错误码一样按CodeInternal处理
+// 还原出的CodedError的ErrorCode与响应头一致
+func responseError(h *codec.Header) error {
+	code := knownCode(ErrorCode(h.ErrorCode))
+	err := decodeError(untraceError(h))
+	if coded, ok := err.(*CodedError); ok {
+		coded.ErrorCode = code
+	}
+	return withCode(code, err)
+}
+
+// CodedError 带有机器可读错误码的错误，服务方法返回它（或包装了它的错误）时，
+// 错误码和是否可重试会随响应传给客户端，客户端用CodedErrorCode、IsRetryable和ErrorCodeOf判断
+type CodedError struct {
+	Code      string    // 错误码，只能包含字母、数字和'_'、'-'、'.'
+	ErrorCode ErrorCode // 响应头中的错误码，为CodeOK时可重试的错误按CodeUnavailable、其余按CodeInternal处理
+	Retryable bool      // 调用方是否可以重试，例如服务暂时不可用
+	Message   string
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+// CodeServerBusy 服务端排队的请求已达上限时返回的错误码，见Server.MaxQueuedRequests
+const CodeServerBusy = "server_busy"
+
+// ErrServerBusy 服务端排队的请求已达上限，请求没有被处理，可以稍后重试
+// 响应的错误码为CodeUnavailable，客户端也可以用CodedErrorCode(err) == CodeServerBusy判断
+var ErrServerBusy = &CodedError{Code: CodeServerBusy, ErrorCode: CodeUnavailable, Retryable: true, Message: "rpc server: server busy"}
+
+// CodeRateLimited 连接的请求速率超过Server.SetRateLimit的限制时返回的错误码
+const CodeRateLimited = "rate_limited"
+
+// ErrRateLimited 连接的请求速率超过限制，请求没有被处理，可以稍后重试
+// 响应的错误码为CodeUnavailable，客户端也可以用CodedErrorCode(err) == CodeRateLimited判断
+var ErrRateLimited = &CodedError{Code: CodeRateLimited, ErrorCode: CodeUnavailable, Retryable: true, Message: "rpc server: rate limited"}
+
+// CodeMethodLimited 方法的并发数或速率超过Server.SetMethodLimit的限制时返回的错误码
+const CodeMethodLimited = "method_limited"
+
+// ErrResourceExhausted 方法的并发数或速率超过限制，请求没有被处理，可以稍后重试
+// 响应的错误码为CodeResourceExhausted，客户端也可以用CodedErrorCode(err) == CodeMethodLimited判断
+var ErrResourceExhausted = &CodedError{Code: CodeMethodLimited, ErrorCode: CodeResourceExhausted, Retryable: true, Message: "rpc server: resource exhausted"}
+
+// NewCodedError 返回带有错误码的错误
+func NewCodedError(code string, retryable bool, message string) *CodedError {
+	return &CodedError{Code: code, Retryable: retryable, Message: message}
+}
+
+// CodedErrorCode 返回err链中CodedError的字符串错误码，没有时返回空字符串，响应头中的错误码见ErrorCodeOf
+func CodedErrorCode(err error) string {
+	var e *CodedError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// IsRetryable err链中存在可重试的CodedError时返回true
+func IsRetryable(err error) bool {
+	var e *CodedError
+	return errors.As(err, &e) && e.Retryable
+}
+
+// encodeError 将服务方法返回的错误编码为Header.Error
+// 带错误码时格式为"[code <code>] <message>"，可重试时为"[code <code> retryable] <message>"
+func encodeError(err error) string {
+	var e *CodedError
+	if !errors.As(err, &e) || !validCode(e.Code) {
+		return err.Error()
+	}
+	prefix := "[code " + e.Code
+	if e.Retryable {
+		prefix += " retryable"
+	}
+	return prefix + "] " + err.Error()
+}
+
+// decodeError 还原encodeError编码的错误，没有错误码时返回普通错误
+func decodeError(msg string) error {
+	if strings.HasPrefix(msg, "[code ") {
+		if end := strings.Index(msg, "] "); end > 0 {
+			fields := strings.Fields(msg[len("[code "):end])
+			if (len(fields) == 1 || len(fields) == 2 && fields[1] == "retryable") && validCode(fields[0]) {
+				return &CodedError{Code: fields[0], Retryable: len(fields) == 2, Message: msg[end+2:]}
+			}
+		}
+	}
+	return errors.New(msg)
+}
+
+func validCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	for _, r := range code {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
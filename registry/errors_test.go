@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+type Flaky int
+
+func (f Flaky) Get(key string, reply *int) error {
+	switch key {
+	case "busy":
+		return fmt.Errorf("get %s: %w", key, NewCodedError("UNAVAILABLE", true, "server busy"))
+	case "missing":
+		return NewCodedError("NOT_FOUND", false, "no such key")
+	case "invalid":
+		return fmt.Errorf("get %s: %w", key, invalidKey{})
+	case "unknown":
+		return unknownCode{}
+	case "gone":
+		return &CodedError{Code: "gone", ErrorCode: CodeNotFound, Message: "key is gone"}
+	case "slow":
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return errors.New("plain failure")
+}
+
+// invalidKey carries its own error code.
+type invalidKey struct{}
+
+func (invalidKey) Error() string { return "invalid key" }
+func (invalidKey) Code() int     { return int(CodeInvalidArgument) }
+
+// unknownCode carries a code outside the defined ones.
+type unknownCode struct{}
+
+func (unknownCode) Error() string { return "unknown code" }
+func (unknownCode) Code() int     { return 99 }
+
+func TestCodedError_RoundTrip(t *testing.T) {
+	t.Parallel()
+	var f Flaky
+	server := NewServer()
+	_ = server.Register(&f)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Flaky.Get", "busy", &reply)
+	_assert(IsRetryable(err) && CodedErrorCode(err) == "UNAVAILABLE" && err.Error() == "get busy: server busy",
+		"expect a retryable coded error, got %q %q", CodedErrorCode(err), err)
+	err = client.Call(context.Background(), "Flaky.Get", "missing", &reply)
+	_assert(!IsRetryable(err) && CodedErrorCode(err) == "NOT_FOUND" && err.Error() == "no such key",
+		"expect a coded error, got %q %q", CodedErrorCode(err), err)
+	err = client.Call(context.Background(), "Flaky.Get", "other", &reply)
+	_assert(!IsRetryable(err) && CodedErrorCode(err) == "" && err.Error() == "plain failure", "expect a plain error, got %v", err)
+}
+
+func TestDecodeError(t *testing.T) {
+	t.Parallel()
+	for msg, code := range map[string]string{
+		"[code A.b-1_2] m":         "A.b-1_2",
+		"[code X retryable] m":     "X",
+		"[code] m":                 "",
+		"[code X Y] m":             "",
+		"[code X retryable Y] m":   "",
+		"[code bad!code] m":        "",
+		"[code X]m":                "",
+		"plain [code X retryable]": "",
+	} {
+		_assert(CodedErrorCode(decodeError(msg)) == code, "decoding %q: expect code %q, got %q", msg, code, CodedErrorCode(decodeError(msg)))
+	}
+	err := NewCodedError("bad code", true, "m")
+	_assert(encodeError(err) == "m", "expect an invalid code not to be encoded, got %q", encodeError(err))
+}
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+	var f Flaky
+	server := NewServer()
+	_ = server.Register(&f)
+	_ = server.Register(new(Slow))
+	_ = server.Register(new(Shapes))
+	server.Authenticate = func(ctx context.Context, token string) error {
+		if token != "" && token != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String(), &Option{HandleTimeout: 100 * time.Millisecond, AllowInsecureAuth: true})
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	var reply int
+	for _, c := range []struct {
+		method string
+		args   interface{}
+		ctx    context.Context
+		code   ErrorCode
+	}{
+		{"Flaky.Missing", "", ctx, CodeNotFound},
+		{"Nope.Get", "", ctx, CodeNotFound},
+		{"Flaky.Get", 1, ctx, CodeInvalidArgument},
+		{"Flaky.Get", "invalid", ctx, CodeInvalidArgument},
+		{"Flaky.Get", "slow", ctx, CodeDeadlineExceeded},
+		{"Slow.Sleep", 300, ctx, CodeDeadlineExceeded},
+		{"Flaky.Get", "busy", ctx, CodeUnavailable},
+		{"Flaky.Get", "gone", ctx, CodeNotFound},
+		{"Flaky.Get", "unknown", ctx, CodeInternal},
+		{"Flaky.Get", "other", ctx, CodeInternal},
+		{"Shapes.Fail", 0, ctx, CodeInternal},
+		{"Flaky.Get", "other", WithCallToken(ctx, "wrong"), CodeUnauthenticated},
+	} {
+		err := client.Call(c.ctx, c.method, c.args, &reply)
+		var e *Error
+		_assert(errors.As(err, &e) && e.Code == c.code && ErrorCodeOf(err) == c.code,
+			"expect %s(%v) to fail with %s, got %v", c.method, c.args, c.code, err)
+	}
+	// the coded error stays reachable behind the code
+	err := client.Call(ctx, "Flaky.Get", "busy", &reply)
+	_assert(IsRetryable(err) && err.Error() == "get busy: server busy", "expect the coded error to be wrapped, got %v", err)
+	_assert(ErrorCodeOf(nil) == CodeOK && ErrorCodeOf(errors.New("local")) == CodeInternal, "unexpected codes of local errors")
+
+	// peers without error codes
+	err = responseError(&codec.Header{Error: "[code X] failed"})
+	_assert(ErrorCodeOf(err) == CodeInternal && CodedErrorCode(err) == "X", "expect an old error to be internal, got %v", err)
+	err = responseError(&codec.Header{Error: "[code X] failed", ErrorCode: 99})
+	var coded *CodedError
+	_assert(ErrorCodeOf(err) == CodeInternal && errors.As(err, &coded) && coded.ErrorCode == CodeInternal, "expect an unknown code to be internal, got %v", err)
+
+	// the built-in coded errors carry the code they are answered with
+	for _, c := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{ErrServerBusy, CodeUnavailable},
+		{ErrRateLimited, CodeUnavailable},
+		{ErrResourceExhausted, CodeResourceExhausted},
+	} {
+		_assert(errorCode(c.err) == c.code, "expect %v to be %s, got %s", c.err, c.code, errorCode(c.err))
+	}
+}
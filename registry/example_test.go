@@ -0,0 +1,42 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	"goRPC/client/codec"
+	"goRPC/registry"
+	"net"
+	"sync"
+)
+
+type Calc int
+
+type Pair struct{ A, B int }
+
+func (c Calc) Mul(p Pair, reply *int) error {
+	*reply = p.A * p.B
+	return nil
+}
+
+// Both ends of a net.Pipe talk goRPC without dialing or exchanging options.
+func ExampleNewClientWithCodec() {
+	server := registry.NewServer()
+	_ = server.Register(new(Calc))
+	c, s := net.Pipe()
+	go server.ServeCodec(codec.NewGobCodec(s))
+	client := registry.NewClientWithCodec(codec.NewGobCodec(c), nil)
+	defer func() { _ = client.Close() }()
+
+	replies := make([]int, 4)
+	var wg sync.WaitGroup
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = client.Call(context.Background(), "Calc.Mul", Pair{A: i, B: i}, &replies[i])
+		}(i)
+	}
+	wg.Wait()
+	fmt.Println(replies)
+	// Output: [0 1 4 9]
+}
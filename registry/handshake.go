@@ -0,0 +1,217 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// handshakeReply 协议版本2起，服务端在Option之后回复的握手结果
+type handshakeReply struct {
+	CodecType codec.Type `json:",omitempty"` // 协商出的Codec，之后的消息都使用它编解码
+	CodecID   *codec.ID  `json:",omitempty"` // 客户端以编号发送Codec时，以编号代替CodecType
+	Error     string     // 握手失败的原因，失败后服务端关闭连接
+	ClientID  string     // 服务端清理后的客户端标识，见Option.ClientID
+	// Unauthenticated 为true时表示Authenticate拒绝了AuthToken，客户端返回ErrUnauthenticated
+	Unauthenticated bool
+	// AtCapacity 为true时表示服务端的连接数已达MaxConnections，客户端返回ErrServerAtCapacity
+	AtCapacity bool
+}
+
+// ErrServerAtCapacity 服务端设置了RejectOverflow且连接数已达MaxConnections，连接被拒绝
+var ErrServerAtCapacity = errors.New("rpc server: server at capacity")
+
+// refuseTimeout 拒绝连接时等待客户端发送Option并回复握手结果的时限
+const refuseTimeout = time.Millisecond * 500
+
+// maxClientIDLen 客户端标识的最大字节数，超出部分被截断
+const maxClientIDLen = 64
+
+// defaultClientID 客户端默认的标识，格式为hostname/pid
+func defaultClientID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// sanitizeClientID 截断过长的标识，并将不可打印字符和空白替换为'_'，便于写入日志
+func sanitizeClientID(id string) string {
+	if len(id) > maxClientIDLen {
+		id = id[:maxClientIDLen]
+		for !utf8.ValidString(id) {
+			id = id[:len(id)-1]
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return '_'
+		}
+		return r
+	}, id)
+}
+
+// clientIDKey 在请求上下文中保存客户端标识的键
+type clientIDKey struct{}
+
+// ClientIDFromContext 返回发起请求的客户端在握手时声明的标识，ctx须来自接收context.Context的服务方法
+func ClientIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey{}).(string)
+	return id
+}
+
+// checkVersion 校验协议版本，未携带版本号的旧客户端视为版本1
+func checkVersion(v uint8) error {
+	if v > ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d, expect at most %d", v, ProtocolVersion)
+	}
+	return nil
+}
+
+// negotiateCodec 从客户端可接受的Codec中选出第一个服务端支持的
+// 未设置AcceptedCodecs的客户端只能使用CodecID或CodecType
+func negotiateCodec(opt *Option) (codec.Type, error) {
+	if opt.CodecID != nil && len(opt.AcceptedCodecs) == 0 {
+		if t := codec.TypeByID[*opt.CodecID]; codec.NewCodecFuncByID[*opt.CodecID] != nil && codec.Lookup(t) != nil {
+			return t, nil
+		}
+		return "", fmt.Errorf("invalid codec id %d", *opt.CodecID)
+	}
+	types := opt.AcceptedCodecs
+	if len(types) == 0 {
+		types = []codec.Type{opt.CodecType}
+	}
+	for _, t := range types {
+		if codec.Lookup(t) != nil {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("invalid codec type %v", types)
+}
+
+// validateOption 以Server.OptionValidator检查并调整opt，校验失败或对版本1的客户端修改了Codec时返回错误
+func (server *Server) validateOption(opt *Option) error {
+	if server.OptionValidator == nil {
+		return nil
+	}
+	if opt.CodecID != nil {
+		if t, ok := codec.TypeByID[*opt.CodecID]; ok {
+			opt.CodecType = t
+		}
+	}
+	magic, version, requested := opt.MagicNumber, opt.Version, opt.CodecType
+	err := server.OptionValidator(opt)
+	opt.MagicNumber, opt.Version = magic, version
+	if err != nil {
+		server.logger().Infof("rpc server: option of client %q rejected: %v", opt.ClientID, err)
+		return err
+	}
+	if opt.CodecID != nil && opt.CodecType != requested {
+		// 按新的CodecType回复，没有编号的Codec以类型回复
+		if id, ok := codec.IDOf(opt.CodecType); ok {
+			opt.CodecID = &id
+		} else {
+			opt.CodecID = nil
+		}
+	}
+	if version < 2 && (opt.CodecType != requested || len(opt.AcceptedCodecs) > 0) {
+		return fmt.Errorf("codec of a version %d client can't be changed", version)
+	}
+	return nil
+}
+
+// replyHandshake 向版本2及以上的客户端回复握手结果，返回握手失败的原因或写入错误
+func replyHandshake(conn io.Writer, opt *Option, t codec.Type, err error) error {
+	if opt.Version >= 2 {
+		reply := handshakeReply{
+			CodecType:       t,
+			ClientID:        opt.ClientID,
+			Unauthenticated: err == ErrUnauthenticated,
+			AtCapacity:      err == ErrServerAtCapacity,
+		}
+		if id, ok := codec.IDOf(t); ok && opt.CodecID != nil {
+			reply.CodecType, reply.CodecID = "", &id
+		}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		if encErr := json.NewEncoder(conn).Encode(&reply); encErr != nil && err == nil {
+			return encErr
+		}
+	}
+	return err
+}
+
+// readHandshakeReply 读取服务端的握手结果，握手失败时返回错误
+func readHandshakeReply(dec *json.Decoder) (*handshakeReply, error) {
+	var reply handshakeReply
+	if err := dec.Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Unauthenticated {
+		return nil, ErrUnauthenticated
+	}
+	if reply.AtCapacity {
+		return nil, ErrServerAtCapacity
+	}
+	if reply.Error != "" {
+		return nil, errors.New("handshake rejected: " + reply.Error)
+	}
+	if reply.CodecID != nil {
+		reply.CodecType = codec.TypeByID[*reply.CodecID]
+	}
+	return &reply, nil
+}
+
+// handshakeConn 读取时先消费握手阶段缓冲的数据，写入和关闭直接作用于原连接
+// json解码器可能已经预读了握手之后的数据，需要交给Codec继续读取
+type handshakeConn struct {
+	io.Reader
+	conn    io.ReadWriteCloser
+	skipped bool // 是否已跳过Encoder在JSON之后写入的换行符
+}
+
+func newHandshakeConn(conn io.ReadWriteCloser, dec *json.Decoder) *handshakeConn {
+	return &handshakeConn{Reader: io.MultiReader(dec.Buffered(), conn), conn: conn}
+}
+
+// Read 跳过紧跟JSON的一个换行符，它可能已被预读，也可能仍在连接中
+// 之后的字节属于Codec，即使与空白字符相同也不能丢弃
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if !c.skipped && len(p) > 0 {
+		if _, err := io.ReadFull(c.Reader, p[:1]); err != nil {
+			return 0, err
+		}
+		c.skipped = true
+		if p[0] != '\n' {
+			return 1, nil
+		}
+	}
+	return c.Reader.Read(p)
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) { return c.conn.Write(p) }
+func (c *handshakeConn) Close() error                { return c.conn.Close() }
+
+// SetReadDeadline 和SetWriteDeadline转交给原连接，使Codec能设置截止时间
+func (c *handshakeConn) SetReadDeadline(t time.Time) error {
+	if conn, ok := c.conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *handshakeConn) SetWriteDeadline(t time.Time) error {
+	if conn, ok := c.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goRPC/client/codec"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandshake_NegotiateCodec(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// protobuf is not registered, the server falls back to gob
+	protobuf := codec.Type("application/protobuf")
+	client, err := Dial("tcp", l.Addr().String(), &Option{AcceptedCodecs: []codec.Type{protobuf, codec.GobType}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.codec == codec.GobType, "expect gob to be negotiated, got %s", client.codec)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	_, err = Dial("tcp", l.Addr().String(), &Option{AcceptedCodecs: []codec.Type{protobuf}})
+	_assert(err != nil && strings.Contains(err.Error(), "invalid codec type"), "expect a codec error, got %v", err)
+}
+
+func TestHandshake_JsonBody(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{AcceptedCodecs: []codec.Type{codec.JsonType, codec.GobType}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.codec == codec.JsonType, "expect json to be negotiated, got %s", client.codec)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	err = client.Call(context.Background(), "Foo.Missing", Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect the error in the gob header, got %v", err)
+}
+
+func TestHandshake_CodecID(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// the server answers a numeric ID with a numeric ID
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	id := codec.JsonID
+	_ = json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, Version: ProtocolVersion, CodecID: &id})
+	dec := json.NewDecoder(conn)
+	var raw map[string]interface{}
+	err = dec.Decode(&raw)
+	_assert(err == nil && raw["CodecID"] == float64(codec.JsonID) && raw["CodecType"] == nil, "expect the numeric form only, got %v (%v)", raw, err)
+	client := NewClientWithCodec(codec.NewJsonCodec(newHandshakeConn(conn, dec)), nil)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over json: %v", err)
+
+	// Dial offers the codec type by its ID and maps the reply back
+	dialed, err := Dial("tcp", l.Addr().String(), &Option{CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = dialed.Close() }()
+	_assert(dialed.codec == codec.JsonType, "expect json to be negotiated, got %s", dialed.codec)
+	err = dialed.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "failed to call Foo.Sum: %v", err)
+
+	unknown := codec.ID(200)
+	_, err = Dial("tcp", l.Addr().String(), &Option{CodecID: &unknown})
+	_assert(err != nil && strings.Contains(err.Error(), "invalid codec id"), "expect a codec error, got %v", err)
+}
+
+func TestHandshake_Version1(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// version 1 clients don't read a handshake reply
+	client, err := Dial("tcp", l.Addr().String(), &Option{Version: 1})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+}
+
+type Whoami int
+
+func (w Whoami) Get(ctx context.Context, _ int, reply *string) error {
+	*reply = ClientIDFromContext(ctx)
+	return nil
+}
+
+func TestHandshake_ClientID(t *testing.T) {
+	t.Parallel()
+	var w Whoami
+	server := NewServer()
+	_ = server.Register(&w)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.ID() == defaultClientID(), "expect the default ID, got %q", client.ID())
+
+	id := "worker 1\n" + strings.Repeat("x", 100)
+	client2, err := Dial("tcp", l.Addr().String(), &Option{ClientID: id})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client2.Close() }()
+	want := "worker_1_" + strings.Repeat("x", maxClientIDLen-9)
+	_assert(client2.ID() == want, "expect the sanitized ID, got %q", client2.ID())
+
+	var reply string
+	for i := 0; i < 2; i++ {
+		err = client2.Call(context.Background(), "Whoami.Get", 0, &reply)
+		_assert(err == nil && reply == want, "expect the handler to see %q, got %q: %v", want, reply, err)
+	}
+	_assert(server.CallsByClient()[want] == 2, "expect 2 calls by %q, got %v", want, server.CallsByClient())
+
+	// a lazy client reports the sanitized ID once connected, the shared Option keeps the announced one
+	opt := &Option{ClientID: "lazy worker"}
+	lazy := NewLazyClient("tcp", l.Addr().String(), opt)
+	defer func() { _ = lazy.Close() }()
+	_assert(lazy.ID() == "lazy worker", "expect the ID to announce before connecting, got %q", lazy.ID())
+	err = lazy.Call(context.Background(), "Whoami.Get", 0, &reply)
+	_assert(err == nil && reply == "lazy_worker", "expect the handler to see the sanitized ID, got %q: %v", reply, err)
+	_assert(lazy.ID() == "lazy_worker", "expect the sanitized ID, got %q", lazy.ID())
+	_assert(opt.ClientID == "lazy worker", "expect the Option to be unchanged, got %q", opt.ClientID)
+	shared := NewLazyClient("tcp", l.Addr().String(), opt)
+	defer func() { _ = shared.Close() }()
+	_assert(shared.ID() == "lazy worker", "expect no ID leaked from another connection, got %q", shared.ID())
+}
+
+func TestHandshakeConn_Deadline(t *testing.T) {
+	t.Parallel()
+	c, s := net.Pipe()
+	defer func() { _ = c.Close(); _ = s.Close() }()
+	dec := json.NewDecoder(s)
+	cc := codec.NewGobCodec(newHandshakeConn(s, dec)).(codec.DeadlineCodec)
+	_ = cc.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	var h codec.Header
+	err := cc.ReadHeader(&h)
+	var ne net.Error
+	_assert(errors.As(err, &ne) && ne.Timeout(), "expect the read to time out, got %v", err)
+}
+
+func TestServer_ServeConnNoHandshake(t *testing.T) {
+	t.Parallel()
+	var w Whoami
+	server := NewServer()
+	_ = server.Register(&w)
+	c, s := net.Pipe()
+	go server.ServeConnNoHandshake(s)
+
+	opt := &Option{SkipHandshake: true}
+	client, err := NewClient(c, opt)
+	_assert(err == nil, "failed to create the client: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.codec == codec.GobType, "expect gob without a handshake, got %q", client.codec)
+	var reply string
+	err = client.Call(context.Background(), "Whoami.Get", 0, &reply)
+	_assert(err == nil && reply == "", "expect no client ID without a handshake, got %q: %v", reply, err)
+
+	c2, s2 := net.Pipe()
+	defer func() { _ = s2.Close() }()
+	_, err = NewClient(c2, &Option{SkipHandshake: true, CodecType: codec.JsonType})
+	_assert(err != nil, "expect SkipHandshake to require the gob codec")
+
+	// an Option shared with later dials keeps its codec unresolved
+	c3, s3 := net.Pipe()
+	defer func() { _ = s3.Close() }()
+	hs, err := handshake(c3, opt)
+	_assert(err == nil && hs.codec == codec.GobType, "failed to skip the handshake: %v", err)
+	_assert(opt.CodecType == "", "expect the Option to be unchanged, got %q", opt.CodecType)
+	_ = hs.cc.Close()
+}
+
+func TestServer_OptionValidator(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.OptionValidator = func(opt *Option) error {
+		if opt.ClientID == "legacy" {
+			opt.CodecType, opt.AcceptedCodecs = codec.GobType, nil
+			return nil
+		}
+		if opt.CodecType == codec.JsonType {
+			return errors.New("json is not allowed")
+		}
+		opt.HandleTimeout = time.Second
+		return nil
+	}
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// the codec is sent by its ID, the validator sees its type
+	_, err := Dial("tcp", l.Addr().String(), &Option{CodecType: codec.JsonType})
+	_assert(err != nil && strings.Contains(err.Error(), "json is not allowed"), "expect json to be rejected, got %v", err)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+
+	// the codec chosen by the server is sent back
+	forced, err := Dial("tcp", l.Addr().String(), &Option{ClientID: "legacy", AcceptedCodecs: []codec.Type{codec.JsonType}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = forced.Close() }()
+	_assert(forced.codec == codec.GobType, "expect the server to force gob, got %s", forced.codec)
+	err = forced.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "failed to call Foo.Sum: %v", err)
+
+	// version 1 clients don't read the handshake reply, their codec can't change
+	v1, err := Dial("tcp", l.Addr().String(), &Option{ClientID: "legacy", Version: 1, CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = v1.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = v1.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && ctx.Err() == nil, "expect the version 1 client to be disconnected, got %v", err)
+}
+
+// countingBody 以JSON编码消息体并记录编码次数，用于确认连接使用了注册的Codec
+type countingBody struct{ n *int64 }
+
+func (b countingBody) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt64(b.n, 1)
+	return json.Marshal(v)
+}
+
+func (b countingBody) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func TestHandshake_RegisterCodec(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	custom := codec.Type("application/x-counting-json")
+	_, err := Dial("tcp", l.Addr().String(), &Option{CodecType: custom})
+	_assert(err != nil, "expect an unregistered codec to be rejected")
+	var encoded int64
+	err = codec.RegisterCodec(custom, codec.NewHeaderCodec(countingBody{n: &encoded}))
+	_assert(err == nil, "failed to register the codec: %v", err)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{CodecType: custom})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.codec == custom, "expect the custom codec to be negotiated, got %s", client.codec)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	_assert(atomic.LoadInt64(&encoded) == 2, "expect the request and the reply to use the custom codec, got %d", encoded)
+}
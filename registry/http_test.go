@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestServer_HandleHTTP(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	// the same server behind TCP and HTTP
+	tcp, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(tcp)
+	server.HandleHTTP(defaultRPCPath)
+	server.HandleHTTP("/_goRPC_/http_test")
+	web, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() { _ = http.Serve(web, nil) }()
+
+	dial := map[string]func() (*Client, error){
+		"tcp":       func() (*Client, error) { return Dial("tcp", tcp.Addr().String()) },
+		"http":      func() (*Client, error) { return DialHTTP("tcp", web.Addr().String()) },
+		"http path": func() (*Client, error) { return DialHTTPPath("tcp", web.Addr().String(), "/_goRPC_/http_test") },
+		"xdial":     func() (*Client, error) { return XDial("http@" + web.Addr().String()) },
+	}
+	for name, f := range dial {
+		client, err := f()
+		_assert(err == nil, "%s: failed to dial: %v", name, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "%s: failed to call Foo.Sum: %v", name, err)
+		_ = client.Close()
+	}
+	_, err := DialHTTPPath("tcp", web.Addr().String(), "/_goRPC_/missing")
+	_assert(err != nil, "expect an unknown path to be refused")
+
+	resp, err := http.Get("http://" + web.Addr().String() + defaultRPCPath)
+	_assert(err == nil && resp.StatusCode == http.StatusMethodNotAllowed, "expect GET to be refused, got %v", err)
+	_ = resp.Body.Close()
+	resp, err = http.Get("http://" + web.Addr().String() + "/_goRPC_/http_test/debug")
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "expect the debug page next to the rpc path, got %v", err)
+	_ = resp.Body.Close()
+}
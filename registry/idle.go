@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"errors"
+	"goRPC/client/codec"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultHandshakeTimeout 未设置Server.HandshakeTimeout时读取Option的时限
+	defaultHandshakeTimeout = 10 * time.Second
+	// defaultReadTimeout 未设置Server.ReadTimeout时空闲连接的最长存活时间
+	defaultReadTimeout = 10 * time.Minute
+)
+
+// handshakeTimeout 返回读取Option的时限
+func (server *Server) handshakeTimeout() time.Duration {
+	if server.HandshakeTimeout > 0 {
+		return server.HandshakeTimeout
+	}
+	return defaultHandshakeTimeout
+}
+
+// readTimeout 返回连接空闲的最长时间，IdleTimeout优先于ReadTimeout，0表示不设限
+func (server *Server) readTimeout() time.Duration {
+	switch {
+	case server.IdleTimeout > 0:
+		return server.IdleTimeout
+	case server.ReadTimeout > 0:
+		return server.ReadTimeout
+	case server.ReadTimeout < 0:
+		return 0
+	}
+	return defaultReadTimeout
+}
+
+// setReadDeadline 为支持截止时间的连接设置读取的截止时间，t为零值时清除
+func setReadDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if d, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		_ = d.SetReadDeadline(t)
+	}
+}
+
+// reap 记录一个因读取超时而关闭的连接，对端失效的半开连接可能很多，只以Debugf输出
+func (server *Server) reap(format string, v ...interface{}) {
+	atomic.AddUint64(&server.reaped, 1)
+	server.logger().Debugf(format, v...)
+}
+
+// ReapedConnections 返回因握手或读取超时被关闭的连接数，包括IdleTimeout关闭的空闲连接
+func (server *Server) ReapedConnections() uint64 {
+	return atomic.LoadUint64(&server.reaped)
+}
+
+// idleTimer 跟踪连接上进行中的请求，超时取自IdleTimeout或ReadTimeout
+// 没有进行中的请求时为读取设置截止时间，期间收到任何帧（包括客户端的心跳）都会重新计时，超时后连接被关闭
+// 有请求在处理时不设截止时间，最后一个请求完成后重新计时，因此耗时较长的请求不会被当作空闲
+type idleTimer struct {
+	cc      codec.DeadlineCodec
+	timeout time.Duration
+	mu      sync.Mutex
+	active  int // 进行中的请求数
+}
+
+// idleTimer 返回连接的空闲计时器，不设限或Codec不支持截止时间时为nil
+func (server *Server) idleTimer(cc codec.Codec) *idleTimer {
+	dc, ok := cc.(codec.DeadlineCodec)
+	timeout := server.readTimeout()
+	if timeout <= 0 || !ok {
+		return nil
+	}
+	return &idleTimer{cc: dc, timeout: timeout}
+}
+
+// arm 读取下一个请求头之前调用，没有进行中的请求时从现在开始计时
+func (t *idleTimer) arm() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == 0 {
+		_ = t.cc.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+}
+
+// begin 开始处理一个请求，连接不再空闲
+func (t *idleTimer) begin() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active++; t.active == 1 {
+		_ = t.cc.SetReadDeadline(time.Time{})
+	}
+}
+
+// end 一个请求处理完毕，没有进行中的请求时重新计时
+func (t *idleTimer) end() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active--; t.active == 0 {
+		_ = t.cc.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+}
+
+// isTimeout err是否为读写超时
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
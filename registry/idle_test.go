@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := &Server{IdleTimeout: 100 * time.Millisecond}
+	_ = server.Register(&foo)
+	_ = server.Register(new(Slow))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	idle, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = idle.Close() }()
+	active, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = active.Close() }()
+
+	// calls keep a connection alive well past the timeout
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		var reply int
+		err := active.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect the active connection to survive, got %v", err)
+		time.Sleep(30 * time.Millisecond)
+	}
+	// a request running longer than the timeout is not idleness
+	var reply int
+	err := active.Call(context.Background(), "Slow.Sleep", 300, &reply)
+	_assert(err == nil && reply == 300, "expect a long call to complete, got %v", err)
+	_assert(active.IsAvailable(), "expect the active connection to stay open")
+
+	select {
+	case <-idle.Done():
+	default:
+		t.Fatal("expect the idle connection to be closed")
+	}
+	select {
+	case <-active.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect the connection to be closed once it goes idle")
+	}
+}
+
+func TestServer_ReapHalfOpen(t *testing.T) {
+	t.Parallel()
+	logger := new(captureLogger)
+	var foo Foo
+	server := &Server{HandshakeTimeout: 100 * time.Millisecond, ReadTimeout: 200 * time.Millisecond, Logger: logger}
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	// a client that connects and never sends the option
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect the server to close the connection, got %v", err)
+
+	// a client that handshakes and then goes silent
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect the silent connection to be closed")
+	}
+	_assert(server.ReapedConnections() == 2, "expect 2 reaped connections, got %d", server.ReapedConnections())
+	_assert(len(logger.find("ERROR")) == 0, "expect no errors logged, got %v", logger.find("ERROR"))
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"errors"
+)
+
+// Invoker sends a call and waits for its reply.
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// ClientInterceptor wraps every call made by a Client. It may inspect or
+// modify args before calling next, which sends the call, and inspect or
+// modify reply once next returns. It must call next at most once.
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error
+
+// Use appends interceptors to the client's chain. The first interceptor
+// added is the outermost one. Use is meant to be called before the
+// client is shared; calls already started are not intercepted.
+func (client *Client) Use(interceptors ...ClientInterceptor) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+// intercept wraps invoker with the client's interceptors. It returns nil
+// if there are none.
+func (client *Client) intercept(invoker Invoker) Invoker {
+	client.mu.Lock()
+	interceptors := client.interceptors
+	client.mu.Unlock()
+	if len(interceptors) == 0 {
+		return nil
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// invoker returns the innermost Invoker, which acquires a pending slot,
+// sends the call and waits for its reply or ctx.
+func (client *Client) invoker(block bool) Invoker {
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		call := newCall(serviceMethod, args, reply, make(chan *Call, 1))
+		if id := TraceIDFromContext(ctx); id != "" {
+			call.TraceID = id
+		}
+		call.priority = callPriority(ctx)
+		call.deadline, _ = ctx.Deadline()
+		token := callToken(ctx)
+		if token != "" {
+			client.mu.Lock()
+			secure := client.secure
+			client.mu.Unlock()
+			if err := checkToken(token, secure, client.opt); err != nil {
+				return err
+			}
+		}
+		if err := client.acquireSlot(ctx, block); err != nil {
+			if err == ErrShutdown || err == ErrTooManyPendingCalls {
+				return err
+			}
+			return errors.New("rpc client: call failed: " + err.Error())
+		}
+		call.token = token
+		client.start(call)
+		select {
+		case <-ctx.Done():
+			client.removeCall(call.Seq)
+			client.logger().Errorf("rpc client: call %s (trace %s) failed: %v", serviceMethod, call.TraceID, ctx.Err())
+			return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		case call := <-call.Done:
+			if info := callInfo(ctx); info != nil {
+				if info.Enqueued.IsZero() {
+					info.Enqueued = call.Info.Enqueued
+				}
+				info.Sent, info.Received = call.Info.Sent, call.Info.Received
+			}
+			return call.Error
+		}
+	}
+}
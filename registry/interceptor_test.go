@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClient_Use(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var trace []string
+	client.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		trace = append(trace, "outer")
+		return next(ctx, serviceMethod, args, reply)
+	}, func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		a := args.(Args)
+		trace = append(trace, "inner "+serviceMethod)
+		// args may be replaced before the call is sent
+		a.Num2 *= 10
+		err := next(ctx, serviceMethod, a, reply)
+		*reply.(*int) += 100
+		return err
+	})
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 121, "expect reply 121, got %d: %v", reply, err)
+	_assert(strings.Join(trace, ",") == "outer,inner Foo.Sum", "unexpected trace %v", trace)
+
+	reply = 0
+	call := client.Go("Foo.Sum", Args{Num1: 1, Num2: 1}, &reply, nil)
+	<-call.Done
+	_assert(call.Error == nil && reply == 111, "expect reply 111, got %d: %v", reply, call.Error)
+	_assert(len(trace) == 4, "expect Go to be intercepted, got %v", trace)
+}
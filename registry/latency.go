@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBounds 请求耗时直方图各桶的上界，最后一个桶收集更慢的请求
+var latencyBounds = []time.Duration{
+	time.Millisecond,
+	time.Millisecond * 10,
+	time.Millisecond * 100,
+	time.Second,
+	time.Second * 10,
+}
+
+// LatencyStats 请求从读取完毕到发出响应的耗时统计，见Server.Latency
+type LatencyStats struct {
+	Count  uint64        // 统计的请求数
+	Slow   uint64        // 耗时达到SlowCallThreshold的请求数
+	Total  time.Duration // 耗时之和，除以Count得到平均耗时
+	Max    time.Duration // 最大耗时
+	Queued time.Duration // 等待处理的时间之和，包括在MaxWorkers的队列中排队的时间
+	// Buckets[i]为耗时不超过Bounds[i]且超过Bounds[i-1]的请求数，最后一个桶为更慢的请求
+	Buckets []uint64
+	Bounds  []time.Duration
+}
+
+// latencyRecorder 记录请求耗时，所有连接共用
+type latencyRecorder struct {
+	mu      sync.Mutex
+	count   uint64
+	slow    uint64
+	total   time.Duration
+	max     time.Duration
+	queued  time.Duration
+	buckets [6]uint64 // len(latencyBounds)+1
+}
+
+func (r *latencyRecorder) record(elapsed, queued time.Duration, slow bool) {
+	i := 0
+	for i < len(latencyBounds) && elapsed > latencyBounds[i] {
+		i++
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if slow {
+		r.slow++
+	}
+	r.total += elapsed
+	if elapsed > r.max {
+		r.max = elapsed
+	}
+	r.queued += queued
+	r.buckets[i]++
+}
+
+func (r *latencyRecorder) stats() LatencyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return LatencyStats{
+		Count:   r.count,
+		Slow:    r.slow,
+		Total:   r.total,
+		Max:     r.max,
+		Queued:  r.queued,
+		Buckets: append([]uint64(nil), r.buckets[:]...),
+		Bounds:  append([]time.Duration(nil), latencyBounds...),
+	}
+}
+
+// observe 在请求处理完毕后记录耗时，达到SlowCallThreshold时打印警告
+// handled为开始处理请求的时间，与读取完毕的时间之差为排队时间，耗时同时计入方法的直方图，见MetricsHandler
+func (server *Server) observe(req *request, handled time.Time) {
+	elapsed := time.Since(req.start)
+	queued := handled.Sub(req.start)
+	slow := server.SlowCallThreshold > 0 && elapsed >= server.SlowCallThreshold
+	if slow {
+		server.logger().Infof("rpc server: slow call %s (trace %s) took %s, queued %s", req.h.ServiceMethod, req.h.TraceID, elapsed, queued)
+	}
+	server.latency.record(elapsed, queued, slow)
+	if !req.h.Oneway {
+		req.mtype.latency.record(elapsed, req.h.Error != "")
+	}
+}
+
+// Latency 返回服务器启动以来请求耗时的统计
+func (server *Server) Latency() LatencyStats {
+	return server.latency.stats()
+}
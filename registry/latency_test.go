@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer collects log output written from other goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// not parallel, since it redirects the log output
+func TestServer_SlowCallThreshold(t *testing.T) {
+	var out lockedBuffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	var s Slow
+	server := &Server{SlowCallThreshold: time.Millisecond * 40}
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_ = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	_ = client.Call(context.Background(), "Slow.Sleep", 60, &reply)
+	// the latency is recorded right after the response is sent
+	for server.Latency().Count < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := server.Latency()
+	_assert(stats.Slow == 1, "expect 1 slow call, got %d", stats.Slow)
+	_assert(stats.Max >= time.Millisecond*60 && stats.Total >= stats.Max, "unexpected latency %+v", stats)
+	_assert(len(stats.Buckets) == len(stats.Bounds)+1, "expect a bucket per bound and one more")
+	_assert(stats.Buckets[2] >= 1 && stats.Buckets[0]+stats.Buckets[1]+stats.Buckets[2] == 2, "expect the slow call in the 100ms bucket, got %v", stats.Buckets)
+	logs := out.String()
+	_assert(strings.Count(logs, "slow call Slow.Sleep") == 1, "expect a single slow call warning, got %q", logs)
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"net"
+	"sync"
+)
+
+// lazyDial holds what is needed to connect a lazy client.
+type lazyDial struct {
+	once    sync.Once
+	network string
+	address string
+	err     error // result of the dial, shared by all callers
+}
+
+// NewLazyClient returns a client for the RPC server at the specified
+// network address without connecting to it. The connection and the
+// option exchange happen on the first Go or Call; if they fail, every
+// call waiting on that first dial fails with the same error.
+func NewLazyClient(network, address string, opts ...*Option) *Client {
+	client := &Client{
+		seq:     1, // seq starts with 1, 0 means invalid call
+		pending: make(map[uint64]*Call),
+		stopped: make(chan struct{}),
+		lazy:    &lazyDial{network: network, address: address},
+	}
+	opt, err := parseOptions(opts...)
+	client.opt, client.lazy.err = opt, err
+	if opt != nil {
+		client.id = opt.ClientID
+	}
+	client.slots = newSlots(opt)
+	return client
+}
+
+// connect dials the server the first time it is called.
+func (client *Client) connect() error {
+	l := client.lazy
+	l.once.Do(func() {
+		if l.err == nil {
+			l.err = client.dial()
+		}
+		if l.err != nil {
+			client.mu.Lock()
+			client.shutdown = true
+			client.stop(l.err)
+			client.setState(StateShutdown)
+			client.mu.Unlock()
+		}
+		client.notifyState()
+	})
+	return l.err
+}
+
+func (client *Client) dial() error {
+	client.mu.Lock()
+	closing := client.closing
+	client.mu.Unlock()
+	if closing {
+		return ErrShutdown
+	}
+	f := func(conn net.Conn, opt *Option) (*Client, error) {
+		hs, err := handshakeTimeout(conn, opt)
+		if err != nil {
+			return nil, err
+		}
+		return &Client{cc: hs.cc, codec: hs.codec, id: hs.clientID, secure: isSecure(conn)}, nil
+	}
+	c, err := dialTimeout(f, client.lazy.network, client.lazy.address, client.opt)
+	if err != nil {
+		return err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closing {
+		_ = c.cc.Close()
+		return ErrShutdown
+	}
+	client.cc, client.codec, client.secure = c.cc, c.codec, c.secure
+	client.id = c.id
+	client.setState(StateConnected)
+	go client.receive()
+	return nil
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener 统计被接受的连接数
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestNewLazyClient(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	tcp, _ := net.Listen("tcp", ":0")
+	l := &countingListener{Listener: tcp}
+	go server.Accept(l)
+
+	client := NewLazyClient("tcp", l.Addr().String())
+	_assert(client.IsAvailable(), "lazy client should be available before first use")
+	time.Sleep(time.Millisecond * 100)
+	_assert(atomic.LoadInt32(&l.accepted) == 0, "lazy client should not dial before first use")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply)
+			_assert(err == nil && reply == 2*i, "failed to call Foo.Sum: %v", err)
+		}(i)
+	}
+	wg.Wait()
+	_assert(atomic.LoadInt32(&l.accepted) == 1, "expect 1 connection, got %d", atomic.LoadInt32(&l.accepted))
+	_assert(client.Close() == nil && !client.IsAvailable(), "failed to close lazy client")
+}
+
+func TestNewLazyClient_DialError(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", ":0")
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	client := NewLazyClient("tcp", addr)
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = client.Call(context.Background(), "Foo.Sum", Args{}, nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		_assert(err != nil && err == errs[0], "expect the same dial error, got %v and %v", err, errs[0])
+	}
+	_assert(!client.IsAvailable(), "client should be unavailable after a failed dial")
+}
+
+func TestNewLazyClient_CloseUnused(t *testing.T) {
+	client := NewLazyClient("tcp", "127.0.0.1:1")
+	_assert(client.Close() == nil, "closing an unused lazy client should be a no-op")
+	err := client.Call(context.Background(), "Foo.Sum", Args{}, nil)
+	_assert(err == ErrShutdown, "expect ErrShutdown after close, got %v", err)
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// Logger 输出日志的接口，可以适配zap、slog等日志库，服务端见Server.Logger，客户端见WithLogger
+// 实现须可以被并发调用
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// stdLogger 默认的Logger，Infof和Errorf写入标准库的log，Debugf被丢弃，因此默认不输出访问日志
+type stdLogger struct{}
+
+func (stdLogger) Debugf(string, ...interface{}) {}
+
+func (stdLogger) Infof(format string, v ...interface{}) { log.Printf(format, v...) }
+
+func (stdLogger) Errorf(format string, v ...interface{}) { log.Printf(format, v...) }
+
+// logger 返回服务端使用的Logger，未设置时为stdLogger
+func (server *Server) logger() Logger {
+	if server.Logger != nil {
+		return server.Logger
+	}
+	return stdLogger{}
+}
+
+// remoteAddrKey 在连接上下文中保存客户端地址的键
+type remoteAddrKey struct{}
+
+// withRemoteAddr 连接能提供对端地址时将其保存在ctx中，用于访问日志
+func withRemoteAddr(ctx context.Context, conn io.ReadWriteCloser) context.Context {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return context.WithValue(ctx, remoteAddrKey{}, c.RemoteAddr().String())
+	}
+	return ctx
+}
+
+// remoteAddr 返回withRemoteAddr保存的客户端地址，没有时为空字符串
+func remoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(remoteAddrKey{}).(string)
+	return addr
+}
+
+// logAccess 每个请求处理完毕后以Debugf输出一行访问日志
+// 包括方法、客户端地址、握手认证的身份、从读取完毕到发出响应的耗时、响应的字节数以及错误
+func (server *Server) logAccess(req *request) {
+	identity, _ := IdentityFromContext(req.ctx)
+	server.logger().Debugf("rpc server: access method=%s remote=%s identity=%s trace=%s duration=%s size=%d error=%q",
+		req.h.ServiceMethod, remoteAddr(req.ctx), identity, req.h.TraceID, time.Since(req.start), req.size, req.h.Error)
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLogger records every line with its level.
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) logf(level, format string, v ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, v...))
+	l.mu.Unlock()
+}
+
+func (l *captureLogger) Debugf(format string, v ...interface{}) { l.logf("DEBUG", format, v...) }
+func (l *captureLogger) Infof(format string, v ...interface{})  { l.logf("INFO", format, v...) }
+func (l *captureLogger) Errorf(format string, v ...interface{}) { l.logf("ERROR", format, v...) }
+
+// find returns the lines containing all of substrs.
+func (l *captureLogger) find(substrs ...string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+next:
+	for _, line := range l.lines {
+		for _, s := range substrs {
+			if !strings.Contains(line, s) {
+				continue next
+			}
+		}
+		found = append(found, line)
+	}
+	return found
+}
+
+func TestServer_Logger(t *testing.T) {
+	t.Parallel()
+	logger := new(captureLogger)
+	var foo Foo
+	server := &Server{Logger: logger}
+	_ = server.Register(&foo)
+	_ = server.Register(new(Shapes))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(WithTraceID(context.Background(), "t-1"), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	err = client.Call(context.Background(), "Shapes.Fail", 0, &reply)
+	_assert(err != nil, "expect Shapes.Fail to fail")
+	// the access line follows the response
+	for i := 0; i < 100 && len(logger.find(" access ")) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	lines := logger.find("DEBUG rpc server: access method=Foo.Sum ", "trace=t-1", `error=""`)
+	_assert(len(lines) == 1, "expect one access line for Foo.Sum, got %q", logger.lines)
+	_assert(strings.Contains(lines[0], " remote=127.0.0.1:"), "expect the remote address, got %q", lines[0])
+	_assert(!strings.Contains(lines[0], " size=0 "), "expect the response size, got %q", lines[0])
+
+	lines = logger.find("DEBUG rpc server: access method=Shapes.Fail ", "shapes: fail")
+	_assert(len(lines) == 1, "expect one access line with the error, got %q", logger.lines)
+	lines = logger.find("ERROR rpc server: Shapes.Fail", "failed")
+	_assert(len(lines) == 1, "expect the failure through the logger, got %q", logger.lines)
+}
+
+func TestLogger_TraceCorrelation(t *testing.T) {
+	t.Parallel()
+	serverLog, clientLog := new(captureLogger), new(captureLogger)
+	var foo Foo
+	server := &Server{Logger: serverLog}
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, err := DialWith("tcp", l.Addr().String(), WithLogger(clientLog))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// no trace ID in ctx, the client generates one
+	var reply int
+	call := <-client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, nil).Done
+	_assert(call.Error == nil && call.TraceID != "", "failed to call Foo.Sum: %v", call.Error)
+	for i := 0; i < 100 && len(serverLog.find(" access ")) < 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	trace := "trace=" + call.TraceID
+	_assert(len(clientLog.find("DEBUG rpc client: send method=Foo.Sum ", trace)) == 1, "expect the send line, got %q", clientLog.lines)
+	_assert(len(serverLog.find("DEBUG rpc server: handle method=Foo.Sum ", trace)) == 1, "expect the handle line, got %q", serverLog.lines)
+	_assert(len(serverLog.find("DEBUG rpc server: access method=Foo.Sum ", trace)) == 1, "expect the access line, got %q", serverLog.lines)
+	_assert(len(clientLog.find("DEBUG rpc client: response method=Foo.Sum ", trace)) == 1, "expect the echoed ID in the response line, got %q", clientLog.lines)
+}
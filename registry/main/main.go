@@ -39,7 +39,9 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	l, _ := net.Listen("tcp", ":0")
 	server := registry.NewServer()
 	_ = server.Register(&foo)
-	regi.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	// 首次心跳同步完成，通知main时服务已经能被发现
+	stop := regi.StartHeartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	defer stop()
 	wg.Done()
 	server.Accept(l)
 }
@@ -104,13 +106,11 @@ func main() {
 	go startRegistry(&wg)
 	wg.Wait()
 
-	time.Sleep(time.Second)
 	wg.Add(2)
 	go startServer(registryAddr, &wg)
 	go startServer(registryAddr, &wg)
 	wg.Wait()
 
-	time.Sleep(time.Second)
 	call(registryAddr)
 	broadcast(registryAddr)
 }
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetMethodLimit 限制serviceMethod同时处理的请求数不超过maxConcurrent、每秒不超过maxPerSecond个，不大于0的一项不设限
+// 超出限制的请求不排队，立即以错误码为CodeResourceExhausted的ErrResourceExhausted响应，不影响连接上其他方法的请求
+// 速率限制的令牌最多积攒一秒，可以在服务运行时调整，对之后的请求生效，两项都不大于0时取消限制，当前的限制见_goRPC.Stats.Get
+func (server *Server) SetMethodLimit(serviceMethod string, maxConcurrent int, maxPerSecond float64) {
+	if maxConcurrent <= 0 && maxPerSecond <= 0 {
+		server.methodLimits.Delete(serviceMethod)
+		return
+	}
+	li, _ := server.methodLimits.LoadOrStore(serviceMethod, &methodLimit{tokens: math.Max(maxPerSecond, 1), last: time.Now()})
+	li.(*methodLimit).set(maxConcurrent, maxPerSecond)
+}
+
+// methodLimit 返回serviceMethod的限制，未设置时返回nil
+func (server *Server) methodLimit(serviceMethod string) *methodLimit {
+	if li, ok := server.methodLimits.Load(serviceMethod); ok {
+		return li.(*methodLimit)
+	}
+	return nil
+}
+
+// methodLimit 方法的并发数上限和令牌桶，调整限制时保留正在处理的请求数
+type methodLimit struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	rate          float64 // 每秒补充的令牌数，最多积攒rate个，至少1个
+	running       int
+	tokens        float64
+	last          time.Time
+	rejected      uint64 // 超出限制被拒绝的请求数，原子操作
+}
+
+func (l *methodLimit) set(maxConcurrent int, maxPerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxConcurrent, l.rate = maxConcurrent, maxPerSecond
+	if l.rate > 0 {
+		l.tokens = math.Min(l.tokens, math.Max(l.rate, 1))
+	}
+}
+
+// acquire 为一个请求占用并发数并取出令牌，超出限制时返回false，nil表示不设限
+// 返回true时，请求处理结束后须调用release
+func (l *methodLimit) acquire(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 && now.After(l.last) {
+		l.tokens = math.Min(math.Max(l.rate, 1), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.maxConcurrent > 0 && l.running >= l.maxConcurrent || l.rate > 0 && l.tokens < 1 {
+		atomic.AddUint64(&l.rejected, 1)
+		return false
+	}
+	if l.rate > 0 {
+		l.tokens--
+	}
+	l.running++
+	return true
+}
+
+// release 释放请求占用的并发数
+func (l *methodLimit) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.running--
+	l.mu.Unlock()
+}
+
+// fill 将限制及拒绝的请求数写入统计
+func (l *methodLimit) fill(st *MethodStats) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	st.MaxConcurrent, st.MaxPerSecond = l.maxConcurrent, l.rate
+	l.mu.Unlock()
+	st.Limited = atomic.LoadUint64(&l.rejected)
+}
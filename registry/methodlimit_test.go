@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_SetMethodLimit(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	var reply int
+
+	expectLimited := func(method string, args interface{}) {
+		t.Helper()
+		start := time.Now()
+		err := client.Call(ctx, method, args, &reply)
+		_assert(ErrorCodeOf(err) == CodeResourceExhausted && CodedErrorCode(err) == CodeMethodLimited && IsRetryable(err),
+			"expect %s to be limited, got %v", method, err)
+		_assert(time.Since(start) < 100*time.Millisecond, "expect %s to be rejected without queuing", method)
+	}
+
+	// the saturated method is rejected, the others on the connection are not
+	server.SetMethodLimit("Slow.Sleep", 2, 0)
+	c1 := client.Go("Slow.Sleep", 300, new(int), nil)
+	c2 := client.Go("Slow.Sleep", 300, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	expectLimited("Slow.Sleep", 300)
+	for i := 0; i < 5; i++ {
+		err := client.Call(ctx, "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "expect Foo.Sum to be unaffected, got %v", err)
+	}
+
+	// the limits are inspectable and adjustable at runtime
+	var stats map[string]MethodStats
+	_ = client.Call(ctx, "_goRPC.Stats.Get", 0, &stats)
+	sleep := stats["Slow.Sleep"]
+	_assert(sleep.MaxConcurrent == 2 && sleep.Limited == 1 && sleep.InFlight == 2, "unexpected Slow.Sleep stats %+v", sleep)
+	_assert(stats["Foo.Sum"].MaxConcurrent == 0 && stats["Foo.Sum"].Limited == 0, "expect Foo.Sum to have no limit")
+	server.SetMethodLimit("Slow.Sleep", 3, 0)
+	err := client.Call(ctx, "Slow.Sleep", 10, &reply)
+	_assert(err == nil, "expect the raised limit to apply, got %v", err)
+	<-c1.Done
+	<-c2.Done
+	_assert(c1.Error == nil && c2.Error == nil, "expect the admitted calls to succeed")
+
+	// the rate cap refills over time
+	server.SetMethodLimit("Foo.Sum", 0, 2)
+	for i := 0; i < 2; i++ {
+		err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+		_assert(err == nil, "expect the burst to be admitted, got %v", err)
+	}
+	expectLimited("Foo.Sum", Args{Num1: 1, Num2: 1})
+	time.Sleep(600 * time.Millisecond)
+	err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+	_assert(err == nil, "expect a token after the refill, got %v", err)
+	_ = client.Call(ctx, "_goRPC.Stats.Get", 0, &stats)
+	_assert(stats["Foo.Sum"].MaxPerSecond == 2 && stats["Foo.Sum"].Calls == 8, "unexpected Foo.Sum stats %+v", stats["Foo.Sum"])
+
+	server.SetMethodLimit("Foo.Sum", 0, 0)
+	for i := 0; i < 5; i++ {
+		err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+		_assert(err == nil, "expect the limit to be removed, got %v", err)
+	}
+}
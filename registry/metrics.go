@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// methodLatency 单个方法按结果分开的请求耗时直方图，桶的上界与latencyBounds相同
+// 耗时与SlowCallThreshold的计时一致，从读取完毕到发出响应，全部使用原子操作
+type methodLatency struct {
+	buckets [2][6]uint64 // [0]为成功的请求，[1]为失败的请求，每组len(latencyBounds)+1个桶
+	sum     [2]int64     // 耗时之和，单位纳秒
+}
+
+func (l *methodLatency) record(elapsed time.Duration, failed bool) {
+	status := 0
+	if failed {
+		status = 1
+	}
+	i := 0
+	for i < len(latencyBounds) && elapsed > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&l.buckets[status][i], 1)
+	atomic.AddInt64(&l.sum[status], int64(elapsed))
+}
+
+// metricsMethod 导出指标的一个方法，函数注册的服务的服务名和方法名都是函数名
+type metricsMethod struct {
+	service, method string
+	m               *methodType
+}
+
+// labelEscaper 按Prometheus文本格式转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mm *metricsMethod) labels() string {
+	return `service="` + labelEscaper.Replace(mm.service) + `",method="` + labelEscaper.Replace(mm.method) + `"`
+}
+
+// MetricsHandler 返回以Prometheus文本格式导出各方法指标的http.Handler，不包括内置服务
+// 指标包括调用数gorpc_requests_total、错误数gorpc_errors_total、进行中的调用数gorpc_in_flight_requests，
+// 以及按status="ok"或"error"区分的耗时直方图gorpc_request_duration_seconds，标签service和method区分方法
+// 例如 http.Handle("/metrics", server.MetricsHandler())
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		server.writeMetrics(bw)
+		if err := bw.Flush(); err != nil {
+			server.logger().Errorf("rpc server: metrics error: %v", err)
+		}
+	})
+}
+
+// writeMetrics 按服务名和方法名排序写出所有方法的指标
+func (server *Server) writeMetrics(w *bufio.Writer) {
+	var methods []metricsMethod
+	server.serviceMap.Range(func(namei, svci interface{}) bool {
+		for name, m := range svci.(*service).method {
+			methods = append(methods, metricsMethod{namei.(string), name, m})
+		}
+		return true
+	})
+	server.funcMap.Range(func(namei, svci interface{}) bool {
+		for _, m := range svci.(*service).method {
+			methods = append(methods, metricsMethod{namei.(string), namei.(string), m})
+		}
+		return true
+	})
+	sort.Slice(methods, func(i, j int) bool {
+		if methods[i].service != methods[j].service {
+			return methods[i].service < methods[j].service
+		}
+		return methods[i].method < methods[j].method
+	})
+
+	family := func(name, typ, help string, value func(mm *metricsMethod) string) {
+		w.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+		for i := range methods {
+			w.WriteString(name + "{" + methods[i].labels() + "} " + value(&methods[i]) + "\n")
+		}
+	}
+	family("gorpc_requests_total", "counter", "Calls received by the method.", func(mm *metricsMethod) string {
+		return strconv.FormatUint(mm.m.NumCalls(), 10)
+	})
+	family("gorpc_errors_total", "counter", "Calls to the method that returned an error or panicked.", func(mm *metricsMethod) string {
+		return strconv.FormatUint(atomic.LoadUint64(&mm.m.stats.errors), 10)
+	})
+	family("gorpc_in_flight_requests", "gauge", "Calls to the method currently running.", func(mm *metricsMethod) string {
+		return strconv.FormatInt(atomic.LoadInt64(&mm.m.stats.inFlight), 10)
+	})
+
+	const histogram = "gorpc_request_duration_seconds"
+	w.WriteString("# HELP " + histogram + " Time from reading the request to sending the response.\n# TYPE " + histogram + " histogram\n")
+	for i := range methods {
+		l := &methods[i].m.latency
+		for status, statusName := range []string{"ok", "error"} {
+			labels := methods[i].labels() + `,status="` + statusName + `"`
+			var count uint64
+			for b := range l.buckets[status] {
+				count += atomic.LoadUint64(&l.buckets[status][b])
+				le := "+Inf"
+				if b < len(latencyBounds) {
+					le = strconv.FormatFloat(latencyBounds[b].Seconds(), 'g', -1, 64)
+				}
+				w.WriteString(histogram + "_bucket{" + labels + `,le="` + le + `"} ` + strconv.FormatUint(count, 10) + "\n")
+			}
+			sum := time.Duration(atomic.LoadInt64(&l.sum[status])).Seconds()
+			w.WriteString(histogram + "_sum{" + labels + "} " + strconv.FormatFloat(sum, 'g', -1, 64) + "\n")
+			w.WriteString(histogram + "_count{" + labels + "} " + strconv.FormatUint(count, 10) + "\n")
+		}
+	}
+}
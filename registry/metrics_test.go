@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_MetricsHandler(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(new(Shapes))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	for i := 0; i < 2; i++ {
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil, "failed to call Foo.Sum: %v", err)
+	}
+	_ = client.Call(context.Background(), "Shapes.Fail", 0, &reply)
+
+	want := []string{
+		"# TYPE gorpc_requests_total counter",
+		`gorpc_requests_total{service="Foo",method="Sum"} 2`,
+		`gorpc_errors_total{service="Foo",method="Sum"} 0`,
+		`gorpc_in_flight_requests{service="Foo",method="Sum"} 0`,
+		"# TYPE gorpc_request_duration_seconds histogram",
+		`gorpc_request_duration_seconds_bucket{service="Foo",method="Sum",status="ok",le="+Inf"} 2`,
+		`gorpc_request_duration_seconds_count{service="Foo",method="Sum",status="ok"} 2`,
+		`gorpc_request_duration_seconds_count{service="Foo",method="Sum",status="error"} 0`,
+		`gorpc_errors_total{service="Shapes",method="Fail"} 1`,
+		`gorpc_request_duration_seconds_count{service="Shapes",method="Fail",status="error"} 1`,
+	}
+	var body string
+	missing := func() string {
+		rec := httptest.NewRecorder()
+		server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		_assert(strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"), "unexpected content type %q", rec.Header().Get("Content-Type"))
+		body = rec.Body.String()
+		lines := make(map[string]bool)
+		for _, line := range strings.Split(body, "\n") {
+			lines[line] = true
+		}
+		for _, line := range want {
+			if !lines[line] {
+				return line
+			}
+		}
+		return ""
+	}
+	// the latency is recorded after the response is sent
+	line := missing()
+	for deadline := time.Now().Add(time.Second); line != "" && time.Now().Before(deadline); line = missing() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(line == "", "expect %q in the metrics:\n%s", line, body)
+	_assert(!strings.Contains(body, "_goRPC"), "expect no builtin services in the metrics")
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"errors"
+	"goRPC/client/codec"
+	"sync/atomic"
+)
+
+// ErrNotifyUnsupported is returned by Notify when the connection speaks a
+// protocol version older than 4, whose servers would answer the request.
+var ErrNotifyUnsupported = errors.New("rpc client: notify needs protocol version 4")
+
+// Notify sends a one-way request to serviceMethod: the server runs the
+// method, discards its reply and error and sends nothing back. Notify
+// returns once the request is written, so its errors are local only,
+// e.g. args can't be encoded or the client is shut down. Notifications
+// hold no pending slot and are counted apart from calls on both sides,
+// see ClientStats.Notified. Stream methods can't be notified.
+func (client *Client) Notify(serviceMethod string, args interface{}) error {
+	if client.opt != nil && client.opt.Version < 4 {
+		return ErrNotifyUnsupported
+	}
+	if err := client.checkMethod(serviceMethod); err != nil {
+		return err
+	}
+	if client.lazy != nil {
+		if err := client.connect(); err != nil {
+			return err
+		}
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.mu.Lock()
+	closed := client.closing || client.shutdown
+	client.mu.Unlock()
+	if closed {
+		return ErrShutdown
+	}
+	h := codec.Header{ServiceMethod: serviceMethod, Callback: client.callback, Oneway: true, TraceID: newTraceID()}
+	err := client.cc.Write(&h, args)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&client.notified, 1)
+	return nil
+}
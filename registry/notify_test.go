@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"errors"
+	"goRPC/client/codec"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Events struct {
+	got chan string
+}
+
+func (e *Events) Submit(event string, _ *struct{}) error {
+	e.got <- event
+	if event == "bad" {
+		return errors.New("bad event")
+	}
+	return nil
+}
+
+// recordingCodec counts the frames the server writes.
+type recordingCodec struct {
+	codec.Codec
+	mu     sync.Mutex
+	frames []codec.Header
+}
+
+func (r *recordingCodec) Write(h *codec.Header, body interface{}) error {
+	r.mu.Lock()
+	r.frames = append(r.frames, *h)
+	r.mu.Unlock()
+	return r.Codec.Write(h, body)
+}
+
+func (r *recordingCodec) written() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.frames)
+}
+
+func TestClient_Notify(t *testing.T) {
+	t.Parallel()
+	events := &Events{got: make(chan string, 1)}
+	server := NewServer()
+	_ = server.Register(events)
+	c, s := net.Pipe()
+	rec := &recordingCodec{Codec: codec.NewGobCodec(s)}
+	go server.ServeCodec(rec)
+	client := NewClientWithCodec(codec.NewGobCodec(c), nil)
+	defer func() { _ = client.Close() }()
+
+	for _, event := range []string{"ok", "bad"} {
+		err := client.Notify("Events.Submit", event)
+		_assert(err == nil, "failed to notify: %v", err)
+		select {
+		case got := <-events.got:
+			_assert(got == event, "expect %s, got %s", event, got)
+		case <-time.After(time.Second):
+			t.Fatal("expect the server to run the method")
+		}
+	}
+	// the handler has run, give a response time to be written
+	time.Sleep(time.Millisecond * 50)
+	_assert(rec.written() == 0, "expect no response frame, got %d", rec.written())
+	_assert(pendingCount(client) == 0 && client.Stats().Notified == 2, "expect 2 notifications and no pending call, got %+v", client.Stats())
+	svci, _ := server.serviceMap.Load("Events")
+	mtype := svci.(*service).method["Submit"]
+	_assert(mtype.NumNotifies() == 2 && mtype.NumCalls() == 0, "expect notifications to be counted apart from calls")
+
+	// a regular call is still answered
+	call := client.Go("Events.Submit", "call", nil, nil)
+	<-events.got
+	<-call.Done
+	_assert(call.Error == nil && rec.written() == 1, "expect one response, got %d: %v", rec.written(), call.Error)
+
+	_ = client.Close()
+	_assert(client.Notify("Events.Submit", "late") == ErrShutdown, "expect ErrShutdown after Close")
+	old := NewClientWithCodec(codec.NewGobCodec(c), &Option{Version: 3})
+	_assert(old.Notify("Events.Submit", "old") == ErrNotifyUnsupported, "expect notify to need version 4")
+	_ = old.Close()
+}
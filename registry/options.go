@@ -0,0 +1,262 @@
+package registry
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"strings"
+	"time"
+)
+
+// DialOption configures the client created by DialWith.
+// It returns an error if its argument is invalid.
+type DialOption func(opt *Option) error
+
+// WithCodec sets the codec used to encode the requests.
+func WithCodec(t codec.Type) DialOption {
+	return func(opt *Option) error {
+		if codec.Lookup(t) == nil {
+			return fmt.Errorf("invalid codec type %q", t)
+		}
+		opt.CodecType = t
+		return nil
+	}
+}
+
+// WithConnectTimeout sets the timeout of the connection and the option
+// exchange, 0 means no limit.
+func WithConnectTimeout(d time.Duration) DialOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("negative connect timeout %s", d)
+		}
+		opt.ConnectTimeout = d
+		return nil
+	}
+}
+
+// WithHandshakeTimeout bounds sending the options and reading the
+// server's handshake reply, see Option.HandshakeTimeout. 0 means no limit.
+func WithHandshakeTimeout(d time.Duration) DialOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("negative handshake timeout %s", d)
+		}
+		opt.HandshakeTimeout = d
+		return nil
+	}
+}
+
+// WithHandleTimeout asks the server to give up on requests
+// not handled within d, 0 means no limit.
+func WithHandleTimeout(d time.Duration) DialOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("negative handle timeout %s", d)
+		}
+		opt.HandleTimeout = d
+		return nil
+	}
+}
+
+// WithSingleFlight shares the reply of identical in-flight calls,
+// see Option.SingleFlight.
+func WithSingleFlight() DialOption {
+	return func(opt *Option) error {
+		opt.SingleFlight = true
+		return nil
+	}
+}
+
+// WithTLS secures the connection with TLS. If config has no ServerName,
+// the host of the dialed address is verified.
+func WithTLS(config *tls.Config) DialOption {
+	return func(opt *Option) error {
+		if config == nil {
+			return errors.New("nil tls config")
+		}
+		opt.tlsConfig = config
+		return nil
+	}
+}
+
+// WithAuthToken sends token in the handshake, see Option.AuthToken.
+// Unless allowInsecure is set, the token is only sent over TLS.
+func WithAuthToken(token string, allowInsecure bool) DialOption {
+	return func(opt *Option) error {
+		opt.AuthToken, opt.AllowInsecureAuth = token, allowInsecure
+		return nil
+	}
+}
+
+// withOption copies every field of an already parsed Option.
+func withOption(o *Option) DialOption {
+	return func(opt *Option) error {
+		*opt = *o
+		return nil
+	}
+}
+
+// WithSeqGenerator makes the client take the seq of every call from gen
+// instead of counting from 1, e.g. to tie seqs to an external trace
+// system or make them unique across clients. gen must be safe for
+// concurrent use. A seq of 0 or one still pending is drawn again.
+func WithSeqGenerator(gen func() uint64) DialOption {
+	return func(opt *Option) error {
+		if gen == nil {
+			return errors.New("nil seq generator")
+		}
+		opt.seqGen = gen
+		return nil
+	}
+}
+
+// WithMagicNumber sets the magic number sent in the handshake, for
+// servers built with NewServerWithMagic.
+func WithMagicNumber(magic int) DialOption {
+	return func(opt *Option) error {
+		if magic == 0 {
+			return errors.New("zero magic number")
+		}
+		opt.MagicNumber = magic
+		return nil
+	}
+}
+
+// WithLogger sends the client's logs to l instead of the standard log
+// package. Every call is logged at debug level when it is sent and when
+// its response arrives, with the trace ID the server echoes back, so the
+// lines can be matched with the server's access log.
+func WithLogger(l Logger) DialOption {
+	return func(opt *Option) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		opt.logger = l
+		return nil
+	}
+}
+
+// WithMaxPendingCalls bounds the calls waiting for a reply,
+// see Option.MaxPendingCalls.
+func WithMaxPendingCalls(n int, failFast bool) DialOption {
+	return func(opt *Option) error {
+		if n < 0 {
+			return fmt.Errorf("negative max pending calls %d", n)
+		}
+		opt.MaxPendingCalls, opt.FailOnMaxPending = n, failFast
+		return nil
+	}
+}
+
+// WithLoadShedding fails new calls with ErrOverloaded once high calls
+// are pending, until fewer than low are, see Option.ShedHighWater.
+// A high of 0 disables shedding.
+func WithLoadShedding(high, low int) DialOption {
+	return func(opt *Option) error {
+		if high < 0 || low < 0 {
+			return fmt.Errorf("negative shedding water marks %d/%d", high, low)
+		}
+		if high > 0 && low >= high {
+			return fmt.Errorf("shedding low-water mark %d not below high-water mark %d", low, high)
+		}
+		opt.ShedHighWater, opt.ShedLowWater = high, low
+		return nil
+	}
+}
+
+// WithHandlerPoolSize asks the server to handle the requests of the
+// connection on at most n goroutines, see Option.HandlerPoolSize.
+func WithHandlerPoolSize(n int) DialOption {
+	return func(opt *Option) error {
+		if n < 0 {
+			return fmt.Errorf("negative handler pool size %d", n)
+		}
+		opt.HandlerPoolSize = n
+		return nil
+	}
+}
+
+// WithOrdered asks the server to handle the requests of the connection
+// one at a time in arrival order, see Option.Ordered.
+func WithOrdered() DialOption {
+	return func(opt *Option) error {
+		opt.Ordered = true
+		return nil
+	}
+}
+
+// WithSkipHandshake sends no options and uses the gob codec right away,
+// see Option.SkipHandshake. Only for trusted transports whose server
+// end was agreed out of band to be served with ServeConnNoHandshake.
+func WithSkipHandshake() DialOption {
+	return func(opt *Option) error {
+		opt.SkipHandshake = true
+		return nil
+	}
+}
+
+// WithPlainMethodNames lets calls use names that are not of the form
+// "Service.Method", e.g. the functions published by Server.RegisterFunc.
+// By default such calls fail with ErrIllFormedMethod before being sent.
+func WithPlainMethodNames() DialOption {
+	return func(opt *Option) error {
+		opt.plainNames = true
+		return nil
+	}
+}
+
+// buildOptions applies opts to a copy of DefaultOption and
+// reports all the validation errors at once.
+func buildOptions(opts ...DialOption) (*Option, error) {
+	opt := *DefaultOption
+	if err := applyOptions(&opt, opts...); err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// validate checks the fields of an Option given to Dial by
+// running them through the matching DialOption.
+func validate(opt *Option) error {
+	return applyOptions(opt,
+		WithCodec(opt.CodecType),
+		WithConnectTimeout(opt.ConnectTimeout),
+		WithHandshakeTimeout(opt.HandshakeTimeout),
+		WithHandleTimeout(opt.HandleTimeout),
+		WithMaxPendingCalls(opt.MaxPendingCalls, opt.FailOnMaxPending),
+		WithLoadShedding(opt.ShedHighWater, opt.ShedLowWater),
+		WithHandlerPoolSize(opt.HandlerPoolSize),
+	)
+}
+
+// applyOptions applies opts to opt and joins their errors.
+func applyOptions(opt *Option, opts ...DialOption) error {
+	var errs []string
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("rpc client: invalid options: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// DialWith connects to an RPC server at the specified network address
+// configured by opts on top of DefaultOption.
+func DialWith(network, address string, opts ...DialOption) (*Client, error) {
+	opt, err := buildOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return dialTimeout(NewClient, network, address, opt)
+}
+
+// DialTLS connects to an RPC server served with Server.AcceptTLS,
+// see WithTLS. opts apply on top of DefaultOption as with DialWith.
+func DialTLS(network, address string, config *tls.Config, opts ...DialOption) (*Client, error) {
+	return DialWith(network, address, append([]DialOption{WithTLS(config)}, opts...)...)
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialWith(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := DialWith("tcp", l.Addr().String(), WithConnectTimeout(time.Second), WithHandleTimeout(time.Second))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	_assert(client.opt.HandleTimeout == time.Second && client.opt.MagicNumber == MagicNumber, "options not applied")
+	_assert(DefaultOption.HandleTimeout == 0, "DefaultOption must not be modified")
+}
+
+func TestDialWith_InvalidOptions(t *testing.T) {
+	_, err := DialWith("tcp", "127.0.0.1:1", WithCodec("application/unknown"), WithConnectTimeout(-time.Second))
+	_assert(err != nil, "expect a validation error")
+	_assert(strings.Contains(err.Error(), "application/unknown") && strings.Contains(err.Error(), "negative connect timeout"),
+		"expect both validation errors, got %v", err)
+}
+
+func TestDial_OptionNotMutated(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	opt := &Option{ConnectTimeout: time.Second}
+	client, err := Dial("tcp", l.Addr().String(), opt)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(reflect.DeepEqual(*opt, Option{ConnectTimeout: time.Second}), "caller's option was modified: %+v", *opt)
+}
+
+func TestDial_SharedOption(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	opt := &Option{HandleTimeout: time.Second}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := Dial("tcp", l.Addr().String(), opt)
+			_assert(err == nil, "failed to dial: %v", err)
+			_ = client.Close()
+		}()
+	}
+	wg.Wait()
+	_assert(reflect.DeepEqual(*opt, Option{HandleTimeout: time.Second}), "caller's option was modified: %+v", *opt)
+
+	_, err := Dial("tcp", l.Addr().String(), &Option{CodecType: "application/unknown", HandleTimeout: -time.Second})
+	_assert(err != nil && strings.Contains(err.Error(), "application/unknown") && strings.Contains(err.Error(), "negative handle timeout"),
+		"expect both validation errors, got %v", err)
+}
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"context"
+	"goRPC/client/codec"
+)
+
+// Peer 代表服务端一侧的单个连接，服务端通过它回调客户端注册的方法
+// 回调请求与响应的Header均带有Callback标志，序号空间与客户端发起的调用互不干扰
+type Peer struct {
+	client *Client
+}
+
+// newPeer 基于连接的Codec创建Peer，不启动独立的接收协程，响应由serveCodec的读取循环转交
+func newPeer(cc codec.Codec) *Peer {
+	return &Peer{client: &Client{
+		seq:      1,
+		cc:       cc,
+		pending:  make(map[uint64]*Call),
+		stopped:  make(chan struct{}),
+		callback: true,
+		state:    StateConnected,
+	}}
+}
+
+// peerKey 在请求上下文中保存Peer的键
+type peerKey struct{}
+
+// PeerFromContext 返回处理请求的连接对应的Peer，ctx须来自接收context.Context的服务方法
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// OnPeer 设置连接建立后的回调，服务端可在其中保存Peer以便之后主动调用客户端
+// f 在连接的处理协程中同步执行，不应阻塞
+func (server *Server) OnPeer(f func(p *Peer)) {
+	server.onPeer = f
+}
+
+// Go 异步回调客户端注册的方法
+func (p *Peer) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return p.client.Go(serviceMethod, args, reply, done)
+}
+
+// Call 回调客户端注册的方法并等待结果
+func (p *Peer) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return p.client.Call(ctx, serviceMethod, args, reply)
+}
+
+// IsAvailable 连接仍然可用时返回true
+func (p *Peer) IsAvailable() bool {
+	return p.client.IsAvailable()
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type Notifier struct {
+	peers chan *Peer
+}
+
+// Watch 在处理期间通过Peer向客户端推送args条通知
+func (n *Notifier) Watch(args int, reply *int) error {
+	peer := <-n.peers
+	for i := 1; i <= args; i++ {
+		var ack int
+		if err := peer.Call(context.Background(), "Listener.Notify", i, &ack); err != nil {
+			return err
+		}
+		*reply += ack
+	}
+	return nil
+}
+
+type Listener struct {
+	mu  sync.Mutex
+	got []int
+}
+
+func (l *Listener) Notify(args int, reply *int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.got = append(l.got, args)
+	*reply = args
+	return nil
+}
+
+func TestPeer_Callback(t *testing.T) {
+	t.Parallel()
+	n := &Notifier{peers: make(chan *Peer, 1)}
+	server := NewServer()
+	_ = server.Register(n)
+	server.OnPeer(func(p *Peer) { n.peers <- p })
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	listener := &Listener{}
+	_assert(client.RegisterCallback(listener) == nil, "failed to register callback")
+
+	var reply int
+	err = client.Call(context.Background(), "Notifier.Watch", 3, &reply)
+	_assert(err == nil, "failed to call Notifier.Watch: %v", err)
+	_assert(reply == 1+2+3, "expect reply 6, got %d", reply)
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	_assert(len(listener.got) == 3 && listener.got[0] == 1 && listener.got[2] == 3,
+		"expect 3 ordered notifications, got %v", listener.got)
+}
+
+// PanickyListener 的回调方法总是panic
+type PanickyListener int
+
+func (l PanickyListener) Notify(args int, reply *int) error {
+	panic("bad notification")
+}
+
+func TestPeer_CallbackPanic(t *testing.T) {
+	t.Parallel()
+	n := &Notifier{peers: make(chan *Peer, 1)}
+	server := NewServer()
+	_ = server.Register(n)
+	_ = server.Register(new(Foo))
+	server.OnPeer(func(p *Peer) { n.peers <- p })
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	// published as Listener, the name Notifier calls back
+	client.callbacks.Store("Listener", newNamedService("Listener", new(PanickyListener)))
+	var reply int
+	err := client.Call(context.Background(), "Notifier.Watch", 1, &reply)
+	_assert(ErrorCodeOf(err) == CodeInternal && strings.Contains(err.Error(), "panic in callback"), "expect the callback panic, got %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3 && client.IsAvailable(), "expect the client to stay usable, got %v", err)
+}
+
+func TestPeer_UnknownCallback(t *testing.T) {
+	t.Parallel()
+	n := &Notifier{peers: make(chan *Peer, 1)}
+	server := NewServer()
+	_ = server.Register(n)
+	server.OnPeer(func(p *Peer) { n.peers <- p })
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Notifier.Watch", 1, &reply)
+	_assert(err != nil && client.IsAvailable(), "expect a callback error, got %v", err)
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// PendingCallInfo describes a call waiting for its reply.
+type PendingCallInfo struct {
+	ServiceMethod string
+	Seq           uint64
+	Enqueued      time.Time     // when the request was registered
+	Age           time.Duration // time since Enqueued
+}
+
+// PendingCalls returns a snapshot of the calls waiting for a reply,
+// oldest first. Calls waiting for a shared request are not listed,
+// only the request itself is.
+func (client *Client) PendingCalls() []PendingCallInfo {
+	now := time.Now()
+	client.mu.Lock()
+	infos := make([]PendingCallInfo, 0, len(client.pending))
+	for seq, call := range client.pending {
+		infos = append(infos, PendingCallInfo{
+			ServiceMethod: call.ServiceMethod,
+			Seq:           seq,
+			Enqueued:      call.Info.Sent,
+			Age:           now.Sub(call.Info.Sent),
+		})
+	}
+	client.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Seq < infos[j].Seq })
+	return infos
+}
+
+// LogPendingCalls logs the calls waiting for a reply for longer than
+// olderThan and returns how many there are.
+func (client *Client) LogPendingCalls(olderThan time.Duration) int {
+	n := 0
+	for _, info := range client.PendingCalls() {
+		if info.Age < olderThan {
+			continue
+		}
+		n++
+		log.Printf("rpc client: call %s (seq %d) pending for %s", info.ServiceMethod, info.Seq, info.Age)
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient_PendingCalls(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var r1, r2 int
+	c1 := client.Go("Slow.Sleep", 300, &r1, nil)
+	time.Sleep(time.Millisecond * 100)
+	c2 := client.Go("Slow.Sleep", 600, &r2, nil)
+	infos := client.PendingCalls()
+	_assert(len(infos) == 2, "expect 2 pending calls, got %v", infos)
+	_assert(infos[0].Seq == c1.Seq && infos[1].Seq == c2.Seq, "expect the calls in order, got %v", infos)
+	_assert(infos[0].ServiceMethod == "Slow.Sleep" && infos[0].Age >= time.Millisecond*100, "unexpected info %+v", infos[0])
+	_assert(client.LogPendingCalls(time.Millisecond*50) == 1, "expect 1 call older than 50ms")
+
+	<-c1.Done
+	infos = client.PendingCalls()
+	_assert(len(infos) == 1 && infos[0].Seq == c2.Seq, "expect the completed call to disappear, got %v", infos)
+	<-c2.Done
+	_assert(len(client.PendingCalls()) == 0, "expect no pending calls")
+}
+
+func TestClient_LoadShedding(t *testing.T) {
+	t.Parallel()
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	_, err := DialWith("tcp", l.Addr().String(), WithLoadShedding(2, 2))
+	_assert(err != nil, "expect the low-water mark to be below the high-water mark")
+	client, err := DialWith("tcp", l.Addr().String(), WithLoadShedding(3, 2))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var r1, r2, r3, reply int
+	c1 := client.Go("Slow.Sleep", 100, &r1, nil)
+	c2 := client.Go("Slow.Sleep", 300, &r2, nil)
+	c3 := client.Go("Slow.Sleep", 600, &r3, nil)
+	shed := client.Go("Slow.Sleep", 0, &reply, nil)
+	select {
+	case <-shed.Done:
+		_assert(shed.Error == ErrOverloaded, "expect the call above the high-water mark to be shed, got %v", shed.Error)
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("expect the shed call to fail right away")
+	}
+
+	// 2 pending, not yet below the low-water mark
+	<-c1.Done
+	shed = client.Go("Slow.Sleep", 0, &reply, nil)
+	<-shed.Done
+	_assert(shed.Error == ErrOverloaded, "expect shedding to go on until below the low-water mark, got %v", shed.Error)
+
+	<-c2.Done
+	ok := client.Go("Slow.Sleep", 0, &reply, nil)
+	<-ok.Done
+	_assert(ok.Error == nil, "expect calls to be accepted again, got %v", ok.Error)
+	<-c3.Done
+	_assert(c1.Error == nil && c2.Error == nil && c3.Error == nil, "expect the pending calls to succeed")
+}
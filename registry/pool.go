@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"goRPC/client/codec"
+	"reflect"
+	"sync"
+)
+
+// headerPool和requestPool 复用请求头和请求，请求处理完毕、响应发出后放回
+// 超时的请求仍可能被处理协程访问，回调的响应和流上的消息交给其他对象处理，这些请求头和请求不放回
+var (
+	headerPool  = sync.Pool{New: func() interface{} { return new(codec.Header) }}
+	requestPool = sync.Pool{New: func() interface{} { return new(request) }}
+)
+
+// newHeader 从池中取出清零的请求头
+func newHeader() *codec.Header {
+	h := headerPool.Get().(*codec.Header)
+	*h = codec.Header{}
+	return h
+}
+
+// newRequest 从池中取出清零的请求
+func newRequest(h *codec.Header) *request {
+	req := requestPool.Get().(*request)
+	*req = request{h: h}
+	return req
+}
+
+// freeRequest 将处理完毕的请求放回池中，设置了ReuseValues时同时放回参数和回复
+// 调用后不能再访问req及其请求头
+func (server *Server) freeRequest(req *request) {
+	if server.ReuseValues && req.mtype != nil {
+		req.mtype.argPool.put(req.argv)
+		req.mtype.replyPool.put(req.replyv)
+	}
+	h := req.h
+	*req = request{}
+	requestPool.Put(req)
+	headerPool.Put(h)
+}
+
+// valuePool 复用指向结构体的参数或回复，nil表示不复用
+// 放回前清零，取出的值与reflect.New创建的一样是零值
+type valuePool struct {
+	typ  reflect.Type // 指针类型
+	pool sync.Pool
+}
+
+// newValuePool 返回t的池，t不是指向结构体的指针时返回nil
+func newValuePool(t reflect.Type) *valuePool {
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || t == typeOfStream {
+		return nil
+	}
+	return &valuePool{typ: t}
+}
+
+// get 取出一个零值，池为空或不复用时ok为false
+func (p *valuePool) get() (v reflect.Value, ok bool) {
+	if p == nil {
+		return reflect.Value{}, false
+	}
+	x := p.pool.Get()
+	if x == nil {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(x), true
+}
+
+// put 清零v后放回池中，v无效或为nil时丢弃
+func (p *valuePool) put(v reflect.Value) {
+	if p == nil || !v.IsValid() || v.Type() != p.typ || v.IsNil() {
+		return
+	}
+	v.Elem().Set(reflect.Zero(p.typ.Elem()))
+	p.pool.Put(v.Interface())
+}
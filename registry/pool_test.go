@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goRPC/client/codec"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type Vec struct {
+	X, Y int
+	Tags []string
+}
+
+// Pooled 参数和回复都是指向结构体的指针，可以被复用
+type Pooled int
+
+func (p Pooled) Shift(args *Vec, reply *Vec) error {
+	if args.X < 0 {
+		return errors.New("negative")
+	}
+	// 只在X为偶数时设置Tags，复用的回复没有清零时奇数X的回复会带上之前的Tags
+	reply.X, reply.Y = args.X+1, args.Y+1
+	if args.X%2 == 0 {
+		reply.Tags = append(reply.Tags, fmt.Sprint(args.X))
+	}
+	return nil
+}
+
+func (p Pooled) Clone(args *Vec) (*Vec, error) {
+	return &Vec{X: args.X, Y: args.Y}, nil
+}
+
+func TestServer_ReuseValues(t *testing.T) {
+	t.Parallel()
+	var p Pooled
+	server := &Server{ReuseValues: true}
+	_ = server.Register(&p)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				x := g*1000 + i
+				var reply Vec
+				err := client.Call(context.Background(), "Pooled.Shift", &Vec{X: x, Y: -x, Tags: []string{"in"}}, &reply)
+				if err != nil {
+					errs <- err
+					return
+				}
+				want := Vec{X: x + 1, Y: -x + 1}
+				if x%2 == 0 {
+					want.Tags = []string{fmt.Sprint(x)}
+				}
+				if !reflect.DeepEqual(reply, want) {
+					errs <- fmt.Errorf("expect %+v, got %+v", want, reply)
+					return
+				}
+				if i%50 == 0 {
+					if err := client.Call(context.Background(), "Pooled.Shift", &Vec{X: -1}, &reply); err == nil {
+						errs <- errors.New("expect the error of Pooled.Shift")
+						return
+					}
+				}
+				var clone Vec
+				if err := client.Call(context.Background(), "Pooled.Clone", &Vec{X: x}, &clone); err != nil || clone.X != x {
+					errs <- fmt.Errorf("failed to call Pooled.Clone: %v %+v", err, clone)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestValuePool(t *testing.T) {
+	t.Parallel()
+	_assert(newValuePool(reflect.TypeOf(new(int))) == nil, "expect a pointer to a non-struct not to be pooled")
+	_assert(newValuePool(reflect.TypeOf(Vec{})) == nil, "expect a struct value not to be pooled")
+	_assert(newValuePool(typeOfStream) == nil, "expect a stream not to be pooled")
+	var p Pooled
+	s := newService(&p)
+	_assert(s.method["Clone"].replyPool == nil, "expect a returned reply not to be pooled")
+
+	pool := newValuePool(reflect.TypeOf(new(Vec)))
+	pool.put(reflect.ValueOf(&Vec{X: 1, Tags: []string{"a"}}))
+	pool.put(reflect.ValueOf((*Vec)(nil)))
+	pool.put(reflect.ValueOf(new(int)))
+	for {
+		v, ok := pool.get()
+		if !ok {
+			break
+		}
+		_assert(reflect.DeepEqual(*v.Interface().(*Vec), Vec{}), "expect a zeroed value, got %+v", v.Interface())
+	}
+}
+
+// replayCodec 重复读出同一个请求n次，丢弃响应，用于测量服务端处理请求的分配
+type replayCodec struct {
+	method string
+	args   interface{}
+	n      int
+	seq    uint64
+	mu     sync.Mutex
+	failed error
+}
+
+func (c *replayCodec) ReadHeader(h *codec.Header) error {
+	if c.seq == uint64(c.n) {
+		return io.EOF
+	}
+	c.seq++
+	h.ServiceMethod, h.Seq = c.method, c.seq
+	return nil
+}
+
+func (c *replayCodec) ReadBody(v interface{}) error {
+	if v != nil {
+		reflect.ValueOf(v).Elem().Set(reflect.ValueOf(c.args))
+	}
+	return nil
+}
+
+func (c *replayCodec) Write(h *codec.Header, _ interface{}) error {
+	if h.Error != "" {
+		c.mu.Lock()
+		c.failed = errors.New(h.Error)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *replayCodec) Flush() error { return nil }
+func (c *replayCodec) Close() error { return nil }
+
+func benchmarkServe(b *testing.B, server *Server, method string, args interface{}) {
+	cc := &replayCodec{method: method, args: args, n: b.N}
+	b.ReportAllocs()
+	b.ResetTimer()
+	server.ServeCodec(cc)
+	b.StopTimer()
+	if cc.failed != nil {
+		b.Fatal(cc.failed)
+	}
+}
+
+// BenchmarkServer_Allocs 服务端处理一个请求的分配，不含编解码和网络
+func BenchmarkServer_Allocs(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		var foo Foo
+		var p Pooled
+		server := &Server{ReuseValues: reuse, DisableReflection: true}
+		_ = server.Register(&foo)
+		_ = server.Register(&p)
+		b.Run(fmt.Sprintf("Foo.Sum/reuse=%t", reuse), func(b *testing.B) {
+			benchmarkServe(b, server, "Foo.Sum", Args{Num1: 1, Num2: 2})
+		})
+		b.Run(fmt.Sprintf("Pooled.Shift/reuse=%t", reuse), func(b *testing.B) {
+			benchmarkServe(b, server, "Pooled.Shift", Vec{X: 1, Y: 2})
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 请求的优先级，见WithPriority和Server.MaxWorkers
+const (
+	PriorityNormal uint8 = iota
+	PriorityHigh
+)
+
+// defaultPriorityAging Server.PriorityAging的默认值
+const defaultPriorityAging = time.Second
+
+// priorityKey 在调用方上下文中保存优先级的键
+type priorityKey struct{}
+
+// WithPriority 返回携带优先级的ctx，Client.Call使用它发起的调用都以该优先级发送
+// 服务端设置了MaxWorkers时，高优先级的请求先于排队中的普通请求被处理
+func WithPriority(ctx context.Context, priority uint8) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func callPriority(ctx context.Context) uint8 {
+	priority, _ := ctx.Value(priorityKey{}).(uint8)
+	return priority
+}
+
+// task 排队等待工作协程的请求
+type task struct {
+	fn       func()
+	enqueued time.Time
+}
+
+// scheduler 有界的工作协程池，高优先级的请求先被处理
+// 普通请求排队超过aging后先于高优先级请求，避免被饿死
+type scheduler struct {
+	mu      sync.Mutex
+	normal  []task
+	high    []task
+	running int
+	max     int
+	limit   int // 排队请求数的上限，0表示不设限
+	aging   time.Duration
+}
+
+// submit 将fn排队，工作协程不足max时启动一个，队列已满时返回false
+func (s *scheduler) submit(priority uint8, fn func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit > 0 && len(s.normal)+len(s.high) >= s.limit {
+		return false
+	}
+	t := task{fn: fn, enqueued: time.Now()}
+	if priority > PriorityNormal {
+		s.high = append(s.high, t)
+	} else {
+		s.normal = append(s.normal, t)
+	}
+	if s.running < s.max {
+		s.running++
+		go s.work()
+	}
+	return true
+}
+
+// work 依次处理排队的请求，队列为空时退出
+func (s *scheduler) work() {
+	for {
+		s.mu.Lock()
+		t, ok := s.next(time.Now())
+		if !ok {
+			s.running--
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		t.fn()
+	}
+}
+
+// next 取出下一个要处理的请求，调用方须持有s.mu
+func (s *scheduler) next(now time.Time) (task, bool) {
+	var q *[]task
+	switch {
+	case len(s.normal) > 0 && (len(s.high) == 0 || now.Sub(s.normal[0].enqueued) >= s.aging):
+		q = &s.normal
+	case len(s.high) > 0:
+		q = &s.high
+	default:
+		return task{}, false
+	}
+	t := (*q)[0]
+	(*q)[0] = task{}
+	*q = (*q)[1:]
+	return t, true
+}
+
+// queued 返回排队中的请求数
+func (s *scheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.normal) + len(s.high)
+}
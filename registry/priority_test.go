@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Queue struct {
+	gate  chan struct{}
+	mu    sync.Mutex
+	order []string
+}
+
+func (q *Queue) Block(_ int, _ *int) error {
+	<-q.gate
+	return nil
+}
+
+func (q *Queue) Record(name string, _ *int) error {
+	q.mu.Lock()
+	q.order = append(q.order, name)
+	q.mu.Unlock()
+	return nil
+}
+
+// saturate blocks the only worker of server, queues normal and high
+// requests, waits for wait and then frees the worker.
+func saturate(t *testing.T, aging, wait time.Duration) []string {
+	q := &Queue{gate: make(chan struct{})}
+	server := &Server{MaxWorkers: 1, PriorityAging: aging}
+	_ = server.Register(q)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	blocked := client.Go("Queue.Block", 0, nil, nil)
+	var wg sync.WaitGroup
+	call := func(name string, priority uint8) {
+		defer wg.Done()
+		_ = client.Call(WithPriority(context.Background(), priority), "Queue.Record", name, nil)
+	}
+	for i, name := range []string{"n1", "n2"} {
+		wg.Add(1)
+		go call(name, PriorityNormal)
+		for server.scheduler().queued() < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Add(1)
+	go call("h1", PriorityHigh)
+	for server.scheduler().queued() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(wait)
+	close(q.gate)
+	<-blocked.Done
+	wg.Wait()
+	return q.order
+}
+
+func TestServer_Priority(t *testing.T) {
+	t.Parallel()
+	order := saturate(t, time.Minute, 0)
+	_assert(len(order) == 3 && order[0] == "h1", "expect the high priority call first, got %v", order)
+
+	// normal calls queued for longer than PriorityAging go first
+	order = saturate(t, time.Millisecond*20, time.Millisecond*50)
+	_assert(len(order) == 3 && order[0] == "n1" && order[1] == "n2", "expect aged normal calls first, got %v", order)
+}
+
+func TestScheduler_Next(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	s := &scheduler{aging: time.Second}
+	s.normal = []task{{enqueued: now.Add(-time.Millisecond)}}
+	s.high = []task{{enqueued: now}}
+	_, _ = s.next(now)
+	_assert(len(s.high) == 0 && len(s.normal) == 1, "expect the high priority task first")
+	_, _ = s.next(now)
+	_assert(len(s.normal) == 0, "expect the normal task once no high priority task is left")
+	_, ok := s.next(now)
+	_assert(!ok, "expect no task")
+
+	s.normal = []task{{enqueued: now.Add(-time.Second)}}
+	s.high = []task{{enqueued: now}}
+	_, _ = s.next(now)
+	_assert(len(s.normal) == 0 && len(s.high) == 1, "expect the aged normal task first")
+}
+
+// not parallel, so that the goroutines counted are this test's
+func TestServer_MaxQueuedRequests(t *testing.T) {
+	var s Slow
+	server := &Server{MaxWorkers: 4, MaxQueuedRequests: 64}
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	base := runtime.NumGoroutine()
+	peak := int64(base)
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				if n := int64(runtime.NumGoroutine()); n > peak {
+					peak = n
+				}
+			}
+		}
+	}()
+
+	const total = 10000
+	done := make(chan *Call, total)
+	for i := 0; i < total; i++ {
+		client.Go("Slow.Sleep", 1, new(int), done)
+	}
+	var ok, busy int
+	for i := 0; i < total; i++ {
+		call := <-done
+		switch {
+		case call.Error == nil:
+			ok++
+		case CodedErrorCode(call.Error) == CodeServerBusy && IsRetryable(call.Error):
+			busy++
+		default:
+			t.Fatalf("unexpected error: %v", call.Error)
+		}
+	}
+	close(stop)
+	<-sampled
+	_assert(ok > 0 && busy > 0 && ok+busy == total, "expect both handled and rejected requests, got %d ok and %d busy", ok, busy)
+	_assert(server.RejectedRequests() == uint64(busy), "expect %d rejected requests, got %d", busy, server.RejectedRequests())
+	// a worker runs a request in up to 2 goroutines, the rest is slack for
+	// timers and the sampler, without the pool it grows by thousands
+	_assert(peak-int64(base) <= 32, "expect a bounded number of goroutines, grew by %d", peak-int64(base))
+}
+
+type Gauge struct {
+	running, peak int64
+}
+
+func (g *Gauge) Spin(_ int, _ *int) error {
+	n := atomic.AddInt64(&g.running, 1)
+	defer atomic.AddInt64(&g.running, -1)
+	for {
+		peak := atomic.LoadInt64(&g.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&g.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * 2)
+	return nil
+}
+
+func TestServer_HandlerPoolSize(t *testing.T) {
+	t.Parallel()
+	g := new(Gauge)
+	server := NewServer()
+	_ = server.Register(g)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := DialWith("tcp", l.Addr().String(), WithHandlerPoolSize(3))
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = client.Call(context.Background(), "Gauge.Spin", 0, nil)
+		}()
+	}
+	wg.Wait()
+	peak := atomic.LoadInt64(&g.peak)
+	_assert(peak >= 1 && peak <= 3, "expect at most 3 concurrent handlers, got %d", peak)
+}
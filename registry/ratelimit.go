@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitWait 未设置Server.RateLimitWait时请求等待令牌的最长时间
+const defaultRateLimitWait = 100 * time.Millisecond
+
+// rateLimitQueue 每个连接上同时等待令牌的请求数上限，超出的请求立即以ErrRateLimited响应
+const rateLimitQueue = 64
+
+// SetRateLimit 限制每个连接的请求速率，每秒补充requestsPerSecond个令牌，最多积攒burst个，burst小于1时按1处理
+// 请求取得令牌后才被处理，需要等待时不阻塞读取，等待超过RateLimitWait或等待的请求过多时以ErrRateLimited响应
+// 只限制新的请求，回调的响应和双向流上的消息不受限制，须在开始服务之前设置，requestsPerSecond不大于0时取消限制
+func (server *Server) SetRateLimit(requestsPerSecond float64, burst int) {
+	server.rateLimit, server.rateBurst = requestsPerSecond, burst
+}
+
+// rateLimiter 返回新连接使用的令牌桶，未设置SetRateLimit时为nil
+func (server *Server) rateLimiter() *rateLimiter {
+	if server.rateLimit <= 0 {
+		return nil
+	}
+	burst := math.Max(float64(server.rateBurst), 1)
+	return &rateLimiter{rate: server.rateLimit, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// rateLimitWait 返回请求等待令牌的最长时间
+func (server *Server) rateLimitWait() time.Duration {
+	if server.RateLimitWait > 0 {
+		return server.RateLimitWait
+	}
+	return defaultRateLimitWait
+}
+
+// rateLimiter 连接的令牌桶，令牌可以预支，预支的请求等到令牌补足后再处理
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 每秒补充的令牌数
+	burst   float64
+	tokens  float64 // 可用的令牌，为负数时表示已预支的令牌
+	last    time.Time
+	waiting int // 预支了令牌、等待处理的请求数
+}
+
+// reserve 为一个请求取出令牌，返回处理前须等待的时间
+// 等待时间超过maxWait或等待的请求已达rateLimitQueue时不取令牌，ok为false
+// 返回的等待时间大于0时，等待结束后须调用done
+func (l *rateLimiter) reserve(now time.Time, maxWait time.Duration) (wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.After(l.last) {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if wait > maxWait || l.waiting >= rateLimitQueue {
+		return 0, false
+	}
+	l.tokens--
+	l.waiting++
+	return wait, true
+}
+
+// done 预支了令牌的请求结束等待
+func (l *rateLimiter) done() {
+	l.mu.Lock()
+	l.waiting--
+	l.mu.Unlock()
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	l := &rateLimiter{rate: 10, burst: 2, tokens: 2, last: now}
+	for i := 0; i < 2; i++ {
+		wait, ok := l.reserve(now, 150*time.Millisecond)
+		_assert(ok && wait == 0, "expect the burst to be admitted at once, got %s %v", wait, ok)
+	}
+	wait, ok := l.reserve(now, 150*time.Millisecond)
+	_assert(ok && wait == 100*time.Millisecond, "expect to wait for the next token, got %s %v", wait, ok)
+	_, ok = l.reserve(now, 150*time.Millisecond)
+	_assert(!ok, "expect a wait beyond the threshold to be refused")
+	l.done()
+	// refused requests take no token
+	wait, ok = l.reserve(now.Add(time.Second), 150*time.Millisecond)
+	_assert(ok && wait == 0 && l.tokens == 1, "expect the bucket to refill, got %s %v %v", wait, ok, l.tokens)
+}
+
+func startRateLimitedServer(rps float64, burst int, maxWait time.Duration) string {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.SetRateLimit(rps, burst)
+	server.RateLimitWait = maxWait
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func TestServer_SetRateLimit(t *testing.T) {
+	t.Parallel()
+	t.Run("admitted rate", func(t *testing.T) {
+		t.Parallel()
+		client, _ := Dial("tcp", startRateLimitedServer(100, 1, time.Second))
+		defer func() { _ = client.Close() }()
+		const n = 40
+		start := time.Now()
+		calls := make([]*Call, n)
+		for i := range calls {
+			calls[i] = client.Go("Foo.Sum", Args{Num1: i, Num2: 1}, new(int), make(chan *Call, 1))
+		}
+		for _, call := range calls {
+			<-call.Done
+			_assert(call.Error == nil, "expect every call to wait for a token, got %v", call.Error)
+		}
+		// the first token is there at once, the others come every 10ms
+		elapsed := time.Since(start)
+		rate := float64(n-1) / elapsed.Seconds()
+		_assert(rate <= 110, "expect at most 100 requests per second, got %.1f in %s", rate, elapsed)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		t.Parallel()
+		client, _ := Dial("tcp", startRateLimitedServer(10, 2, 10*time.Millisecond))
+		defer func() { _ = client.Close() }()
+		calls := make([]*Call, 10)
+		for i := range calls {
+			calls[i] = client.Go("Foo.Sum", Args{Num1: i, Num2: 1}, new(int), make(chan *Call, 1))
+		}
+		var admitted, limited int
+		for _, call := range calls {
+			<-call.Done
+			switch {
+			case call.Error == nil:
+				admitted++
+			case CodedErrorCode(call.Error) == CodeRateLimited && IsRetryable(call.Error):
+				limited++
+			default:
+				t.Fatalf("unexpected error %v", call.Error)
+			}
+		}
+		_assert(admitted >= 2 && limited > 0 && admitted+limited == 10, "expect the burst to pass and the rest limited, got %d and %d", admitted, limited)
+		_assert(client.IsAvailable(), "expect the client to stay usable")
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		t.Parallel()
+		client, _ := Dial("tcp", startRateLimitedServer(0, 0, 0))
+		defer func() { _ = client.Close() }()
+		for i := 0; i < 20; i++ {
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+			_assert(err == nil && reply == i+1, "expect no limit by default, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// reflectionService 内置的自省服务名，客户端以_goRPC.Reflect.ListServices等调用，Server.DisableReflection为true时不可用
+const reflectionService = "_goRPC.Reflect"
+
+// ServiceDesc ListServices返回的服务描述，函数注册的服务只有一个同名方法
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc // 按方法名排序
+}
+
+// MethodDesc 方法的描述，类型为Go的类型名
+type MethodDesc struct {
+	Name      string
+	ArgType   string
+	ReplyType string
+	Signature string // 不含方法名与接收者的签名，可以区分两种形式，见registerMethods
+}
+
+// DescribeArgs DescribeMethod的参数
+type DescribeArgs struct {
+	Service, Method string
+}
+
+// FieldDesc 参数结构体的导出字段
+type FieldDesc struct {
+	Name string
+	Type string
+	Kind string
+}
+
+// MethodDetail DescribeMethod返回的方法详情
+type MethodDetail struct {
+	MethodDesc
+	ArgKind string
+	Fields  []FieldDesc // 参数为结构体或其指针时的导出字段，否则为空
+}
+
+// reflection 自省服务的接收者，结果在第一次调用时计算并缓存，注册或注销服务后重新计算
+type reflection struct {
+	server   *Server
+	mu       sync.Mutex
+	gen      uint64 // 缓存对应的Server.generation
+	services []ServiceDesc
+	details  map[string]*MethodDetail // 服务名.方法名 -> 方法详情
+}
+
+// ListServices 返回已注册的服务及其方法，不包括自省服务本身
+func (r *reflection) ListServices(_ int, reply *[]ServiceDesc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check()
+	if r.services == nil {
+		r.services = r.server.describeServices()
+	}
+	*reply = r.services
+	return nil
+}
+
+// DescribeMethod 返回方法的签名以及参数结构体的字段
+func (r *reflection) DescribeMethod(args DescribeArgs, reply *MethodDetail) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check()
+	key := args.Service + "." + args.Method
+	if d, ok := r.details[key]; ok {
+		*reply = *d
+		return nil
+	}
+	m := r.server.lookupMethod(args.Service, args.Method)
+	if m == nil {
+		return errors.New("rpc server: can't find method " + key)
+	}
+	d := describeMethod(args.Method, m)
+	r.details[key] = d
+	*reply = *d
+	return nil
+}
+
+// check 服务有变化时丢弃缓存，调用方须持有r.mu
+func (r *reflection) check() {
+	if gen := atomic.LoadUint64(&r.server.generation); gen != r.gen || r.details == nil {
+		r.gen, r.services, r.details = gen, nil, make(map[string]*MethodDetail)
+	}
+}
+
+// reflection 返回自省服务，第一次调用时创建
+func (server *Server) reflection() *service {
+	server.reflectOnce.Do(func() {
+		server.reflectSvc = newNamedService(reflectionService, &reflection{server: server})
+	})
+	return server.reflectSvc
+}
+
+// lookupMethod 按服务名和方法名查找方法，函数注册的服务的方法名与服务名相同
+func (server *Server) lookupMethod(serviceName, methodName string) *methodType {
+	if svci, ok := server.funcMap.Load(serviceName); ok && serviceName == methodName {
+		return svci.(*service).method[methodName]
+	}
+	if svci, ok := server.serviceMap.Load(serviceName); ok {
+		return svci.(*service).method[methodName]
+	}
+	return nil
+}
+
+// describeServices 描述serviceMap和funcMap中的所有服务，按服务名排序
+func (server *Server) describeServices() []ServiceDesc {
+	services := make([]ServiceDesc, 0)
+	collect := func(namei, svci interface{}) bool {
+		desc := ServiceDesc{Name: namei.(string)}
+		for name, m := range svci.(*service).method {
+			desc.Methods = append(desc.Methods, describeMethod(name, m).MethodDesc)
+		}
+		sort.Slice(desc.Methods, func(i, j int) bool { return desc.Methods[i].Name < desc.Methods[j].Name })
+		services = append(services, desc)
+		return true
+	}
+	server.serviceMap.Range(collect)
+	server.funcMap.Range(collect)
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// describeMethod 通过反射描述方法及其参数的导出字段
+func describeMethod(name string, m *methodType) *MethodDetail {
+	d := &MethodDetail{
+		MethodDesc: MethodDesc{
+			Name:      name,
+			ArgType:   m.ArgType.String(),
+			ReplyType: m.ReplyType.String(),
+			Signature: m.Signature(),
+		},
+		ArgKind: m.ArgType.Kind().String(),
+	}
+	t := m.ArgType
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return d
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		d.Fields = append(d.Fields, FieldDesc{Name: f.Name, Type: f.Type.String(), Kind: f.Type.Kind().String()})
+	}
+	return d
+}
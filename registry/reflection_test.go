@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestServer_Reflection(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(new(Shapes))
+	_ = server.RegisterFunc("Add", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	})
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var services []ServiceDesc
+	err := client.Call(ctx, "_goRPC.Reflect.ListServices", 0, &services)
+	_assert(err == nil && len(services) == 3, "expect 3 services, got %v: %v", services, err)
+	_assert(services[0].Name == "Add" && services[1].Name == "Foo" && services[2].Name == "Shapes", "expect sorted services, got %v", services)
+	sum := services[1].Methods[0]
+	_assert(sum.Name == "Sum" && sum.ArgType == "registry.Args" && sum.ReplyType == "*int", "unexpected Foo.Sum %+v", sum)
+	_assert(len(services[2].Methods) == 8 && services[2].Methods[0].Name == "Double", "unexpected Shapes methods %v", services[2].Methods)
+	_assert(services[2].Methods[0].Signature == "(int) (int, error)", "expect the returning form, got %v", services[2].Methods[0])
+
+	var detail MethodDetail
+	err = client.Call(ctx, "_goRPC.Reflect.DescribeMethod", DescribeArgs{Service: "Shapes", Method: "Move"}, &detail)
+	_assert(err == nil && detail.ArgKind == "struct" && len(detail.Fields) == 2, "unexpected detail %+v: %v", detail, err)
+	_assert(detail.Fields[0] == FieldDesc{Name: "X", Type: "int", Kind: "int"}, "unexpected field %+v", detail.Fields[0])
+	err = client.Call(ctx, "_goRPC.Reflect.DescribeMethod", DescribeArgs{Service: "Add", Method: "Add"}, &detail)
+	_assert(err == nil && detail.Signature == "(registry.Args, *int) error" && len(detail.Fields) == 2, "unexpected detail %+v: %v", detail, err)
+	err = client.Call(ctx, "_goRPC.Reflect.DescribeMethod", DescribeArgs{Service: "Foo", Method: "Nope"}, &detail)
+	_assert(err != nil && strings.Contains(err.Error(), "Foo.Nope"), "expect an unknown method error, got %v", err)
+
+	// the cached list follows registrations
+	_ = server.Unregister("Shapes")
+	services = nil
+	err = client.Call(ctx, "_goRPC.Reflect.ListServices", 0, &services)
+	_assert(err == nil && len(services) == 2, "expect 2 services after Unregister, got %v: %v", services, err)
+}
+
+func TestServer_DisableReflection(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := &Server{DisableReflection: true}
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var services []ServiceDesc
+	err := client.Call(context.Background(), "_goRPC.Reflect.ListServices", 0, &services)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect reflection to be disabled, got %v", err)
+	_assert(len(server.Services()) == 1, "expect reflection not to be listed, got %v", server.Services())
+}
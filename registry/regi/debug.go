@@ -0,0 +1,68 @@
+package regi
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const debugText = `<html>
+	<body>
+	<title>goRPC Registry</title>
+	<hr>
+	Timeout {{.Timeout}}
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>Last heartbeat</th><th align=center>Status</th>
+		{{range .Servers}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.LastHeartbeat.Format "2006-01-02 15:04:05"}}</td>
+			<td align=center>{{.Status}}</td>
+			</tr>
+		{{end}}
+		</table>
+	</body>
+	</html>`
+
+var debug = template.Must(template.New("registry debug").Parse(debugText))
+
+// debugHTTP 以HTML表格展示注册中心中的所有服务
+type debugHTTP struct {
+	*GoRegistry
+}
+
+// status 根据距上次心跳的时间计算服务状态，剩余时间不足timeout的五分之一时为expiring
+func (r *GoRegistry) status(s *ServerItem, now time.Time) string {
+	if r.timeout == 0 {
+		return "alive"
+	}
+	remaining := s.start.Add(r.timeout).Sub(now)
+	switch {
+	case remaining <= 0:
+		return "expired"
+	case remaining < r.timeout/5:
+		return "expiring"
+	}
+	return "alive"
+}
+
+func (r debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	addrs := make([]string, 0, len(r.servers))
+	for addr := range r.servers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	servers := r.serverInfos(addrs, time.Now())
+	r.mu.Unlock()
+	err := debug.Execute(w, struct {
+		Timeout time.Duration
+		Servers []ServerInfo
+	}{r.timeout, servers})
+	if err != nil {
+		_, _ = fmt.Fprintln(w, "rpc registry: error executing template:", err.Error())
+	}
+}
@@ -0,0 +1,47 @@
+package regi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoRegistry_Debug(t *testing.T) {
+	r := New(time.Minute)
+	r.HandleHTTP("/_goRPC_/regi_debug_test")
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	r.putServer("tcp@127.0.0.1:9999")
+	r.putServer("tcp@127.0.0.1:9998")
+	r.servers["tcp@127.0.0.1:9998"].start = time.Now().Add(-time.Second * 55)
+	resp, err := http.Get(ts.URL + "/_goRPC_/regi_debug_test/debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	page := string(b)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(page, "tcp@127.0.0.1:9999") {
+		t.Fatalf("expect the registered address on the page, got %d:\n%s", resp.StatusCode, page)
+	}
+	if !strings.Contains(page, "alive") || !strings.Contains(page, "expiring") {
+		t.Fatalf("expect an alive and an expiring server:\n%s", page)
+	}
+}
+
+func TestGoRegistry_Status(t *testing.T) {
+	now := time.Now()
+	r := New(time.Minute)
+	for ago, want := range map[time.Duration]string{0: "alive", 50 * time.Second: "expiring", 2 * time.Minute: "expired"} {
+		if got := r.status(&ServerItem{start: now.Add(-ago)}, now); got != want {
+			t.Fatalf("heartbeat %s ago: expect %s, got %s", ago, want, got)
+		}
+	}
+	if got := New(0).status(&ServerItem{start: now.Add(-time.Hour)}, now); got != "alive" {
+		t.Fatalf("expect servers never to expire without timeout, got %s", got)
+	}
+}
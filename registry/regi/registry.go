@@ -1,9 +1,13 @@
 package regi
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +20,8 @@ type GoRegistry struct {
 	timeout time.Duration
 	mu      sync.Mutex
 	servers map[string]*ServerItem
+	version uint64        // 服务列表的版本，列表变化时递增
+	changed chan struct{} // 列表变化时关闭并替换，用于唤醒等待中的长轮询
 }
 
 type ServerItem struct {
@@ -23,9 +29,19 @@ type ServerItem struct {
 	start time.Time
 }
 
+// ServerInfo 服务的地址和心跳信息，GET请求的Accept为application/json时以JSON数组返回
+type ServerInfo struct {
+	Addr          string
+	LastHeartbeat time.Time
+	Status        string // alive、expiring或expired，见debug页面
+}
+
 const (
 	defaultPath    = "/_goRPC_/regi"
 	defaultTimeout = time.Minute * 5
+	debugPath      = "/debug"
+	// watchTimeout 长轮询的最长等待时间，超时后返回当前列表
+	watchTimeout = time.Second * 30
 )
 
 // New 创建具有超时设置的注册实例
@@ -33,6 +49,7 @@ func New(timeout time.Duration) *GoRegistry {
 	return &GoRegistry{
 		servers: make(map[string]*ServerItem),
 		timeout: timeout,
+		changed: make(chan struct{}),
 	}
 }
 
@@ -48,32 +65,101 @@ func (r *GoRegistry) putServer(addr string) {
 			Addr:  addr,
 			start: time.Now(),
 		}
+		r.bump()
 	} else {
 		//如果存在，更新时间来保持存活
 		s.start = time.Now()
 	}
 }
 
-// aliveServers 返回可用的服务列表，如果存在超时服务，则删除
-func (r *GoRegistry) aliveServers() []string {
+// removeServer 注销服务实例
+func (r *GoRegistry) removeServer(addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, ok := r.servers[addr]; ok {
+		delete(r.servers, addr)
+		r.bump()
+	}
+}
+
+// aliveServers 返回可用的服务列表，如果存在超时服务，则删除
+// 调用方须持有r.mu
+func (r *GoRegistry) aliveServers() []string {
 	var alive []string
+	removed := false
 	for addr, s := range r.servers {
 		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
 			alive = append(alive, addr)
 		} else {
 			delete(r.servers, addr)
+			removed = true
 		}
 	}
+	if removed {
+		r.bump()
+	}
 	sort.Strings(alive)
 	return alive
 }
 
+// serverInfos 返回addrs中各服务的信息，调用方须持有r.mu
+func (r *GoRegistry) serverInfos(addrs []string, now time.Time) []ServerInfo {
+	infos := make([]ServerInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		if s := r.servers[addr]; s != nil {
+			infos = append(infos, ServerInfo{Addr: addr, LastHeartbeat: s.start, Status: r.status(s, now)})
+		}
+	}
+	return infos
+}
+
+// bump 递增版本并唤醒等待的长轮询，调用方须持有r.mu
+func (r *GoRegistry) bump() {
+	r.version++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// watch 返回可用的服务列表及其版本
+// since为请求携带的版本，与当前版本相同时等待列表变化、请求取消或watchTimeout后再返回
+func (r *GoRegistry) watch(ctx context.Context, since string) ([]string, uint64) {
+	v, err := strconv.ParseUint(since, 10, 64)
+	waiting := since != "" && err == nil
+	timer := time.NewTimer(watchTimeout)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		alive, version, changed := r.aliveServers(), r.version, r.changed
+		r.mu.Unlock()
+		if !waiting || version != v {
+			return alive, version
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			waiting = false
+		case <-timer.C:
+			// 超时后重新计算一次，以便返回期间过期的服务
+			waiting = false
+		}
+	}
+}
+
 func (r *GoRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-goRPC-Servers", strings.Join(r.aliveServers(), ","))
+		// 携带X-goRPC-Watch时为长轮询，列表版本变化后才返回
+		alive, version := r.watch(req.Context(), req.Header.Get("X-goRPC-Watch"))
+		w.Header().Set("X-goRPC-Servers", strings.Join(alive, ","))
+		w.Header().Set("X-goRPC-Version", strconv.FormatUint(version, 10))
+		// 请求JSON时在响应体中附带各服务的信息，只读取响应头的旧客户端不受影响
+		if strings.Contains(req.Header.Get("Accept"), "application/json") {
+			r.mu.Lock()
+			infos := r.serverInfos(alive, time.Now())
+			r.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(infos)
+		}
 	case "POST":
 		addr := req.Header.Get("X-goRPC-Server")
 		if addr == "" {
@@ -81,15 +167,24 @@ func (r *GoRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 		r.putServer(addr)
+	case "DELETE":
+		addr := req.Header.Get("X-goRPC-Server")
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.removeServer(addr)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-// HandleHTTP 为注册表的goRegistry注册一个HTTP处理程序
+// HandleHTTP 为注册表的goRegistry注册一个HTTP处理程序，并在registryPath/debug注册展示所有服务的页面
 func (r *GoRegistry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
+	http.Handle(registryPath+debugPath, debugHTTP{r})
 	log.Println("rpc registry path:", registryPath)
+	log.Println("rpc registry debug path:", registryPath+debugPath)
 }
 
 func HandleHTTP() {
@@ -97,29 +192,70 @@ func HandleHTTP() {
 }
 
 func Heartbeat(registry, addr string, duration time.Duration) {
+	StartHeartbeat(registry, addr, duration)
+}
+
+// StartHeartbeat 与Heartbeat相同，返回的stop停止发送心跳并从注册中心注销addr，返回时注销已完成
+// 发送失败时记录错误，下一个周期继续发送，注册中心短暂不可用后服务会重新出现在列表中
+func StartHeartbeat(registry, addr string, duration time.Duration) (stop func()) {
 	if duration == 0 {
 		//确保有足够的时间发送心跳在被移除出注册表之前
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
-	var err error
-	err = sendHeartbeat(registry, addr)
+	heartbeat := func() {
+		if err := sendHeartbeat(registry, addr); err != nil {
+			log.Println(addr, "heart beat failed, retry in", duration, "error:", err)
+		}
+	}
+	heartbeat()
+	done, stopped := make(chan struct{}), make(chan struct{})
 	go func() {
+		defer close(stopped)
 		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				heartbeat()
+			case <-done:
+				_ = Deregister(registry, addr)
+				return
+			}
 		}
 	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
 }
 
 func sendHeartbeat(registry, addr string) error {
 	log.Println(addr, "send heart beat to registry", registry)
+	return send("POST", registry, addr)
+}
+
+// Deregister 从注册中心注销addr，客户端在下次获取服务列表时不再看到它
+func Deregister(registry, addr string) error {
+	log.Println(addr, "deregister from registry", registry)
+	return send("DELETE", registry, addr)
+}
+
+// send 向注册中心发送关于addr的请求
+func send(method, registry, addr string) error {
 	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
+	req, _ := http.NewRequest(method, registry, nil)
 	req.Header.Set("X-goRPC-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Println("rpc server: registry request err:", err)
 		return err
 	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("rpc server: registry request %s: %s", method, resp.Status)
+	}
 	return nil
 }
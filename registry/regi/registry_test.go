@@ -0,0 +1,105 @@
+package regi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// servers 返回注册中心当前的服务列表
+func servers(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get("X-goRPC-Servers")
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	stop := StartHeartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Millisecond*10)
+	if got := servers(t, ts.URL); got != "tcp@127.0.0.1:9999" {
+		t.Fatalf("expect the server to be registered, got %q", got)
+	}
+	time.Sleep(time.Millisecond * 50)
+	stop()
+	stop()
+	if got := servers(t, ts.URL); got != "" {
+		t.Fatalf("expect the server to be deregistered, got %q", got)
+	}
+	r.mu.Lock()
+	v := r.version
+	r.mu.Unlock()
+	if v != 2 {
+		t.Fatalf("expect registration and deregistration to bump the version, got %d", v)
+	}
+}
+
+func TestStartHeartbeat_Failure(t *testing.T) {
+	r := New(100 * time.Millisecond)
+	var posts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the registry fails the second heartbeat
+		if req.Method == "POST" && atomic.AddInt32(&posts, 1) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+
+	stop := StartHeartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Millisecond*20)
+	time.Sleep(time.Millisecond * 300)
+	if got := servers(t, ts.URL); got != "tcp@127.0.0.1:9999" || atomic.LoadInt32(&posts) < 5 {
+		t.Fatalf("expect the heartbeats to go on after a failure, got %q after %d heartbeats", got, atomic.LoadInt32(&posts))
+	}
+	stop()
+	if got := servers(t, ts.URL); got != "" {
+		t.Fatalf("expect the server to be deregistered, got %q", got)
+	}
+}
+
+func TestGoRegistry_JSON(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	before := time.Now()
+	for _, addr := range []string{"tcp@127.0.0.1:2", "tcp@127.0.0.1:1"} {
+		if err := sendHeartbeat(ts.URL, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var infos []ServerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatal("failed to decode:", err)
+	}
+	if len(infos) != 2 || infos[0].Addr != "tcp@127.0.0.1:1" || infos[1].Addr != "tcp@127.0.0.1:2" {
+		t.Fatalf("expect the registered servers, got %+v", infos)
+	}
+	for _, info := range infos {
+		if info.LastHeartbeat.Before(before) || info.Status != "alive" {
+			t.Fatalf("unexpected server info %+v", info)
+		}
+	}
+	if got := resp.Header.Get("X-goRPC-Servers"); got != "tcp@127.0.0.1:1,tcp@127.0.0.1:2" {
+		t.Fatalf("expect the header to be kept, got %q", got)
+	}
+	if got := servers(t, ts.URL); got != "tcp@127.0.0.1:1,tcp@127.0.0.1:2" {
+		t.Fatalf("expect plain GETs to be unchanged, got %q", got)
+	}
+}
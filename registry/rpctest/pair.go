@@ -0,0 +1,54 @@
+// Package rpctest wires a registry.Server and registry.Client together
+// in memory for tests, without listening on a socket or sleeping until
+// the server is up.
+//
+//	client, server, cleanup := rpctest.NewPair(t, new(Foo))
+//	defer cleanup()
+//	err := client.Call(ctx, "Foo.Sum", args, &reply)
+//
+// Pass DialOptions to NewPairWith to pick the codec or other options:
+//
+//	client, _, cleanup := rpctest.NewPairWith(t,
+//		[]registry.DialOption{registry.WithCodec(codec.JsonType)}, new(Foo))
+package rpctest
+
+import (
+	"goRPC/registry"
+	"net"
+	"testing"
+)
+
+// NewPair returns a client connected over net.Pipe to a new server with
+// receivers registered, using the default options. The returned func
+// closes the client, which also ends the server side of the pipe.
+// Setup failures fail t.
+func NewPair(t testing.TB, receivers ...interface{}) (*registry.Client, *registry.Server, func()) {
+	t.Helper()
+	return NewPairWith(t, nil, receivers...)
+}
+
+// NewPairWith is like NewPair but applies opts to the default options
+// before the client handshakes with the server.
+func NewPairWith(t testing.TB, opts []registry.DialOption, receivers ...interface{}) (*registry.Client, *registry.Server, func()) {
+	t.Helper()
+	opt := *registry.DefaultOption
+	for _, o := range opts {
+		if err := o(&opt); err != nil {
+			t.Fatal("rpctest: invalid option:", err)
+		}
+	}
+	server := registry.NewServer()
+	for _, rcvr := range receivers {
+		if err := server.Register(rcvr); err != nil {
+			t.Fatal("rpctest: failed to register:", err)
+		}
+	}
+	c, s := net.Pipe()
+	go server.ServeConn(s)
+	client, err := registry.NewClient(c, &opt)
+	if err != nil {
+		_ = c.Close()
+		t.Fatal("rpctest: handshake failed:", err)
+	}
+	return client, server, func() { _ = client.Close() }
+}
@@ -0,0 +1,43 @@
+package rpctest
+
+import (
+	"context"
+	"goRPC/client/codec"
+	"goRPC/registry"
+	"testing"
+)
+
+type Calc int
+
+type Pair struct{ A, B int }
+
+func (c Calc) Mul(p Pair, reply *int) error {
+	*reply = p.A * p.B
+	return nil
+}
+
+func TestNewPair(t *testing.T) {
+	t.Parallel()
+	client, server, cleanup := NewPair(t, new(Calc))
+	var reply int
+	if err := client.Call(context.Background(), "Calc.Mul", Pair{A: 3, B: 4}, &reply); err != nil || reply != 12 {
+		t.Fatalf("failed to call Calc.Mul: %v %d", err, reply)
+	}
+	if server == nil || !client.IsAvailable() {
+		t.Fatal("expect a server and an available client")
+	}
+	cleanup()
+	if client.IsAvailable() {
+		t.Fatal("expect cleanup to close the client")
+	}
+}
+
+func TestNewPairWith(t *testing.T) {
+	t.Parallel()
+	client, _, cleanup := NewPairWith(t, []registry.DialOption{registry.WithCodec(codec.JsonType)}, new(Calc))
+	defer cleanup()
+	var reply int
+	if err := client.Call(context.Background(), "Calc.Mul", Pair{A: 2, B: 5}, &reply); err != nil || reply != 10 {
+		t.Fatalf("failed to call Calc.Mul over json: %v %d", err, reply)
+	}
+}
@@ -2,51 +2,188 @@ package registry
 
 //处理通信过程
 import (
+	"context"
+	"crypto/tls"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"goRPC/client/codec"
+	"goRPC/registry/regi"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MagicNumber = 0x3bef5c
+
+// ProtocolVersion 当前支持的最高协议版本，帧格式变化时递增
+// 版本2起，服务端在收到Option后回复握手结果，见handshakeReply
+// 版本3起，Codec不再在每次Write后自动Flush，由发送方显式Flush，帧格式与版本2相同
+// 版本4起，服务端不响应Header.Oneway的请求，见Client.Notify
+// 版本5起，Option和握手结果可以用Codec的数字编号代替类型，见Option.CodecID
+const ProtocolVersion uint8 = 5
 const (
-	connected = "200 Connected to Gee RPC"
+	connected = "200 Connected to goRPC"
 	defaultRPCPath = "/_goRPC_"
 	defaultDebugPath = "/debug/goRPC"
 )
 // Option 消息的编解码方式
 type Option struct {
 	MagicNumber    int           //MagicNumber记录这是goRPC请求
-	CodecType      codec.Type    //客户端可能会选择不同Codec来编码body
+	Version        uint8         // 协议版本，默认值为1，0表示客户端早于版本协商，按1处理
+	CodecType      codec.Type    `json:",omitempty"` //客户端可能会选择不同Codec来编码body
+	// CodecID 代替CodecType的数字编号，非nil时优先于CodecType，服务端在握手结果中同样以编号回复
+	// 版本5起，客户端的CodecType有编号且未设置AcceptedCodecs时自动以编号发送
+	CodecID *codec.ID `json:",omitempty"`
+	// AcceptedCodecs 客户端按偏好排列的Codec，非空时服务端从中选出第一个支持的并在握手中返回，优先于CodecType
+	AcceptedCodecs []codec.Type
 	ConnectTimeout time.Duration // 默认值为10s
+	// HandshakeTimeout 客户端在NewClient中发送Option并读取握手结果的时限，超时后关闭连接，默认值为0，不设限
+	// Dial等函数仍以ConnectTimeout限制建立连接和握手的总时间，直接调用NewClient时只有它生效
+	HandshakeTimeout time.Duration
 	HandleTimeout  time.Duration // 默认值为0，不设限
+	SingleFlight   bool          // 客户端合并参数相同且仍在进行中的调用，只发送一次请求
+	// MaxPendingCalls 客户端同时等待响应的最大调用数，默认值为0，不设限
+	MaxPendingCalls int
+	// FailOnMaxPending 达到MaxPendingCalls时，为true则Go立即返回ErrTooManyPendingCalls，否则阻塞等待
+	FailOnMaxPending bool
+	// ShedHighWater 客户端等待响应的调用数达到该值后，新的调用立即返回ErrOverloaded，调用方可以据此减载，默认值为0，不减载
+	// ShedLowWater 开始减载后，等待响应的调用数降到该值以下才恢复接受新的调用，须小于ShedHighWater，避免在阈值附近反复切换
+	ShedHighWater int
+	ShedLowWater  int
+	// ClientID 客户端的标识，默认值为hostname/pid，服务端截断并清理后用于日志和统计，见ClientIDFromContext
+	ClientID string
+	// AuthToken 握手时发送的令牌，由服务端的Authenticate校验，只能通过TLS连接发送
+	AuthToken string
+	// AllowInsecureAuth 为true时允许通过非TLS连接发送令牌
+	AllowInsecureAuth bool
+	// HandlerPoolSize 服务端处理该连接请求的最大协程数，默认值为0，每个请求一个协程
+	// 服务端设置了MaxWorkers时以服务端的工作协程池为准
+	HandlerPoolSize int
+	// Ordered 为true时服务端按到达顺序逐个处理该连接的请求，响应也按请求的顺序发出，同一连接的请求不会交错访问共享状态
+	// 一个请求耗时较长时之后的请求都要等待，连接的吞吐量受限于单个请求的耗时，忽略HandlerPoolSize和服务端的MaxWorkers
+	// 双向流在结束前同样占用该连接的处理顺序
+	Ordered bool
+	// SkipHandshake 为true时客户端不发送Option，直接以gob编解码，服务端须以ServeConnNoHandshake服务该连接
+	// 两端须事先约定，仅用于可信的传输，例如同一主机上的UNIX socket，不能与AuthToken或其他Codec同时使用
+	SkipHandshake bool
+
+	cache      *responseCache // 客户端缓存的响应，见WithCache，不参与编码
+	tlsConfig  *tls.Config    // 客户端的TLS配置，见WithTLS，不参与编码
+	seqGen     func() uint64  // 客户端生成调用序号的函数，见WithSeqGenerator，不参与编码
+	logger     Logger         // 客户端的日志输出，见WithLogger，不参与编码
+	plainNames bool           // 客户端不校验ServiceMethod的格式，见WithPlainMethodNames，不参与编码
 }
 
 // Server 代表一个RPC服务器
 type Server struct {
-	serviceMap sync.Map
+	// MaxConnections 同时服务的最大连接数，默认值为0，不设限
+	MaxConnections int
+	// RejectOverflow 连接数达到MaxConnections时，为true则接受新连接后回复ErrServerAtCapacity并关闭，否则等待空位后再Accept
+	RejectOverflow bool
+	// Authenticate 校验握手时的Option.AuthToken（未设置时为空字符串）以及调用携带的令牌
+	// ctx中可以取得ClientIDFromContext、CommonNameFromContext、IdentityFromContext和ConnInfoFromContext，返回错误时握手或调用失败
+	Authenticate func(ctx context.Context, token string) error
+	// MaxWorkers 同时处理请求的最大协程数，默认值为0，不设限，每个请求一个协程
+	// 设置后超出的请求排队，高优先级的请求先被处理，见WithPriority
+	MaxWorkers int
+	// PriorityAging 普通请求排队超过该时间后先于高优先级请求被处理，默认值为1s
+	PriorityAging time.Duration
+	// MaxQueuedRequests 设置了MaxWorkers时排队请求数的上限，默认值为0，不设限
+	// 队列已满时新请求不被处理，立即以错误码为CodeServerBusy的错误响应
+	MaxQueuedRequests int
+	// SlowCallThreshold 请求从读取完毕到发出响应的耗时达到该值时打印警告，默认值为0，不打印
+	SlowCallThreshold time.Duration
+	// PanicStack 为true时，方法panic转换成的错误附带截断的调用栈，便于调试，默认只在服务端日志中记录调用栈
+	PanicStack bool
+	// Logger 服务端的日志输出，默认写入标准库的log，每个请求的访问日志以Debugf输出，默认被丢弃
+	Logger Logger
+	// RateLimitWait 设置了SetRateLimit时请求等待令牌的最长时间，超过时立即以ErrRateLimited响应，默认值为100ms
+	RateLimitWait time.Duration
+	// IdleTimeout 连接上没有进行中的请求且在该时间内没有收到任何帧时关闭连接，默认值为0，此时以ReadTimeout为准
+	// 客户端的心跳等任何帧都会重新计时，耗时较长的请求处理期间不计时
+	IdleTimeout time.Duration
+	// ReadTimeout 未设置IdleTimeout时空闲连接的最长存活时间，用于清理对端已失效却没有关闭的半开连接
+	// 默认值为10min，负值不设限，见ReapedConnections
+	ReadTimeout time.Duration
+	// HandshakeTimeout 连接建立后客户端发送Option的时限，超时后关闭连接，默认值为10s
+	HandshakeTimeout time.Duration
+	// TLSHandshakeTimeout TLS连接在开始服务前完成握手的时限，超时后关闭连接，默认值为10s，见AcceptTLS
+	TLSHandshakeTimeout time.Duration
+	// DisableReflection 为true时关闭内置的自省服务_goRPC.Reflect，不向客户端暴露服务和参数的结构
+	DisableReflection bool
+	// OptionValidator 握手时在选择Codec之前检查客户端的Option，返回错误时握手失败并关闭连接，错误信息回复给客户端
+	// 可以修改Option以覆盖客户端的选择，例如强制某个Codec或设置HandleTimeout，修改MagicNumber和Version无效
+	// 以CodecID发送的Codec在调用前填入CodecType，之后按CodecType回复。修改Codec须确保客户端支持它，
+	// 版本1的客户端不读取握手结果，无法得知新的Codec，对它们修改Codec会使握手失败
+	OptionValidator func(opt *Option) error
+	// ReuseValues 为true时复用指向结构体的参数和回复，响应发出后清零放回，减少高QPS时的内存分配
+	// 开启后方法返回后不能再持有参数或回复的指针，例如保存它们或在新的协程中访问，返回回复的方法返回的值不被复用
+	ReuseValues bool
+
+	serviceMap  sync.Map
+	funcMap     sync.Map      // 函数名 -> *service，见RegisterFunc和RegisterHandler
+	onPeer      func(p *Peer) // 连接建立后的回调，见OnPeer
+	clientCalls sync.Map      // 客户端标识 -> *uint64，各客户端发起的请求数
+	activeConns int64         // 正在服务的连接数
+	panics      uint64        // 恢复的方法panic数，见RecoveredPanics
+	rejected    uint64        // 因队列已满被拒绝的请求数，见RejectedRequests
+	reaped      uint64        // 因握手或读取超时被关闭的连接数，见ReapedConnections
+	semOnce     sync.Once
+	connSem     chan struct{} // 限制连接数的信号量
+	schedOnce   sync.Once
+	sched       *scheduler // 设置了MaxWorkers时的工作协程池
+	startOnce   sync.Once
+	started     time.Time // 服务器的启动时间，见DebugHTTP
+	// latency 请求耗时的统计，见Latency
+	latency latencyRecorder
+	// generation 注册或注销服务的次数，自省服务据此丢弃缓存
+	generation  uint64
+	reflectOnce sync.Once
+	reflectSvc  *service // 内置的自省服务，见DisableReflection
+	statsOnce   sync.Once
+	statsSvc    *service // 内置的统计服务，见stats
+	authFunc    AuthFunc // 握手时的校验钩子，见SetAuthFunc
+	rateLimit   float64  // 每个连接每秒的请求数，见SetRateLimit
+	rateBurst   int
+	magic       int // 接受的MagicNumber，0为默认值，见NewServerWithMagic
+	// methodTimeouts ServiceMethod -> time.Duration，见SetMethodTimeout
+	methodTimeouts sync.Map
+	// methodLimits ServiceMethod -> *methodLimit，见SetMethodLimit
+	methodLimits sync.Map
+	// validator 调用方法前校验参数的钩子，见SetValidator
+	validator func(serviceMethod string, args interface{}) error
+	// onConnect和onDisconnect 连接的生命周期钩子，见OnConnect和OnDisconnect
+	onConnect    func(info ConnInfo) error
+	onDisconnect func(info ConnInfo, reason error)
 }
 
 type request struct {
-	h            *codec.Header // 请求的请求头
-	argv, replyv reflect.Value // 请求的argv和replyv
+	ctx          context.Context // 请求的上下文，连接断开或处理超时后被取消
+	h            *codec.Header   // 请求的请求头
+	argv, replyv reflect.Value   // 请求的argv和replyv
 	mtype        *methodType
 	svc          *service
+	start        time.Time // 请求读取完毕的时间，用于统计耗时
+	size         int64     // 响应的字节数，Codec不支持统计时为0，用于访问日志
+	stream       *Stream   // 双向流，由读取协程建立，见newBidiStream
 }
 
 // DefaultOption 默认配置
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
+	Version:        ProtocolVersion,
 	CodecType:      codec.GobType,
 	ConnectTimeout: time.Second * 10,
+	ClientID:       defaultClientID(),
 }
 
 // DefaultServer 默认 *Server实例
@@ -57,99 +194,468 @@ var invalidRequest = struct{}{}
 
 // NewServer 构造服务器
 func NewServer() *Server {
-	return &Server{}
+	server := &Server{}
+	server.startTime()
+	return server
+}
+
+// NewServerWithMagic 返回只接受Option.MagicNumber为magic的连接的服务器，其他连接在读取Option后被关闭
+// 用于在共享的基础设施上隔离多个互不相通的RPC网络，客户端须以WithMagicNumber或Option.MagicNumber设置相同的值
+func NewServerWithMagic(magic int) *Server {
+	server := NewServer()
+	server.magic = magic
+	return server
+}
+
+// magicNumber 返回服务器接受的MagicNumber
+func (server *Server) magicNumber() int {
+	if server.magic != 0 {
+		return server.magic
+	}
+	return MagicNumber
+}
+
+// startTime 返回服务器的启动时间，未通过NewServer构造的服务器以首个连接的时间为准
+func (server *Server) startTime() time.Time {
+	server.startOnce.Do(func() { server.started = time.Now() })
+	return server.started
 }
 
 //Accept 接收监听者上的连接
 //并为每个传入连接提供请求
 func (server *Server) Accept(lis net.Listener) {
+	sem := server.connSemaphore()
+	var delay time.Duration // 暂时性错误后重试的等待时间
 	//while（true）等待socket连接的建立，并开启子协程处理，处理过程交给ServerConn方法
 	for {
+		if sem != nil && !server.RejectOverflow {
+			sem <- struct{}{}
+		}
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if sem != nil && !server.RejectOverflow {
+				<-sem
+			}
+			// 文件描述符耗尽等暂时性错误等待后重试，只有监听者关闭等永久性错误才停止服务
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay = acceptBackoff(delay)
+				server.logger().Errorf("rpc server: accept error: %v; retrying in %s", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			server.logger().Errorf("rpc server: accept error: %v", err)
 			return
 		}
-		go server.ServeConn(conn)
+		delay = 0
+		if sem != nil && server.RejectOverflow {
+			select {
+			case sem <- struct{}{}:
+			default:
+				server.logger().Infof("rpc server: too many connections, reject %s", conn.RemoteAddr())
+				go server.refuse(conn)
+				continue
+			}
+		}
+		go func() {
+			server.ServeConn(conn)
+			if sem != nil {
+				<-sem
+			}
+		}()
+	}
+}
+
+// minAcceptDelay和maxAcceptDelay Accept遇到暂时性错误后重试的最短和最长等待时间
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// acceptBackoff 返回连续的暂时性错误后下一次重试的等待时间，每次加倍，不超过maxAcceptDelay
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	if delay *= 2; delay > maxAcceptDelay {
+		delay = maxAcceptDelay
+	}
+	return delay
+}
+
+// refuse 读取被拒绝的客户端发送的Option，回复ErrServerAtCapacity后关闭连接
+// 客户端在refuseTimeout内没有完成握手时直接关闭
+func (server *Server) refuse(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(refuseTimeout))
+	var opt Option
+	if err := json.NewDecoder(conn).Decode(&opt); err != nil || opt.MagicNumber != server.magicNumber() {
+		return
 	}
+	_ = replyHandshake(conn, &opt, "", ErrServerAtCapacity)
+}
+
+// AcceptAndRegister 与Accept相同，同时以network@lis.Addr()为地址向registryURL的注册中心定期发送心跳
+// heartbeatInterval为0时使用regi.Heartbeat的默认间隔，lis关闭后停止心跳并从注册中心注销
+func (server *Server) AcceptAndRegister(lis net.Listener, registryURL string, heartbeatInterval time.Duration) {
+	stop := regi.StartHeartbeat(registryURL, lis.Addr().Network()+"@"+lis.Addr().String(), heartbeatInterval)
+	defer stop()
+	server.Accept(lis)
+}
+
+// scheduler 返回处理请求的工作协程池，未设置MaxWorkers时返回nil
+func (server *Server) scheduler() *scheduler {
+	server.schedOnce.Do(func() {
+		if server.MaxWorkers > 0 {
+			aging := server.PriorityAging
+			if aging <= 0 {
+				aging = defaultPriorityAging
+			}
+			server.sched = &scheduler{max: server.MaxWorkers, limit: server.MaxQueuedRequests, aging: aging}
+		}
+	})
+	return server.sched
+}
+
+// connSemaphore 返回限制连接数的信号量，未设置MaxConnections时返回nil
+func (server *Server) connSemaphore() chan struct{} {
+	server.semOnce.Do(func() {
+		if server.MaxConnections > 0 {
+			server.connSem = make(chan struct{}, server.MaxConnections)
+		}
+	})
+	return server.connSem
+}
+
+// clientCounter 返回客户端标识对应的请求计数
+func (server *Server) clientCounter(id string) *uint64 {
+	c, _ := server.clientCalls.LoadOrStore(id, new(uint64))
+	return c.(*uint64)
+}
+
+// CallsByClient 返回各客户端标识发起的请求数，相同标识的连接合并统计
+func (server *Server) CallsByClient() map[string]uint64 {
+	stats := make(map[string]uint64)
+	server.clientCalls.Range(func(id, c interface{}) bool {
+		stats[id.(string)] = atomic.LoadUint64(c.(*uint64))
+		return true
+	})
+	return stats
+}
+
+// ActiveConnections 返回正在服务的连接数
+func (server *Server) ActiveConnections() int64 {
+	return atomic.LoadInt64(&server.activeConns)
+}
+
+// RejectedRequests 返回因排队请求数达到MaxQueuedRequests而被拒绝的请求数
+func (server *Server) RejectedRequests() uint64 {
+	return atomic.LoadUint64(&server.rejected)
 }
 
 // Accept 默认的Accept
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
+// ServeCodec 在已建立的Codec上运行服务器，用于不经过Dial的传输，例如SSH通道或net.Pipe
+// 不进行Option交换，因此没有版本和Codec协商、客户端标识及握手认证，也没有ConnInfo，不调用OnConnect和OnDisconnect
+// 客户端须用NewClientWithCodec和相同的Codec
+// 每一帧为请求头及其请求体，ServeCodec阻塞直到cc读取失败，返回前关闭cc
+func (server *Server) ServeCodec(cc codec.Codec) {
+	server.startTime()
+	atomic.AddInt64(&server.activeConns, 1)
+	defer atomic.AddInt64(&server.activeConns, -1)
+	server.serveCodec(context.Background(), cc, &Option{})
+}
+
 // ServeConn 在单个连接上运行服务器
 // ServeConn 阻塞，为连接提供服务，直到客户端挂起
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
+	server.startTime()
+	atomic.AddInt64(&server.activeConns, 1)
+	defer atomic.AddInt64(&server.activeConns, -1)
 	//结束后关闭连接
 	defer func() { _ = conn.Close() }()
+	ctx, err := connContext(conn, server.tlsHandshakeTimeout())
+	if err != nil {
+		server.logger().Errorf("rpc server: tls handshake error: %v", err)
+		return
+	}
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	dec := json.NewDecoder(conn)
+	setReadDeadline(conn, time.Now().Add(server.handshakeTimeout()))
+	if err := dec.Decode(&opt); err != nil {
+		if isTimeout(err) {
+			server.reap("rpc server: close connection from %q: no options received", remoteAddr(ctx))
+		} else {
+			server.logger().Errorf("rpc server: options error: %v", err)
+		}
+		return
+	}
+	setReadDeadline(conn, time.Time{})
+	if opt.MagicNumber != server.magicNumber() {
+		server.logger().Errorf("rpc server: invalid magic number %x", opt.MagicNumber)
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+	if err := checkVersion(opt.Version); err != nil {
+		server.logger().Errorf("rpc server: %v", err)
 		return
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	opt.ClientID = sanitizeClientID(opt.ClientID)
+	ctx = context.WithValue(ctx, clientIDKey{}, opt.ClientID)
+	err = server.validateOption(&opt)
+	var t codec.Type
+	if err == nil {
+		t, err = negotiateCodec(&opt)
+	}
+	if err == nil {
+		var authErr error
+		if ctx, authErr = server.authorize(ctx, conn, opt.AuthToken); authErr == nil {
+			ctx = withConnInfo(ctx, conn)
+			authErr = server.authenticate(ctx, opt.AuthToken)
+		}
+		if authErr != nil {
+			server.logger().Infof("rpc server: client %q unauthenticated: %v", opt.ClientID, authErr)
+			err = ErrUnauthenticated
+		}
+	}
+	if err == nil {
+		err = server.connect(ctx)
+	}
+	if err = replyHandshake(conn, &opt, t, err); err != nil {
+		server.logger().Errorf("rpc server: handshake error with client %q: %v", opt.ClientID, err)
+		return
+	}
+	opt.CodecType = t
+	err = server.serveCodec(ctx, codec.Lookup(t)(newHandshakeConn(conn, dec)), &opt)
+	server.disconnect(ctx, err)
+}
+
+// ServeConnNoHandshake 在单个连接上运行服务器，不读取Option，直接以gob编解码，客户端须设置Option.SkipHandshake
+// 与ServeCodec一样没有版本和Codec协商、客户端标识及握手认证，仅用于两端事先约定的可信传输
+func (server *Server) ServeConnNoHandshake(conn io.ReadWriteCloser) {
+	server.startTime()
+	atomic.AddInt64(&server.activeConns, 1)
+	defer atomic.AddInt64(&server.activeConns, -1)
+	ctx, err := connContext(conn, server.tlsHandshakeTimeout())
+	if err != nil {
+		server.logger().Errorf("rpc server: tls handshake error: %v", err)
+		_ = conn.Close()
 		return
 	}
-	server.serveCodec(f(conn),&opt)
+	ctx = withConnInfo(ctx, conn)
+	if err = server.connect(ctx); err != nil {
+		server.logger().Infof("rpc server: %v", err)
+		_ = conn.Close()
+		return
+	}
+	err = server.serveCodec(ctx, codec.NewGobCodec(conn), &Option{CodecType: codec.GobType, SkipHandshake: true})
+	server.disconnect(ctx, err)
 }
 
 //serveCodec 主要包含三个过程
 //读取请求 readRequest
 //处理请求 handleRequest
 //回复请求 sendResponse
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
-	//加锁确保发送一个完整请求
-	sending := new(sync.Mutex)
+//返回结束读取的错误，客户端正常关闭连接时为io.EOF
+func (server *Server) serveCodec(ctx context.Context, cc codec.Codec, opt *Option) error {
+	peer := newPeer(cc)
+	//加锁确保发送一个完整请求，回调请求与响应共用同一把锁
+	sending := &peer.client.sending
 	//一直等待所有请求被处理
 	wg := new(sync.WaitGroup)
+	//连接断开时取消所有请求的上下文
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, peerKey{}, peer))
+	defer cancel()
+	calls := server.clientCounter(opt.ClientID)
+	//连接上进行中的双向流，序号 -> *Stream
+	streams := new(sync.Map)
+	sched := server.scheduler()
+	if opt.Ordered {
+		//连接独享的单个工作协程，请求按到达顺序处理
+		sched = &scheduler{max: 1}
+	} else if sched == nil && opt.HandlerPoolSize > 0 {
+		//连接独享的工作协程池
+		sched = &scheduler{max: opt.HandlerPoolSize, aging: defaultPriorityAging}
+	}
+	if server.onPeer != nil {
+		server.onPeer(peer)
+	}
+	limiter := server.rateLimiter()
+	idle := server.idleTimer(cc)
+	dispatch := func(req *request) {
+		wg.Add(1)
+		handle := func() {
+			server.handleRequest(cc, req, sending, wg, server.handleTimeout(req, opt.HandleTimeout))
+			idle.end()
+		}
+		priority := req.h.Priority
+		if opt.Ordered {
+			priority = PriorityNormal
+		}
+		if sched == nil {
+			go handle()
+		} else if !sched.submit(priority, handle) {
+			wg.Done()
+			idle.end()
+			req.stream.close()
+			atomic.AddUint64(&server.rejected, 1)
+			setError(req.h, ErrServerBusy)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
+		}
+	}
 
+	var err error
 	for {
-		req, err := server.readRequest(cc)
-		if err != nil {
-			//由于没有回复，所以关闭连接
-			if req == nil {
+		var h *codec.Header
+		idle.arm()
+		if h, err = server.readRequestHeader(cc); err != nil {
+			if isTimeout(err) {
+				server.reap("rpc server: close idle connection of client %q", opt.ClientID)
+			}
+			break
+		}
+		//回调的响应交给Peer处理
+		if h.Callback {
+			if err = peer.client.handleResponse(h); err != nil {
 				break
 			}
-			req.h.Error = err.Error()
+			continue
+		}
+		if h.Stream {
+			// 客户端在双向流上的消息交给流，已结束的流的消息丢弃，带ServiceMethod的帧建立新的流
+			if s, ok := streams.Load(h.Seq); ok && h.ServiceMethod == "" {
+				if err = s.(*Stream).deliver(h); err != nil {
+					break
+				}
+				continue
+			}
+			if h.ServiceMethod == "" {
+				if err = cc.ReadBody(nil); err != nil {
+					break
+				}
+				continue
+			}
+		}
+		opening := h.Stream
+		h.Stream = false // 响应帧是普通帧
+		// 追踪ID来自客户端，与客户端标识一样清理后才写入日志
+		h.TraceID = sanitizeClientID(h.TraceID)
+		req, reqErr := server.readRequest(cc, h, opening)
+		if reqErr != nil {
+			server.logger().Errorf("rpc server: bad request %s (trace %s) from client %q: %v", h.ServiceMethod, h.TraceID, opt.ClientID, reqErr)
+			setError(req.h, reqErr)
 			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
 			continue
 		}
-		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		if h.Token != "" {
+			if authErr := server.authenticate(ctx, h.Token); authErr != nil {
+				setError(req.h, &Error{Code: CodeUnauthenticated, Message: ErrUnauthenticated.Error() + ": " + authErr.Error()})
+				server.sendResponse(cc, req.h, invalidRequest, sending)
+				server.freeRequest(req)
+				continue
+			}
+		}
+		if h.Oneway && req.mtype.stream {
+			server.logger().Infof("rpc server: drop one-way request to stream method %s (trace %s)", h.ServiceMethod, h.TraceID)
+			server.freeRequest(req)
+			continue
+		}
+		req.ctx = ctx
+		if h.TraceID != "" {
+			req.ctx = WithTraceID(ctx, h.TraceID)
+		}
+		if req.mtype.bidi {
+			var abort context.CancelFunc
+			req.ctx, abort = context.WithCancel(req.ctx)
+			seq := h.Seq
+			req.stream = newBidiStream(server, cc, h, sending, ctx, abort)
+			req.stream.detach = func() { streams.Delete(seq) }
+			streams.Store(seq, req.stream)
+		}
+		atomic.AddUint64(calls, 1)
+		idle.begin()
+		if limiter == nil {
+			dispatch(req)
+			continue
+		}
+		wait, ok := limiter.reserve(time.Now(), server.rateLimitWait())
+		switch {
+		case !ok:
+			idle.end()
+			req.stream.close()
+			setError(req.h, ErrRateLimited)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
+		case wait == 0:
+			dispatch(req)
+		case opt.Ordered:
+			// 有序的连接在读取协程中等待令牌，之后的请求不会越过它
+			time.Sleep(wait)
+			limiter.done()
+			dispatch(req)
+		default:
+			// 在单独的协程中等待令牌，读取协程继续读取，超限的请求可以立即得到响应
+			wg.Add(1)
+			go func(req *request) {
+				defer wg.Done()
+				defer limiter.done()
+				t := time.NewTimer(wait)
+				defer t.Stop()
+				select {
+				case <-t.C:
+					dispatch(req)
+				case <-ctx.Done():
+					idle.end()
+					req.stream.close()
+				}
+			}(req)
+		}
 	}
+	//连接断开，结束所有等待中的回调，避免处理协程阻塞
+	peer.client.terminateCalls(err)
+	cancel()
 	wg.Wait()
 	_ = cc.Close()
+	return err
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
-	var h codec.Header
-	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+	h := newHeader()
+	if err := cc.ReadHeader(h); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !isTimeout(err) {
+			server.logger().Errorf("rpc server: read header error: %v", err)
 		}
 		return nil, err
 	}
-	return &h, nil
+	return h, nil
 }
 
-// readRequest 通过newArgv()和newReplyv()两个方法创建出两个入参实例
-// 通过cc.ReadBody()将请求报文反序列化为第一个入参argv
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
-	h, err := server.readRequestHeader(cc)
-	if err != nil {
-		return nil, err
-	}
-	req := &request{h: h}
+// readRequest 通过newArgv()和newReplyv()两个方法创建出两个入参实例，返回回复的方法没有回复入参
+// 通过cc.ReadBody()将请求报文反序列化为第一个入参argv，opening为true时是建立双向流的请求，没有参数
+func (server *Server) readRequest(cc codec.Codec, h *codec.Header, opening bool) (*request, error) {
+	var err error
+	req := newRequest(h)
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
+	if err == nil && opening != req.mtype.bidi {
+		if opening {
+			err = withCode(CodeInvalidArgument, errors.New("rpc server: "+h.ServiceMethod+" is not a bidirectional stream method"))
+		} else {
+			err = withCode(CodeInvalidArgument, errors.New("rpc server: bidirectional stream method "+h.ServiceMethod+" must be opened with NewStream"))
+		}
+	}
 	if err != nil {
+		// 丢弃请求体，连接上之后的请求仍能正确读取
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
+	if opening {
+		if err = cc.ReadBody(nil); err != nil {
+			return req, withCode(CodeInvalidArgument, err)
+		}
+		req.start = time.Now()
+		return req, nil
+	}
 	req.argv = req.mtype.newArgv()
 	req.replyv = req.mtype.newReplyv()
 	//确保argvi是一个指针，ReadBody需要指针作为参数
@@ -158,18 +664,37 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
-		return req, err
+		server.logger().Errorf("rpc server: read body error: %v", err)
+		return req, withCode(CodeInvalidArgument, err)
 	}
+	req.start = time.Now()
 	return req, nil
 }
 
-func (server Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
+// sendResponse 发送响应，返回写入连接的字节数，Codec不支持统计时为0
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) int64 {
+	// 单向请求即使出错也不发送响应
+	if h.Oneway {
+		return 0
+	}
 	sending.Lock()
 	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+	sc, _ := cc.(codec.SizeCodec)
+	var before int64
+	if sc != nil {
+		before = sc.Written()
 	}
+	err := cc.Write(h, body)
+	if err == nil {
+		err = cc.Flush()
+	}
+	if err != nil {
+		server.logger().Errorf("rpc server: write response error: %v", err)
+	}
+	if sc == nil {
+		return 0
+	}
+	return sc.Written() - before
 }
 
 // handleRequest 通过req.svc.call完成方法调用，将replyv传递给sendResponse完成序列化即可
@@ -177,74 +702,401 @@ func (server Server) sendResponse(cc codec.Codec, h *codec.Header, body interfac
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	//响应registered rpc方法来获得正确replyv
 	defer wg.Done()
+	// 处理协程结束后才能复用请求，超时后处理协程可能仍在访问它
+	finished := false
+	defer func() {
+		if finished {
+			server.freeRequest(req)
+		}
+	}()
+	// 超出方法限制的请求不被处理，立即响应
+	limit := server.methodLimit(req.h.ServiceMethod)
+	if !limit.acquire(time.Now()) {
+		req.stream.close()
+		setError(req.h, ErrResourceExhausted)
+		server.sendResponse(cc, req.h, invalidRequest, sending)
+		finished = true
+		return
+	}
+	defer server.observe(req, time.Now())
+	defer server.logAccess(req)
+	// 追踪ID由客户端生成并随响应原样返回，两端的日志据此关联
+	server.logger().Debugf("rpc server: handle method=%s seq=%d trace=%s", req.h.ServiceMethod, req.h.Seq, req.h.TraceID)
+	ctx, cancel := req.ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.ctx, timeout)
+	}
+	defer cancel()
+	if req.h.Timeout > 0 {
+		// 客户端ctx的截止时间，与处理时限先到者为准，到期后只取消ctx，响应由客户端放弃
+		var cancelCall context.CancelFunc
+		ctx, cancelCall = context.WithTimeout(ctx, time.Duration(req.h.Timeout))
+		defer cancelCall()
+	}
+	stream := req.stream
+	if req.mtype.stream && stream == nil {
+		stream = newStream(server, cc, req.h, sending)
+	}
+	if req.mtype.bidi {
+		req.argv = reflect.ValueOf(stream)
+	} else if stream != nil {
+		req.replyv = reflect.ValueOf(stream)
+	}
+	// 超时后不再接收called，处理协程经timedOut得知超时并退出，不再发送响应
 	called := make(chan struct{})
-	sent := make(chan struct{})
+	sent := make(chan struct{}, 1)
+	timedOut := make(chan struct{})
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
+		err := server.recoverCall(req.h, func() (err error) {
+			if err = server.validate(req); err != nil {
+				return err
+			}
+			if req.h.Oneway {
+				atomic.AddUint64(&req.mtype.numNotifies, 1)
+				req.replyv, err = req.svc.invoke(ctx, req.mtype, req.argv, req.replyv)
+				return err
+			}
+			req.replyv, err = req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+			return err
+		})
+		// 超时后方法可能仍在执行，结束后才释放并发数
+		limit.release()
+		select {
+		case called <- struct{}{}:
+		case <-timedOut:
+			return
+		}
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.logger().Errorf("rpc server: %s (trace %s) failed: %v", req.h.ServiceMethod, req.h.TraceID, err)
+			setError(req.h, err)
+			stream.close()
+			req.size = server.sendResponse(cc, req.h, invalidRequest, sending)
 			sent <- struct{}{}
 			return
 		}
 		// called信道接收到消息，代表处理没有超时，继续执行sendResponse
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		// 流式方法的消息已由Stream.Send发出，最后以不带消息的响应帧结束流
+		if stream != nil {
+			stream.close()
+			req.size = server.sendResponse(cc, req.h, invalidRequest, sending)
+		} else {
+			req.size = server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		}
 		sent <- struct{}{}
 	}()
 	if timeout == 0 {
 		<-called
 		<-sent
+		finished = true
 		return
 	}
 	select {
-	case <-time.After(timeout): // time.After()先于called接收到信息，说明处理超市，called和sent都将被阻塞
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+	case <-time.After(timeout): // time.After()先于called接收到信息，说明处理超时，取消方法的ctx并通知处理协程退出
+		cancel()
+		close(timedOut)
+		server.logger().Errorf("rpc server: %s (trace %s) timed out", req.h.ServiceMethod, req.h.TraceID)
+		setError(req.h, &Error{Code: CodeDeadlineExceeded, Message: fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)})
+		stream.close()
+		req.size = server.sendResponse(cc, req.h, invalidRequest, sending)
 	case <-called:
 		<-sent
+		finished = true
+	}
+}
+
+// maxPanicStack 设置了PanicStack时错误中附带的调用栈的最大字节数
+const maxPanicStack = 4096
+
+// recoverCall 执行call并将其中的panic转换为错误，使panic的方法不影响连接上的其他请求
+func (server *Server) recoverCall(h *codec.Header, call func() error) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		atomic.AddUint64(&server.panics, 1)
+		stack := make([]byte, maxPanicStack)
+		stack = stack[:runtime.Stack(stack, false)]
+		server.logger().Errorf("rpc server: panic in %s (trace %s): %v\n%s", h.ServiceMethod, h.TraceID, r, stack)
+		err = fmt.Errorf("rpc: panic in %s: %v", h.ServiceMethod, r)
+		if server.PanicStack {
+			err = fmt.Errorf("%w\n%s", err, stack)
+		}
+	}()
+	return call()
+}
+
+// RecoveredPanics 返回服务方法panic后被恢复的次数
+func (server *Server) RecoveredPanics() uint64 {
+	return atomic.LoadUint64(&server.panics)
+}
+
+// Validator 参数实现了Validator时，服务端在调用方法之前校验参数
+// 校验失败时方法不会被调用，Validate返回的错误作为响应返回给客户端
+type Validator interface {
+	Validate() error
+}
+
+// SetValidator 设置所有方法共用的参数校验钩子，在参数的Validate之后、调用方法之前调用，f为nil时取消
+// args为解码后的参数，类型与方法的参数类型相同，返回错误时方法不会被调用，也不计入调用次数，
+// 错误以CodeInvalidArgument响应，双向流式方法没有参数，不经过校验，须在开始服务之前设置
+func (server *Server) SetValidator(f func(serviceMethod string, args interface{}) error) {
+	server.validator = f
+}
+
+// validate 依次以参数的Validate和SetValidator设置的钩子校验请求的参数，失败时返回CodeInvalidArgument的错误
+func (server *Server) validate(req *request) error {
+	if req.mtype.bidi {
+		return nil
+	}
+	if err := validateArgs(req.argv); err != nil {
+		return withCode(CodeInvalidArgument, err)
+	}
+	if server.validator != nil {
+		if err := server.validator(req.h.ServiceMethod, req.argv.Interface()); err != nil {
+			return withCode(CodeInvalidArgument, err)
+		}
+	}
+	return nil
+}
+
+// validateArgs 参数或其指针实现了Validator时校验参数
+func validateArgs(argv reflect.Value) error {
+	v, ok := argv.Interface().(Validator)
+	if !ok && argv.CanAddr() {
+		v, ok = argv.Addr().Interface().(Validator)
 	}
+	if !ok {
+		return nil
+	}
+	return v.Validate()
 }
 
-// Register 注册在服务器中发布的方法
+// Register 注册在服务器中发布的方法，rcvr没有任何签名符合的方法时返回错误，列出各方法被跳过的原因
 func (server *Server) Register(rcvr interface{}) error {
 	s := newService(rcvr)
+	if err := server.checkMethods(s); err != nil {
+		return err
+	}
+	if err := server.checkShadowed(s); err != nil {
+		return err
+	}
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
+	atomic.AddUint64(&server.generation, 1)
 	return nil
 }
 
+// checkMethods 服务没有可发布的方法时返回错误，列出签名不符合的方法及原因，
+// 否则以调试级别记录被跳过的方法，便于发现拼错的签名
+func (server *Server) checkMethods(s *service) error {
+	if len(s.method) == 0 {
+		if len(s.skipped) == 0 {
+			return errors.New("rpc: service " + s.name + " has no exported methods")
+		}
+		return errors.New("rpc: service " + s.name + " has no usable methods: " + strings.Join(s.skipped, "; "))
+	}
+	for _, reason := range s.skipped {
+		server.logger().Debugf("rpc server: skip method %s.%s", s.name, reason)
+	}
+	return nil
+}
+
+// checkShadowed 服务的方法与RegisterFunc、RegisterHandler发布的同名函数冲突时返回错误
+// findService先查找函数，同名的方法将永远无法被调用，因此两者不能同时注册
+func (server *Server) checkShadowed(s *service) error {
+	for name := range s.method {
+		if _, ok := server.funcMap.Load(s.name + "." + name); ok {
+			return errors.New("rpc: method " + s.name + "." + name + " already defined as a function")
+		}
+	}
+	return nil
+}
+
+// shadowsMethod 函数名name与已注册服务的方法同名时返回true，见checkShadowed
+func (server *Server) shadowsMethod(name string) bool {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return false
+	}
+	svci, ok := server.serviceMap.Load(name[:dot])
+	return ok && svci.(*service).method[name[dot+1:]] != nil
+}
+
 // Register 在默认服务端注册发布接受者的方法
 func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+// RegisterName 以name代替类型名发布rcvr的方法，同一类型的多个实例可以用不同的名字发布
+// name不能为空或包含'.'，已被占用时返回错误
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" || strings.Contains(name, ".") {
+		return errors.New("rpc: invalid service name: " + name)
+	}
+	s := newNamedService(name, rcvr)
+	if err := server.checkMethods(s); err != nil {
+		return err
+	}
+	if err := server.checkShadowed(s); err != nil {
+		return err
+	}
+	if _, dup := server.serviceMap.LoadOrStore(name, s); dup {
+		return errors.New("rpc: service already defined: " + name)
+	}
+	atomic.AddUint64(&server.generation, 1)
+	return nil
+}
+
+// RegisterName 在默认服务端以name发布接受者的方法
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
+// RegisterFunc 将函数fn发布为名为name的调用，客户端以name作为ServiceMethod调用，不按'.'拆分
+// fn的签名规则与Register的方法相同，例如func(Args, *Reply) error，可以是闭包
+// name与已注册服务的方法同名时返回错误，之后注册的服务也不能包含与函数同名的方法
+func (server *Server) RegisterFunc(name string, fn interface{}) error {
+	if name == "" {
+		return errors.New("rpc: function name is empty")
+	}
+	s, err := newFuncService(name, fn)
+	if err != nil {
+		return err
+	}
+	if server.shadowsMethod(name) {
+		return errors.New("rpc: function " + name + " already defined as a method")
+	}
+	if _, dup := server.funcMap.LoadOrStore(name, s); dup {
+		return errors.New("rpc: function already defined: " + name)
+	}
+	atomic.AddUint64(&server.generation, 1)
+	return nil
+}
+
+// RegisterHandler 将handler发布为名为name的调用，与RegisterFunc一样以name作为ServiceMethod，不按'.'拆分
+// 请求体解码到newArgs返回的指针中后直接交给handler，handler返回的回复作为响应，调用路径上不使用反射调用，
+// 用于热点调用，可以与反射注册的服务共存。newArgs每次须返回新的非nil指针，handler返回的回复不能为nil
+func (server *Server) RegisterHandler(name string, newArgs func() interface{}, handler func(args interface{}) (reply interface{}, err error)) error {
+	if name == "" {
+		return errors.New("rpc: handler name is empty")
+	}
+	s, err := newHandlerService(name, newArgs, handler)
+	if err != nil {
+		return err
+	}
+	if server.shadowsMethod(name) {
+		return errors.New("rpc: function " + name + " already defined as a method")
+	}
+	if _, dup := server.funcMap.LoadOrStore(name, s); dup {
+		return errors.New("rpc: function already defined: " + name)
+	}
+	atomic.AddUint64(&server.generation, 1)
+	return nil
+}
+
+// Unregister 注销名为name的服务或由RegisterFunc、RegisterHandler发布的函数，之后的请求返回找不到服务的错误
+// 已经找到该服务的请求不受影响，正常完成
+func (server *Server) Unregister(name string) error {
+	if _, ok := server.serviceMap.LoadAndDelete(name); ok {
+		atomic.AddUint64(&server.generation, 1)
+		return nil
+	}
+	if _, ok := server.funcMap.LoadAndDelete(name); ok {
+		atomic.AddUint64(&server.generation, 1)
+		return nil
+	}
+	return errors.New("rpc: service not defined: " + name)
+}
+
+// Services 返回已注册的服务名和函数名，按名称排序
+func (server *Server) Services() []string {
+	var names []string
+	collect := func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	}
+	server.serviceMap.Range(collect)
+	server.funcMap.Range(collect)
+	sort.Strings(names)
+	return names
+}
+
+// RegisterFunc 在默认服务端注册发布函数
+func RegisterFunc(name string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(name, fn)
+}
+
+// RegisterHandler 在默认服务端发布不经过反射调用的handler
+func RegisterHandler(name string, newArgs func() interface{}, handler func(args interface{}) (reply interface{}, err error)) error {
+	return DefaultServer.RegisterHandler(name, newArgs, handler)
+}
+
+// RegisterType 向gob注册v的具体类型，参数、回复或其字段为接口类型时，其中的具体类型须先注册才能编解码
+// gob的注册在进程内全局生效，客户端一侧同样需要注册，可以直接调用包级的RegisterType
+// v为nil或与已注册的同名类型冲突时返回错误
+func (server *Server) RegisterType(v interface{}) (err error) {
+	if v == nil {
+		return errors.New("rpc: can't register the type of nil")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rpc: can't register type %T: %v", v, r)
+		}
+	}()
+	gob.Register(v)
+	return nil
+}
+
+// RegisterType 向gob注册v的具体类型，见Server.RegisterType
+func RegisterType(v interface{}) error {
+	return DefaultServer.RegisterType(v)
+}
+
 
 // findService
 // 因为ServiceMethod是由Service和Method构成的
 // 首先在serviceMap中找到对应的service实例
 //再从service实例的method中，找到对应的methodType
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	if svci, ok := server.funcMap.Load(serviceMethod); ok {
+		svc = svci.(*service)
+		return svc, svc.method[serviceMethod], nil
+	}
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = withCode(CodeInvalidArgument, errors.New("rpc server: service/method request ill-formed: "+serviceMethod))
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service" + serviceName)
+		svci, ok = server.builtinService(serviceName)
+	}
+	if !ok {
+		err = withCode(CodeNotFound, errors.New("rpc server: can't find service"+serviceName))
 		return
 	}
 	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = withCode(CodeNotFound, errors.New("rpc server: can't find method "+methodName))
 	}
 	return
 }
 
+// builtinService 返回内置的服务，自省服务在DisableReflection为true时不可用
+func (server *Server) builtinService(name string) (*service, bool) {
+	switch {
+	case name == reflectionService && !server.DisableReflection:
+		return server.reflection(), true
+	case name == statsService:
+		return server.stats(), true
+	}
+	return nil, false
+}
+
 // ServeHTTP 继承一个 httpDebug.Handler 作为RPC请求
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request)  {
 	if req.Method != "CONNECT" {
@@ -255,21 +1107,30 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request)  {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ",err.Error())
+		server.logger().Errorf("rpc server: hijacking %s: %v", req.RemoteAddr, err)
 		return
 	}
 	_, _ = io.WriteString(conn,"HTTP/1.0 "+connected+"\n\n")
 	server.ServeConn(conn)
 }
 
-// HandleHTTP 为rpcPath上的RPC消息注册HTTP处理程序,仍然需要调用http.Serve()
-func (server *Server) HandleHTTP() {
-	http.Handle(defaultRPCPath, server)
-	http.Handle(defaultDebugPath, debugHTTP{server})
-	log.Println("rpc server debug path:", defaultDebugPath)
+// HandleHTTP 在http.DefaultServeMux上为rpcPath上的RPC消息注册HTTP处理程序,仍然需要调用http.Serve()
+// 客户端以CONNECT请求rpcPath后按普通连接握手，见DialHTTPPath，rpcPath为空时为默认路径
+// 调试页面注册在默认路径的/debug/goRPC，其他路径的rpcPath/debug，同一路径只能注册一次
+func (server *Server) HandleHTTP(rpcPath string) {
+	debugPath := defaultDebugPath
+	if rpcPath == "" {
+		rpcPath = defaultRPCPath
+	}
+	if rpcPath != defaultRPCPath {
+		debugPath = strings.TrimSuffix(rpcPath, "/") + "/debug"
+	}
+	http.Handle(rpcPath, server)
+	http.Handle(debugPath, http.HandlerFunc(server.DebugHTTP))
+	server.logger().Infof("rpc server: rpc path: %s, debug path: %s", rpcPath, debugPath)
 }
 
-// HandleHTTP 默认服务器注册 HTTP 处理程序的一种便捷方法
+// HandleHTTP 默认服务器在默认路径注册 HTTP 处理程序的一种便捷方法
 func HandleHTTP() {
-	DefaultServer.HandleHTTP()
+	DefaultServer.HandleHTTP(defaultRPCPath)
 }
\ No newline at end of file
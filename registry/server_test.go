@@ -0,0 +1,490 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goRPC/registry/regi"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeConn_UnsupportedVersion(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	conn, _ := net.Dial("tcp", l.Addr().String())
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, Version: ProtocolVersion + 1, CodecType: DefaultOption.CodecType})
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	_assert(err != nil && !strings.Contains(err.Error(), "timeout"), "expect the server to close the connection, got %v", err)
+
+	_, err = Dial("tcp", l.Addr().String(), &Option{Version: ProtocolVersion + 1})
+	_assert(err != nil && strings.Contains(err.Error(), "unsupported protocol version"), "expect a version error, got %v", err)
+}
+
+func TestServer_MagicNumber(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServerWithMagic(0x5eed)
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	opt := &Option{MagicNumber: 0x5eed, ConnectTimeout: time.Second}
+
+	client, err := Dial("tcp", l.Addr().String(), opt)
+	_assert(err == nil, "failed to dial with the same magic number: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(opt.MagicNumber == 0x5eed && client.opt.MagicNumber == 0x5eed, "expect the caller's magic number to be kept")
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	other, err := DialWith("tcp", l.Addr().String(), WithMagicNumber(0x5eed))
+	_assert(err == nil, "failed to dial with WithMagicNumber: %v", err)
+	_ = other.Close()
+
+	// clients of another mesh are dropped after the options
+	_, err = Dial("tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "expect the default magic number to be rejected")
+	_, err = Dial("tcp", l.Addr().String(), &Option{MagicNumber: 0x5eee, ConnectTimeout: time.Second})
+	_assert(err != nil, "expect another magic number to be rejected")
+	_, err = DialWith("tcp", l.Addr().String(), WithMagicNumber(0))
+	_assert(err != nil && strings.Contains(err.Error(), "zero magic number"), "expect an option error, got %v", err)
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	t.Parallel()
+	for _, reject := range []bool{true, false} {
+		var foo Foo
+		server := &Server{MaxConnections: 2, RejectOverflow: reject}
+		_ = server.Register(&foo)
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+
+		var clients []*Client
+		for i := 0; i < 2; i++ {
+			client, _ := Dial("tcp", l.Addr().String())
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply)
+			_assert(err == nil, "failed to call Foo.Sum: %v", err)
+			clients = append(clients, client)
+		}
+		_assert(server.ActiveConnections() == 2, "expect 2 active connections, got %d", server.ActiveConnections())
+
+		// the handshake reply is only sent once the connection is served
+		type result struct {
+			client *Client
+			err    error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			client, err := Dial("tcp", l.Addr().String())
+			ch <- result{client, err}
+		}()
+		if reject {
+			select {
+			case r := <-ch:
+				_assert(r.err != nil, "expect the third connection to be refused")
+			case <-time.After(time.Second):
+				t.Fatal("expect the third connection to be closed")
+			}
+		} else {
+			select {
+			case <-ch:
+				t.Fatal("expect the third connection to be queued")
+			case <-time.After(time.Millisecond * 200):
+			}
+			_ = clients[0].Close()
+			r := <-ch
+			_assert(r.err == nil, "expect the queued connection to be served, got %v", r.err)
+			var reply int
+			err := r.client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+			_ = r.client.Close()
+		}
+		for _, client := range clients {
+			_ = client.Close()
+		}
+		_ = l.Close()
+	}
+}
+
+func TestServer_AtCapacity(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := &Server{MaxConnections: 3, RejectOverflow: true}
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = l.Close() }()
+
+	var clients []*Client
+	for i := 0; i < 3+5; i++ {
+		client, err := Dial("tcp", l.Addr().String())
+		if i < 3 {
+			_assert(err == nil, "failed to dial: %v", err)
+			clients = append(clients, client)
+			continue
+		}
+		_assert(errors.Is(err, ErrServerAtCapacity), "expect connection %d to be refused, got %v", i, err)
+	}
+	_assert(server.ActiveConnections() == 3, "expect 3 active connections, got %d", server.ActiveConnections())
+	for _, client := range clients {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect the admitted clients to keep working, got %v", err)
+		_ = client.Close()
+	}
+}
+
+// tempError 暂时性的Accept错误，例如EMFILE
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener 第一次Accept返回暂时性错误，之后交给内部的监听者
+type flakyListener struct {
+	net.Listener
+	failed int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.CompareAndSwapInt32(&l.failed, 0, 1) {
+		return nil, tempError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServer_AcceptRetriesTemporaryErrors(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	inner, _ := net.Listen("tcp", ":0")
+	l := &flakyListener{Listener: inner}
+	done := make(chan struct{})
+	go func() {
+		server.Accept(l)
+		close(done)
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+	_assert(err == nil, "expect the server to keep serving after a temporary error, got %v", err)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	_assert(atomic.LoadInt32(&l.failed) == 1, "expect the temporary error to be returned once")
+	_ = client.Close()
+
+	// a closed listener stops the loop
+	_ = l.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect Accept to return once the listener is closed")
+	}
+	_assert(acceptBackoff(0) == minAcceptDelay && acceptBackoff(maxAcceptDelay) == maxAcceptDelay, "unexpected backoff")
+}
+
+func TestServer_AcceptAndRegister(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(regi.New(time.Minute))
+	defer ts.Close()
+	servers := func() string {
+		resp, err := http.Get(ts.URL)
+		_assert(err == nil, "failed to query the registry: %v", err)
+		_ = resp.Body.Close()
+		return resp.Header.Get("X-goRPC-Servers")
+	}
+
+	server := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	done := make(chan struct{})
+	go func() {
+		server.AcceptAndRegister(l, ts.URL, time.Millisecond*10)
+		close(done)
+	}()
+	addr := "tcp@" + l.Addr().String()
+	deadline := time.Now().Add(time.Second)
+	for servers() != addr && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(servers() == addr, "expect %s to be registered, got %q", addr, servers())
+
+	_ = l.Close()
+	<-done
+	_assert(servers() == "", "expect the server to be deregistered, got %q", servers())
+}
+
+type Positive struct{ N int }
+
+func (p *Positive) Validate() error {
+	if p.N < 0 {
+		return NewCodedError("invalid_argument", false, "negative number")
+	}
+	return nil
+}
+
+type Checked struct{ calls int32 }
+
+func (c *Checked) Double(p Positive, reply *int) error {
+	atomic.AddInt32(&c.calls, 1)
+	*reply = p.N * 2
+	return nil
+}
+
+func TestServer_ValidateArgs(t *testing.T) {
+	t.Parallel()
+	var c Checked
+	server := NewServer()
+	_ = server.Register(&c)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Checked.Double", Positive{N: -1}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "negative number") && CodedErrorCode(err) == "invalid_argument", "expect the validation error, got %v", err)
+	_assert(atomic.LoadInt32(&c.calls) == 0, "expect the handler not to run")
+
+	err = client.Call(context.Background(), "Checked.Double", Positive{N: 2}, &reply)
+	_assert(err == nil && reply == 4, "failed to call Checked.Double: %v", err)
+	_assert(atomic.LoadInt32(&c.calls) == 1, "expect the handler to run once")
+}
+
+func TestServer_SetValidator(t *testing.T) {
+	t.Parallel()
+	var c Checked
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&c)
+	_ = server.Register(&foo)
+	var seen []string
+	var mu sync.Mutex
+	server.SetValidator(func(serviceMethod string, args interface{}) error {
+		mu.Lock()
+		seen = append(seen, serviceMethod)
+		mu.Unlock()
+		if a, ok := args.(Args); ok && a.Num1 > 100 {
+			return NewCodedError("too_large", false, "number too large")
+		}
+		return nil
+	})
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	err := client.Call(ctx, "Foo.Sum", Args{Num1: 101, Num2: 1}, &reply)
+	_assert(ErrorCodeOf(err) == CodeInvalidArgument && CodedErrorCode(err) == "too_large", "expect the validator to reject the call, got %v", err)
+	err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the validator to pass the call, got %v", err)
+	_, mtype, _ := server.findService("Foo.Sum")
+	_assert(mtype.NumCalls() == 1, "expect the rejected call not to be counted, got %d", mtype.NumCalls())
+
+	// the argument's own Validate runs first
+	err = client.Call(ctx, "Checked.Double", Positive{N: -1}, &reply)
+	_assert(ErrorCodeOf(err) == CodeInvalidArgument && CodedErrorCode(err) == "invalid_argument", "expect Validate to reject the call, got %v", err)
+	_assert(atomic.LoadInt32(&c.calls) == 0, "expect the handler not to run")
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(seen) == 2 && seen[0] == "Foo.Sum", "expect the validator to see the calls passing Validate, got %v", seen)
+}
+
+type Panicky int
+
+func (p Panicky) Boom(n int, reply *int) error {
+	if n < 0 {
+		panic("negative")
+	}
+	*reply = n
+	return nil
+}
+
+func TestServer_RecoverPanic(t *testing.T) {
+	t.Parallel()
+	var p Panicky
+	server := &Server{PanicStack: true}
+	_ = server.Register(&p)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Panicky.Boom", -1, &reply)
+	_assert(err != nil && strings.HasPrefix(err.Error(), "rpc: panic in Panicky.Boom: negative"), "expect the panic as an error, got %v", err)
+	_assert(strings.Contains(err.Error(), "goroutine"), "expect a stack with PanicStack set, got %v", err)
+	_assert(server.RecoveredPanics() == 1, "expect 1 recovered panic, got %d", server.RecoveredPanics())
+
+	err = client.Call(context.Background(), "Panicky.Boom", 2, &reply)
+	_assert(err == nil && reply == 2, "expect the connection to keep serving, got %v", err)
+}
+
+func TestServer_Unregister(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	_ = server.RegisterFunc("double", func(n int, reply *int) error {
+		*reply = n * 2
+		return nil
+	})
+	_assert(strings.Join(server.Services(), ",") == "Foo,Slow,double", "unexpected services %v", server.Services())
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := DialWith("tcp", l.Addr().String(), WithPlainMethodNames())
+	defer func() { _ = client.Close() }()
+
+	t.Run("unregister then call", func(t *testing.T) {
+		var reply int
+		// a call in flight when its service is unregistered completes normally
+		call := client.Go("Slow.Sleep", 100, &reply, nil)
+		time.Sleep(time.Millisecond * 20)
+		_assert(server.Unregister("Slow") == nil, "failed to unregister Slow")
+		<-call.Done
+		_assert(call.Error == nil && reply == 100, "expect the in-flight call to complete, got %v", call.Error)
+		err := client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect an unknown service, got %v", err)
+
+		_assert(server.Unregister("double") == nil, "failed to unregister double")
+		err = client.Call(context.Background(), "double", 1, &reply)
+		_assert(err != nil && client.IsAvailable(), "expect the unregistered function to be gone, got %v", err)
+		_assert(server.Unregister("Slow") != nil, "expect an error for an unknown service")
+		_assert(strings.Join(server.Services(), ",") == "Foo", "unexpected services %v", server.Services())
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+				_assert(err == nil && reply == i+1 || err != nil && strings.Contains(err.Error(), "can't find service"),
+					"expect success or an unknown service, got %v", err)
+			}(i)
+			if i == 25 {
+				_assert(server.Unregister("Foo") == nil, "failed to unregister Foo")
+			}
+		}
+		wg.Wait()
+		_assert(len(server.Services()) == 0, "expect no service left, got %v", server.Services())
+	})
+}
+
+// replica is unexported, RegisterName doesn't need the type name.
+type replica struct{ role string }
+
+func (r *replica) Role(_ int, reply *string) error {
+	*reply = r.role
+	return nil
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.RegisterName("PrimaryStore", &replica{role: "primary"}) == nil, "failed to register PrimaryStore")
+	_assert(server.RegisterName("ReplicaStore", &replica{role: "replica"}) == nil, "failed to register ReplicaStore")
+	_assert(server.RegisterName("ReplicaStore", &replica{}) != nil, "expect an error for a duplicate name")
+	_assert(server.RegisterName("", &replica{}) != nil, "expect an error for an empty name")
+	_assert(server.RegisterName("Replica.Store", &replica{}) != nil, "expect an error for a name with a dot")
+	var foo Foo
+	_assert(server.RegisterName("Adder", &foo) == nil, "failed to register Adder")
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	for name, want := range map[string]string{"PrimaryStore": "primary", "ReplicaStore": "replica"} {
+		var role string
+		err := client.Call(context.Background(), name+".Role", 0, &role)
+		_assert(err == nil && role == want, "expect %s to answer on its own, got %q %v", name, role, err)
+	}
+	var reply int
+	err := client.Call(context.Background(), "Adder.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Adder.Sum: %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect the type name not to be registered")
+}
+
+type Animal interface{ Sound() string }
+
+type Dog struct{ Name string }
+
+func (d Dog) Sound() string { return d.Name + ": woof" }
+
+type Zoo int
+
+func (z Zoo) Get(name string, reply *Animal) error {
+	*reply = Dog{Name: name}
+	return nil
+}
+
+func TestServer_RegisterType(t *testing.T) {
+	t.Parallel()
+	var z Zoo
+	server := NewServer()
+	_ = server.Register(&z)
+	_assert(server.RegisterType(Dog{}) == nil, "failed to register Dog")
+	_assert(server.RegisterType(nil) != nil, "expect an error for nil")
+	{
+		// a different type with the same name
+		type Dog struct{ Age int }
+		_assert(RegisterType(Dog{}) != nil, "expect an error for a conflicting type")
+	}
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var a Animal
+	err := client.Call(context.Background(), "Zoo.Get", "rex", &a)
+	_assert(err == nil && a != nil && a.Sound() == "rex: woof", "expect the Dog back, got %v: %v", a, err)
+}
+
+func TestServer_Ordered(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(new(Slow))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	// first returns which of a slow call and a fast call sent after it completes first
+	first := func(client *Client) string {
+		done := make(chan *Call, 2)
+		var slow, sum int
+		client.Go("Slow.Sleep", 200, &slow, done)
+		client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, &sum, done)
+		call := <-done
+		_assert(call.Error == nil, "failed to call %s: %v", call.ServiceMethod, call.Error)
+		<-done
+		return call.ServiceMethod
+	}
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	_assert(first(client) == "Foo.Sum", "expect the fast call to overtake the slow one")
+
+	ordered, _ := DialWith("tcp", l.Addr().String(), WithOrdered())
+	defer func() { _ = ordered.Close() }()
+	_assert(first(ordered) == "Slow.Sleep", "expect responses in request order")
+}
@@ -1,26 +1,53 @@
 package registry
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // methodType 包含了一个方法的完整信息
 type methodType struct {
 	method    reflect.Method // 方法本身
 	ArgType   reflect.Type   // 第一个参数类型
-	ReplyType reflect.Type   // 第二个参数类型
+	ReplyType reflect.Type   // 第二个参数类型，返回回复的方法为第一个返回值类型
 	numCalls  uint64         // 统计方法调用次数
+	withCtx   bool           // 第一个参数是否为context.Context
+	stream    bool           // 第二个参数是否为*Stream，见Stream
+	// numNotifies 统计单向请求次数，不计入numCalls，见Client.Notify
+	numNotifies uint64
+	// returns 方法以第一个返回值作为回复，没有回复入参，见registerMethods
+	returns bool
+	// bidi 双向流式方法，唯一的入参为*Stream，ArgType与ReplyType均为*Stream
+	bidi bool
+	// stats 调用的进行中数、错误数及耗时，见Server的_goRPC.Stats.Get
+	stats callStats
+	// latency 按结果分开的请求耗时直方图，见Server.MetricsHandler
+	latency methodLatency
+	// timeout 接收者的RPCTimeout给出的处理时限，见TimeoutProvider
+	timeout time.Duration
+	// newArgs和handler 由RegisterHandler发布的调用不经过反射，直接以它们创建参数和处理请求
+	newArgs func() interface{}
+	handler func(args interface{}) (reply interface{}, err error)
+	// argPool和replyPool 设置了Server.ReuseValues时复用指向结构体的参数和回复，nil表示不复用
+	// 返回回复的方法返回的值由方法创建，可能仍被方法持有，不复用
+	argPool, replyPool *valuePool
 }
 
 // service
 type service struct {
 	name   string                 // 映射的结构体的名称
 	typ    reflect.Type           // 结构体类型
-	rcvr   reflect.Value          // 结构体实例本身，需要rcvr作为第0个参数
+	rcvr   reflect.Value          // 结构体实例本身，需要rcvr作为第0个参数，函数注册的服务为零值
 	method map[string]*methodType // 存储映射的结构体的所有符合条件的方法
+	// skipped 签名不符合而没有发布的方法及原因，格式为"方法名: 原因"，见Server.Register
+	skipped []string
 }
 
 
@@ -28,8 +55,19 @@ func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// NumNotifies 返回方法收到的单向请求数
+func (m *methodType) NumNotifies() uint64 {
+	return atomic.LoadUint64(&m.numNotifies)
+}
+
 // newArgv 用于创建对应类型的实例，指针和值类型有区别
 func (m *methodType) newArgv() reflect.Value {
+	if m.newArgs != nil {
+		return reflect.ValueOf(m.newArgs())
+	}
+	if argv, ok := m.argPool.get(); ok {
+		return argv
+	}
 	var argv reflect.Value
 	//arg可能是指针或者值类型
 	if m.ArgType.Kind() == reflect.Ptr {
@@ -40,8 +78,29 @@ func (m *methodType) newArgv() reflect.Value {
 	return argv
 }
 
-// newReplyv 用于创建返回实例
+// Signature 返回方法的签名，不含方法名与接收者，调试页面据此区分两种形式
+func (m *methodType) Signature() string {
+	in := m.ArgType.String()
+	if m.withCtx {
+		in = typeOfContext.String() + ", " + in
+	}
+	if m.returns {
+		return fmt.Sprintf("(%s) (%s, error)", in, m.ReplyType)
+	}
+	if m.bidi {
+		return fmt.Sprintf("(%s) error", in)
+	}
+	return fmt.Sprintf("(%s, %s) error", in, m.ReplyType)
+}
+
+// newReplyv 用于创建返回实例，返回回复的方法不需要，返回无效值
 func (m *methodType) newReplyv() reflect.Value {
+	if m.returns {
+		return reflect.Value{}
+	}
+	if replyv, ok := m.replyPool.get(); ok {
+		return replyv
+	}
 	//返回值一定是指针类型
 	replyv := reflect.New(m.ReplyType.Elem())
 	switch m.ReplyType.Elem().Kind() {
@@ -54,54 +113,255 @@ func (m *methodType) newReplyv() reflect.Value {
 }
 
 func newService(rcvr interface{}) *service {
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) {
+		log.Fatalf("rpc server: %s is not a valid service name", name)
+	}
+	return newNamedService(name, rcvr)
+}
+
+// newNamedService 以name发布rcvr的方法，rcvr的类型不必导出
+func newNamedService(name string, rcvr interface{}) *service {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
+	s.name = name
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
-	}
 	s.registerMethods()
 	return s
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfStream  = reflect.TypeOf((*Stream)(nil))
+)
+
 // registerMethods 过滤符合条件的方法
 // 两个导出或内置类型的入参（反射时为3个，第0个是自己，Java中的this）
+// 也可以在两个入参之前接收一个context.Context（反射时为4个）
+// 第二个入参为*Stream时为流式方法，通过Stream.Send返回多条消息
 // 返回值只有一个，类型为error
+// 也可以省去第二个入参，改为返回(回复, error)，如func (t *T) M(args A) (R, error)
+// 两种形式以返回值个数区分，不会混淆
+// 唯一的入参为*Stream（之前也可以有context.Context）时为双向流式方法，见Stream.Recv
+// 参数或回复中含接口类型时，gob编解码前须用RegisterType注册其中的具体类型
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
+	provider, _ := s.rcvr.Interface().(TimeoutProvider)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		mtype, err := newMethodType(method, 1)
+		if err != nil {
+			s.skipped = append(s.skipped, method.Name+": "+err.Error())
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
+		if provider != nil {
+			mtype.timeout = provider.RPCTimeout(method.Name)
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
+		s.method[method.Name] = mtype
+		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+	}
+}
+
+// newMethodType 按registerMethods的规则检查签名，不符合时返回原因
+// first为第一个入参的下标，方法为1（第0个是接收者），函数为0
+func newMethodType(method reflect.Method, first int) (*methodType, error) {
+	mType := method.Type
+	if mType.NumOut() == 2 {
+		return newReturnsMethodType(method, first)
+	}
+	numIn := mType.NumIn() - first
+	if numIn > 0 && mType.In(mType.NumIn()-1) == typeOfStream && (numIn == 1 || numIn == 2 && mType.In(first) == typeOfContext) {
+		if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+			return nil, fmt.Errorf("wrong return %s, expect error", returnTypes(mType))
 		}
-		s.method[method.Name] = &methodType{
+		return &methodType{
 			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+			ArgType:   typeOfStream,
+			ReplyType: typeOfStream,
+			withCtx:   numIn == 2,
+			stream:    true,
+			bidi:      true,
+		}, nil
+	}
+	withCtx := numIn == 3 && mType.In(first) == typeOfContext
+	if numIn != 2 && !withCtx {
+		return nil, fmt.Errorf("wrong arity, takes %d arguments, expect args and reply", numIn)
+	}
+	if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+		return nil, fmt.Errorf("wrong return %s, expect error", returnTypes(mType))
+	}
+	argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	if err := checkExported(argType, replyType); err != nil {
+		return nil, err
+	}
+	return &methodType{
+		method:    method,
+		ArgType:   argType,
+		ReplyType: replyType,
+		withCtx:   withCtx,
+		stream:    replyType == typeOfStream,
+		argPool:   newValuePool(argType),
+		replyPool: newValuePool(replyType),
+	}, nil
+}
+
+// newReturnsMethodType 检查返回(回复, error)的方法，入参为args或ctx, args，不符合时返回原因
+func newReturnsMethodType(method reflect.Method, first int) (*methodType, error) {
+	mType := method.Type
+	numIn := mType.NumIn() - first
+	withCtx := numIn == 2 && mType.In(first) == typeOfContext
+	if numIn != 1 && !withCtx {
+		return nil, fmt.Errorf("wrong arity, takes %d arguments, expect args", numIn)
+	}
+	if mType.Out(1) != typeOfError {
+		return nil, fmt.Errorf("wrong return %s, expect (reply, error)", returnTypes(mType))
+	}
+	argType, replyType := mType.In(mType.NumIn()-1), mType.Out(0)
+	if replyType == typeOfStream {
+		return nil, errors.New("a *Stream can't be returned as the reply")
 	}
+	if err := checkExported(argType, replyType); err != nil {
+		return nil, err
+	}
+	return &methodType{
+		method:    method,
+		ArgType:   argType,
+		ReplyType: replyType,
+		withCtx:   withCtx,
+		returns:   true,
+		argPool:   newValuePool(argType),
+	}, nil
+}
+
+// checkExported 参数或回复的类型未导出时返回原因
+func checkExported(argType, replyType reflect.Type) error {
+	if !isExportedOrBuiltinType(argType) {
+		return fmt.Errorf("argument type %s is not exported", argType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return fmt.Errorf("reply type %s is not exported", replyType)
+	}
+	return nil
+}
+
+// returnTypes 返回方法的返回值类型列表，用于说明签名为何不符合
+func returnTypes(t reflect.Type) string {
+	outs := make([]string, t.NumOut())
+	for i := range outs {
+		outs[i] = t.Out(i).String()
+	}
+	return "(" + strings.Join(outs, ", ") + ")"
+}
+
+// newFuncService 将函数fn包装为只有一个方法的服务，服务名与方法名均为name
+// fn的签名须与方法相同，只是没有接收者，例如func(Args, *Reply) error
+func newFuncService(name string, fn interface{}) (*service, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("rpc server: %s is not a function", name)
+	}
+	mtype, err := newMethodType(reflect.Method{Name: name, Type: v.Type(), Func: v}, 0)
+	if err != nil {
+		return nil, fmt.Errorf("rpc server: function %s has an invalid signature %s: %v", name, v.Type(), err)
+	}
+	log.Printf("rpc server: register func %s\n", name)
+	return &service{name: name, typ: v.Type(), method: map[string]*methodType{name: mtype}}, nil
+}
+
+var typeOfAny = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// newHandlerService 将handler包装为只有一个方法的服务，服务名与方法名均为name
+// newArgs须返回非nil的指针，ReadBody将请求体解码到其中
+func newHandlerService(name string, newArgs func() interface{}, handler func(args interface{}) (interface{}, error)) (*service, error) {
+	if newArgs == nil || handler == nil {
+		return nil, fmt.Errorf("rpc server: handler %s is nil", name)
+	}
+	args := reflect.ValueOf(newArgs())
+	if args.Kind() != reflect.Ptr || args.IsNil() {
+		return nil, fmt.Errorf("rpc server: handler %s: newArgs must return a non-nil pointer, got %s", name, args.Kind())
+	}
+	mtype := &methodType{
+		method:    reflect.Method{Name: name},
+		ArgType:   args.Type(),
+		ReplyType: typeOfAny,
+		returns:   true,
+		newArgs:   newArgs,
+		handler:   handler,
+	}
+	log.Printf("rpc server: register handler %s\n", name)
+	return &service{name: name, typ: reflect.TypeOf(handler), method: map[string]*methodType{name: mtype}}, nil
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, reply reflect.Value) error {
+func (s *service) call(m *methodType, argv, reply reflect.Value) (reflect.Value, error) {
+	return s.callContext(context.Background(), m, argv, reply)
+}
+
+// callContext 调用方法并计入调用次数
+func (s *service) callContext(ctx context.Context, m *methodType, argv, reply reflect.Value) (reflect.Value, error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	done := m.stats.begin()
+	failed := true // 方法panic时仍为true，计为错误
+	defer func() { done(failed) }()
+	reply, err := s.invoke(ctx, m, argv, reply)
+	failed = err != nil
+	return reply, err
+}
+
+// invoke 调用方法，接收context.Context的方法将ctx作为第一个参数
+// 返回要发送的回复，即reply或返回回复的方法的第一个返回值
+func (s *service) invoke(ctx context.Context, m *methodType, argv, reply reflect.Value) (reflect.Value, error) {
+	if m.handler != nil {
+		return m.invokeHandler(argv)
+	}
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, reply})
-	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+	in := []reflect.Value{argv}
+	if !m.returns && !m.bidi {
+		in = append(in, reply)
 	}
-	return nil
+	if m.withCtx {
+		in = append([]reflect.Value{reflect.ValueOf(ctx)}, in...)
+	} else if !m.returns && !m.bidi {
+		// 不接收ctx的方法通过ConnInfoOf(reply)取得连接的信息，defer保证panic时也取消登记
+		defer trackConnInfo(ctx, reply)()
+	}
+	if s.rcvr.IsValid() {
+		in = append([]reflect.Value{s.rcvr}, in...)
+	}
+	returnValues := f.Call(in)
+	errv := returnValues[len(returnValues)-1]
+	if errInter := errv.Interface(); errInter != nil {
+		return reply, errInter.(error)
+	}
+	if !m.returns {
+		return reply, nil
+	}
+	reply = returnValues[0]
+	// nil指针无法编码，回复其指向类型的零值
+	if reply.Kind() == reflect.Ptr && reply.IsNil() {
+		reply = reflect.New(m.ReplyType.Elem())
+	}
+	return reply, nil
+}
+
+// invokeHandler 直接调用RegisterHandler发布的handler，不经过反射调用
+func (m *methodType) invokeHandler(argv reflect.Value) (reflect.Value, error) {
+	r, err := m.handler(argv.Interface())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if r == nil {
+		return reflect.Value{}, fmt.Errorf("rpc server: handler %s returned a nil reply", m.method.Name)
+	}
+	return reflect.ValueOf(r), nil
 }
@@ -1,8 +1,12 @@
 package registry
 import (
+	"context"
 	"fmt"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -43,6 +47,334 @@ func TestMethodType_Call(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	_, err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type hidden struct{ N int }
+
+// Typos 每个方法的签名都有一处错误
+type Typos int
+
+func (t Typos) Arity(args Args) error                      { return nil }
+func (t Typos) Value(args Args, reply int) error           { return nil }
+func (t Typos) HiddenArgs(args hidden, reply *int) error   { return nil }
+func (t Typos) HiddenReply(args Args, reply *hidden) error { return nil }
+func (t Typos) Return(args Args, reply *int) int           { return 0 }
+func (t Typos) ReturnPair(args Args) (int, int)            { return 0, 0 }
+
+// Partly 一个方法可用，另一个被跳过
+type Partly int
+
+func (p Partly) Sum(args Args, reply *int) error  { return Foo(0).Sum(args, reply) }
+func (p Partly) Value(args Args, reply int) error { return nil }
+
+func TestServer_RegisterSkippedMethods(t *testing.T) {
+	t.Parallel()
+	logger := new(captureLogger)
+	server := &Server{Logger: logger}
+	err := server.Register(new(Typos))
+	_assert(err != nil, "expect a service without usable methods to be rejected")
+	for _, reason := range []string{
+		"Arity: wrong arity",
+		"Value: reply type int is not a pointer",
+		"HiddenArgs: argument type registry.hidden is not exported",
+		"HiddenReply: reply type *registry.hidden is not exported",
+		"Return: wrong return (int), expect error",
+		"ReturnPair: wrong return (int, int), expect (reply, error)",
+	} {
+		_assert(strings.Contains(err.Error(), reason), "expect %q in %v", reason, err)
+	}
+	_assert(server.RegisterName("Typos", new(Typos)) != nil, "expect RegisterName to reject it too")
+	_assert(len(server.Services()) == 0, "expect nothing to be registered, got %v", server.Services())
+
+	err = server.Register(new(Partly))
+	_assert(err == nil, "failed to register a service with a usable method: %v", err)
+	lines := logger.find("DEBUG", "Partly.Value: reply type int is not a pointer")
+	_assert(len(lines) == 1, "expect the skipped method to be logged at debug level, got %v", logger.lines)
+}
+
+type Clock struct {
+	canceled chan struct{}
+}
+
+// Now 旧形式的方法
+func (c *Clock) Now(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+// Deadline 接收context.Context的方法
+func (c *Clock) Deadline(ctx context.Context, args int, reply *bool) error {
+	_, ok := ctx.Deadline()
+	*reply = ok && ctx.Err() == nil
+	return nil
+}
+
+// Wait 阻塞直到ctx被取消
+func (c *Clock) Wait(ctx context.Context, args int, reply *int) error {
+	<-ctx.Done()
+	close(c.canceled)
+	return ctx.Err()
+}
+
+func TestNewService_WithContext(t *testing.T) {
+	s := newService(&Clock{})
+	_assert(len(s.method) == 3, "wrong service Method, expect 3, but got %d", len(s.method))
+	_assert(!s.method["Now"].withCtx && s.method["Deadline"].withCtx, "wrong context detection")
+	_assert(s.method["Deadline"].ArgType.Kind() == reflect.Int, "wrong ArgType of Deadline")
+}
+
+func TestServer_ContextMethod(t *testing.T) {
+	t.Parallel()
+	clock := &Clock{canceled: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(clock)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String(), &Option{HandleTimeout: time.Millisecond * 500})
+	defer func() { _ = client.Close() }()
+	var now int
+	err := client.Call(context.Background(), "Clock.Now", 7, &now)
+	_assert(err == nil && now == 7, "failed to call Clock.Now: %v", err)
+	var live bool
+	err = client.Call(context.Background(), "Clock.Deadline", 0, &live)
+	_assert(err == nil && live, "expect a live context with a deadline, got %v", err)
+	err = client.Call(context.Background(), "Clock.Wait", 0, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error, got %v", err)
+	select {
+	case <-clock.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("context of Clock.Wait should be canceled on timeout")
+	}
+}
+
+func TestServer_ContextCancellation(t *testing.T) {
+	t.Parallel()
+	// every subtest dials its own server so each Clock is canceled once
+	newClient := func() (*Client, *Clock) {
+		clock := &Clock{canceled: make(chan struct{})}
+		server := NewServer()
+		_ = server.Register(clock)
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+		client, _ := Dial("tcp", l.Addr().String())
+		return client, clock
+	}
+	canceled := func(clock *Clock) bool {
+		select {
+		case <-clock.canceled:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		client, clock := newClient()
+		defer func() { _ = client.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		var live bool
+		err := client.Call(ctx, "Clock.Deadline", 0, &live)
+		_assert(err == nil && live, "expect the deadline of the caller, got %v", err)
+		err = client.Call(ctx, "Clock.Wait", 0, new(int))
+		_assert(err != nil, "expect the call to time out")
+		_assert(canceled(clock), "context of Clock.Wait should be canceled at the caller's deadline")
+	})
+	t.Run("disconnect", func(t *testing.T) {
+		client, clock := newClient()
+		call := client.Go("Clock.Wait", 0, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+		_ = client.Close()
+		<-call.Done
+		_assert(canceled(clock), "context of Clock.Wait should be canceled when the client disconnects")
+	})
+}
+
+// Doubler 与RegisterFunc发布的Math.Double同名的方法
+type Doubler int
+
+func (d Doubler) Double(n int, reply *int) error {
+	*reply = n * 2
+	return nil
+}
+
+type args struct{}
+
+func TestServer_RegisterFunc(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	offset := 10
+	err := server.RegisterFunc("Add", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2 + offset
+		return nil
+	})
+	_assert(err == nil, "failed to register a closure: %v", err)
+	err = server.RegisterFunc("Math.HasDeadline", func(ctx context.Context, _ int, reply *bool) error {
+		_, *reply = ctx.Deadline()
+		return nil
+	})
+	_assert(err == nil, "failed to register a function taking a context: %v", err)
+	err = server.RegisterFunc("Math.Double", func(n int) (int, error) { return n * 2, nil })
+	_assert(err == nil, "failed to register a function returning its reply: %v", err)
+	_assert(server.RegisterFunc("Add", func(int, *int) error { return nil }) != nil, "expect a duplicate name to be rejected")
+	_assert(server.RegisterFunc("Bad", func(int) error { return nil }) != nil, "expect an invalid signature to be rejected")
+	_assert(server.RegisterFunc("NotFunc", 1) != nil, "expect a non-function to be rejected")
+	_assert(server.RegisterFunc("Unexported", func(int, *args) error { return nil }) != nil, "expect an unexported reply type to be rejected")
+	_assert(server.RegisterFunc("NoError", func(int, *int) int { return 0 }) != nil, "expect a function not returning an error to be rejected")
+	_assert(server.RegisterFunc("", func(int, *int) error { return nil }) != nil, "expect an empty name to be rejected")
+	// a function never shadows a method of the same name, whichever is registered first
+	_assert(server.Register(new(Foo)) == nil, "failed to register Foo")
+	err = server.RegisterFunc("Foo.Sum", func(Args, *int) error { return nil })
+	_assert(err != nil && strings.Contains(err.Error(), "Foo.Sum"), "expect a function shadowing Foo.Sum to be rejected, got %v", err)
+	err = server.RegisterHandler("Foo.Sum", func() interface{} { return new(Args) }, func(interface{}) (interface{}, error) { return 0, nil })
+	_assert(err != nil, "expect a handler shadowing Foo.Sum to be rejected")
+	_assert(server.RegisterFunc("Foo.Product", func(Args, *int) error { return nil }) == nil, "expect a function beside the methods of Foo to be allowed")
+	err = server.RegisterName("Math", new(Doubler))
+	_assert(err != nil && strings.Contains(err.Error(), "Math.Double"), "expect a method shadowed by Math.Double to be rejected, got %v", err)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := DialWith("tcp", l.Addr().String(), WithHandleTimeout(time.Second), WithPlainMethodNames())
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Add", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 13, "failed to call Add: %d, %v", reply, err)
+	var ok bool
+	err = client.Call(context.Background(), "Math.HasDeadline", 0, &ok)
+	_assert(err == nil && ok, "expect the function to get the handle timeout: %v", err)
+	err = client.Call(context.Background(), "Math.Double", 21, &reply)
+	_assert(err == nil && reply == 42, "failed to call Math.Double: %d, %v", reply, err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect Foo.Sum to still call the method: %d, %v", reply, err)
+	svci, _ := server.funcMap.Load("Add")
+	_assert(svci.(*service).method["Add"].NumCalls() == 1, "expect the call to be counted")
+}
+
+func TestServer_RegisterHandler(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	newArgs := func() interface{} { return new(Args) }
+	err := server.RegisterHandler("Direct.Sum", newArgs, func(args interface{}) (interface{}, error) {
+		a := args.(*Args)
+		if a.Num1 < 0 {
+			return nil, fmt.Errorf("negative %d", a.Num1)
+		}
+		return a.Num1 + a.Num2, nil
+	})
+	_assert(err == nil, "failed to register a handler: %v", err)
+	_assert(server.RegisterHandler("Direct.Sum", newArgs, func(interface{}) (interface{}, error) { return 0, nil }) != nil, "expect a duplicate name to be rejected")
+	_assert(server.RegisterHandler("Direct.Value", func() interface{} { return Args{} }, func(interface{}) (interface{}, error) { return 0, nil }) != nil, "expect non-pointer args to be rejected")
+	_assert(server.RegisterHandler("Direct.Nil", newArgs, nil) != nil, "expect a nil handler to be rejected")
+	_ = server.RegisterHandler("Direct.Nothing", newArgs, func(interface{}) (interface{}, error) { return nil, nil })
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Direct.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Direct.Sum: %d, %v", reply, err)
+	err = client.Call(context.Background(), "Direct.Sum", Args{Num1: -1}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "negative -1"), "expect the handler's error, got %v", err)
+	err = client.Call(context.Background(), "Direct.Nothing", Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "nil reply"), "expect a nil reply to fail, got %v", err)
+	// reflective services still work next to it
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "failed to call Foo.Sum: %v", err)
+	_, mtype, _ := server.findService("Direct.Sum")
+	_assert(mtype.NumCalls() == 2, "expect the calls to be counted, got %d", mtype.NumCalls())
+}
+
+type Point struct{ X, Y int }
+
+// Shapes 两种形式的方法并存
+type Shapes struct{}
+
+func (s *Shapes) Double(n int) (int, error) {
+	return n * 2, nil
+}
+
+func (s *Shapes) Origin(_ int) (*Point, error) {
+	return &Point{}, nil
+}
+
+func (s *Shapes) Move(p Point) (*Point, error) {
+	return &Point{X: p.X + 1, Y: p.Y + 1}, nil
+}
+
+func (s *Shapes) Nothing(_ int) (*Point, error) {
+	return nil, nil
+}
+
+func (s *Shapes) Repeat(ctx context.Context, n int) ([]string, error) {
+	return strings.Split(strings.Repeat("a", n), ""), ctx.Err()
+}
+
+func (s *Shapes) Index(words []string) (map[string]int, error) {
+	m := make(map[string]int)
+	for i, w := range words {
+		m[w] = i
+	}
+	return m, nil
+}
+
+func (s *Shapes) Fail(_ int) (int, error) {
+	return 1, fmt.Errorf("shapes: fail")
+}
+
+func (s *Shapes) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestNewService_Returns(t *testing.T) {
+	s := newService(new(Shapes))
+	_assert(len(s.method) == 8, "expect 8 methods, got %d", len(s.method))
+	_assert(s.method["Double"].Signature() == "(int) (int, error)", "unexpected signature %s", s.method["Double"].Signature())
+	_assert(s.method["Repeat"].Signature() == "(context.Context, int) ([]string, error)", "unexpected signature %s", s.method["Repeat"].Signature())
+	_assert(s.method["Sum"].Signature() == "(registry.Args, *int) error", "unexpected signature %s", s.method["Sum"].Signature())
+	_assert(!s.method["Repeat"].newReplyv().IsValid(), "expect no reply argument for a method returning its reply")
+}
+
+func TestServer_ReturnsReply(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Shapes))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var n int
+	err := client.Call(ctx, "Shapes.Double", 21, &n)
+	_assert(err == nil && n == 42, "expect 42, got %d, %v", n, err)
+
+	var p Point
+	err = client.Call(ctx, "Shapes.Move", Point{X: 1, Y: 2}, &p)
+	_assert(err == nil && p == Point{X: 2, Y: 3}, "expect {2 3}, got %v, %v", p, err)
+	var pp *Point
+	err = client.Call(ctx, "Shapes.Origin", 0, &pp)
+	_assert(err == nil && pp != nil && *pp == Point{}, "expect the origin, got %v, %v", pp, err)
+	err = client.Call(ctx, "Shapes.Nothing", 0, &p)
+	_assert(err == nil, "expect a nil pointer to be sent as the zero value, got %v", err)
+
+	var words []string
+	err = client.Call(ctx, "Shapes.Repeat", 3, &words)
+	_assert(err == nil && reflect.DeepEqual(words, []string{"a", "a", "a"}), "expect 3 words, got %v, %v", words, err)
+
+	var index map[string]int
+	err = client.Call(ctx, "Shapes.Index", []string{"x", "y"}, &index)
+	_assert(err == nil && reflect.DeepEqual(index, map[string]int{"x": 0, "y": 1}), "unexpected index %v, %v", index, err)
+
+	err = client.Call(ctx, "Shapes.Fail", 0, &n)
+	_assert(err != nil && strings.Contains(err.Error(), "shapes: fail"), "expect the handler's error, got %v", err)
+
+	err = client.Call(ctx, "Shapes.Sum", Args{Num1: 1, Num2: 2}, &n)
+	_assert(err == nil && n == 3, "expect 3, got %d, %v", n, err)
+}
@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// flight is a request on the wire whose result is shared
+// by every caller that issued an identical call meanwhile.
+type flight struct {
+	call    *Call
+	waiters []*Call
+}
+
+// MarkIdempotent declares serviceMethods as free of side effects, so that
+// concurrent calls to them with deeply equal args share one request,
+// as all calls do with Option.SingleFlight. Errors are reported to every
+// caller and a caller giving up doesn't cancel the shared request.
+func (client *Client) MarkIdempotent(serviceMethods ...string) {
+	for _, serviceMethod := range serviceMethods {
+		client.idempotent.Store(serviceMethod, true)
+	}
+}
+
+// shared reports whether calls to serviceMethod may share a request.
+func (client *Client) shared(serviceMethod string) bool {
+	if client.opt != nil && client.opt.SingleFlight {
+		return true
+	}
+	_, ok := client.idempotent.Load(serviceMethod)
+	return ok
+}
+
+// flightKey identifies identical calls by serviceMethod, reply type and
+// the encoded args. ok is false if args can't be encoded, in which case
+// the call is not shared. Calls discarding their reply only share
+// with each other.
+func flightKey(call *Call) (key string, ok bool) {
+	b, err := json.Marshal(call.Args)
+	if err != nil {
+		return "", false
+	}
+	if discardsReply(call.Reply) {
+		return fmt.Sprintf("%s|-|%s", call.ServiceMethod, b), true
+	}
+	return fmt.Sprintf("%s|%T|%s", call.ServiceMethod, call.Reply, b), true
+}
+
+// goShared joins call to an identical in-flight request, or sends a new
+// one if there is none. The shared reply is copied into each caller's
+// reply once the request completes.
+func (client *Client) goShared(call *Call) {
+	key, ok := flightKey(call)
+	if !ok {
+		client.send(call)
+		return
+	}
+	client.mu.Lock()
+	if f, ok := client.flights[key]; ok {
+		if !reflect.DeepEqual(f.call.Args, call.Args) {
+			// same encoding but not the same args
+			client.mu.Unlock()
+			client.send(call)
+			return
+		}
+		f.waiters = append(f.waiters, call)
+		client.mu.Unlock()
+		// only the request on the wire holds a pending slot
+		client.releaseSlot()
+		return
+	}
+	f := &flight{
+		call: &Call{
+			ServiceMethod: call.ServiceMethod,
+			Args:          call.Args,
+			Reply:         newReply(call.Reply),
+			Done:          make(chan *Call, 1),
+		},
+		waiters: []*Call{call},
+	}
+	if client.flights == nil {
+		client.flights = make(map[string]*flight)
+	}
+	client.flights[key] = f
+	client.mu.Unlock()
+
+	client.send(f.call)
+	go func() {
+		<-f.call.Done
+		client.mu.Lock()
+		delete(client.flights, key)
+		client.mu.Unlock()
+		// waiters can no longer change once the flight is removed
+		for _, w := range f.waiters {
+			w.Error = f.call.Error
+			w.Info.Sent, w.Info.Received = f.call.Info.Sent, f.call.Info.Received
+			if w.Error == nil && !discardsReply(w.Reply) {
+				deepCopy(reflect.ValueOf(w.Reply).Elem(), reflect.ValueOf(f.call.Reply).Elem())
+			}
+			client.complete(w)
+		}
+	}()
+}
+
+// newReply allocates a value of the same type reply points to.
+func newReply(reply interface{}) interface{} {
+	if discardsReply(reply) {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
+
+// deepCopy copies src into dst so that the callers sharing a reply
+// don't share its memory. Unexported struct fields are copied shallowly.
+func deepCopy(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return
+		}
+		if src.Kind() == reflect.Ptr {
+			v := reflect.New(src.Type().Elem())
+			deepCopy(v.Elem(), src.Elem())
+			dst.Set(v)
+			return
+		}
+		v := reflect.New(src.Elem().Type()).Elem()
+		deepCopy(v, src.Elem())
+		dst.Set(v)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return
+		}
+		v := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(v.Index(i), src.Index(i))
+		}
+		dst.Set(v)
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(src.Type()))
+			return
+		}
+		v := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			k := reflect.New(src.Type().Key()).Elem()
+			deepCopy(k, iter.Key())
+			e := reflect.New(src.Type().Elem()).Elem()
+			deepCopy(e, iter.Value())
+			v.SetMapIndex(k, e)
+		}
+		dst.Set(v)
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				deepCopy(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(dst.Index(i), src.Index(i))
+		}
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Store int
+
+func (s Store) Get(key string, reply *string) error {
+	time.Sleep(time.Millisecond * 200)
+	*reply = "value of " + key
+	return nil
+}
+
+func (s Store) List(prefix string, reply *[]string) error {
+	time.Sleep(time.Millisecond * 200)
+	*reply = []string{prefix + "1", prefix + "2"}
+	return nil
+}
+
+func (s Store) Fail(key string, reply *string) error {
+	time.Sleep(time.Millisecond * 200)
+	return errors.New("no such key: " + key)
+}
+
+func numCalls(server *Server, serviceName, methodName string) uint64 {
+	svci, _ := server.serviceMap.Load(serviceName)
+	return svci.(*service).method[methodName].NumCalls()
+}
+
+func TestClient_SingleFlight(t *testing.T) {
+	t.Parallel()
+	var s Store
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{SingleFlight: true})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	replies := make([]string, 50)
+	errs := make([]error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = client.Call(context.Background(), "Store.Get", "foo", &replies[i])
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for i := range replies {
+		_assert(errs[i] == nil && replies[i] == "value of foo", "call %d: reply %q, err %v", i, replies[i], errs[i])
+	}
+	_assert(numCalls(server, "Store", "Get") == 1, "expect 1 call on server, got %d", numCalls(server, "Store", "Get"))
+
+	// different args are not shared
+	var a, b string
+	callA := client.Go("Store.Get", "a", &a, nil)
+	callB := client.Go("Store.Get", "b", &b, nil)
+	<-callA.Done
+	<-callB.Done
+	_assert(a == "value of a" && b == "value of b", "unexpected replies %q %q", a, b)
+	_assert(numCalls(server, "Store", "Get") == 3, "expect 3 calls on server, got %d", numCalls(server, "Store", "Get"))
+}
+
+func TestClient_MarkIdempotent(t *testing.T) {
+	t.Parallel()
+	var s Store
+	server := NewServer()
+	_ = server.Register(&s)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	client.MarkIdempotent("Store.List", "Store.Fail")
+
+	// each caller gets its own copy of the shared reply
+	var replies [3][]string
+	var calls [3]*Call
+	for i := range calls {
+		calls[i] = client.Go("Store.List", "k", &replies[i], nil)
+	}
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "failed to call Store.List: %v", call.Error)
+	}
+	replies[0][0] = "changed"
+	_assert(replies[1][0] == "k1" && replies[2][0] == "k1", "replies share memory: %v", replies)
+	_assert(numCalls(server, "Store", "List") == 1, "expect 1 call on server, got %d", numCalls(server, "Store", "List"))
+
+	// errors are reported to every caller
+	for i := range calls {
+		calls[i] = client.Go("Store.Fail", "k", new(string), nil)
+	}
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error != nil && strings.Contains(call.Error.Error(), "no such key: k"), "expect the shared error, got %v", call.Error)
+	}
+	_assert(numCalls(server, "Store", "Fail") == 1, "expect 1 call on server, got %d", numCalls(server, "Store", "Fail"))
+
+	// a caller giving up doesn't cancel the others
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var a, b string
+	call := client.Go("Store.Fail", "x", &a, nil)
+	err = client.Call(ctx, "Store.Fail", "x", &b)
+	_assert(err != nil && strings.Contains(err.Error(), context.DeadlineExceeded.Error()), "expect a timeout, got %v", err)
+	<-call.Done
+	_assert(call.Error != nil && strings.Contains(call.Error.Error(), "no such key: x"), "expect the shared error, got %v", call.Error)
+
+	// methods not marked are never shared
+	var r1, r2 string
+	c1 := client.Go("Store.Get", "k", &r1, nil)
+	c2 := client.Go("Store.Get", "k", &r2, nil)
+	<-c1.Done
+	<-c2.Done
+	_assert(numCalls(server, "Store", "Get") == 2, "expect 2 calls on server, got %d", numCalls(server, "Store", "Get"))
+}
+
+func TestDeepCopy(t *testing.T) {
+	t.Parallel()
+	type reply struct {
+		Names []string
+		Attrs map[string]*int
+		Next  *reply
+	}
+	n := 1
+	src := reply{Names: []string{"a"}, Attrs: map[string]*int{"n": &n}, Next: &reply{Names: []string{"b"}}}
+	var dst reply
+	deepCopy(reflect.ValueOf(&dst).Elem(), reflect.ValueOf(src))
+	_assert(reflect.DeepEqual(src, dst), "copy differs: %+v", dst)
+	dst.Names[0], *dst.Attrs["n"], dst.Next.Names[0] = "x", 2, "y"
+	_assert(src.Names[0] == "a" && n == 1 && src.Next.Names[0] == "b", "copy shares memory with the source")
+}
@@ -0,0 +1,82 @@
+package registry
+
+// State is the lifecycle state of a Client.
+type State int
+
+const (
+	StateIdle      State = iota // a lazy client that hasn't connected yet
+	StateConnected              // the connection is up
+	StateClosing                // Close was called, the connection is going down
+	StateShutdown               // the client is unusable, see Err
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnected:
+		return "connected"
+	case StateClosing:
+		return "closing"
+	case StateShutdown:
+		return "shutdown"
+	}
+	return "unknown"
+}
+
+// stateChange is a transition waiting to be delivered to the listeners.
+type stateChange struct {
+	old, new State
+}
+
+// State returns the current state of the client.
+func (client *Client) State() State {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.state
+}
+
+// OnStateChange registers fn to be called on every state transition,
+// e.g. Connected → Closing → Shutdown after Close, or Connected →
+// Shutdown when the connection breaks. Transitions are delivered in
+// order, one at a time and never with the client locked, so fn may call
+// back into the client. A state is never reported twice in a row.
+func (client *Client) OnStateChange(fn func(old, new State)) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.stateFns = append(client.stateFns, fn)
+}
+
+// setState records a transition to s; it is delivered by notifyState.
+// client.mu must be held.
+func (client *Client) setState(s State) {
+	if client.state == s || client.state == StateShutdown {
+		return
+	}
+	client.changes = append(client.changes, stateChange{client.state, s})
+	client.state = s
+}
+
+// notifyState delivers the recorded transitions. It must be called
+// without client.mu held, after every setState. Whoever finds
+// transitions to deliver drains them all, so they are never reordered.
+func (client *Client) notifyState() {
+	client.mu.Lock()
+	if client.notifying {
+		client.mu.Unlock()
+		return
+	}
+	client.notifying = true
+	for len(client.changes) > 0 {
+		c := client.changes[0]
+		client.changes = client.changes[1:]
+		fns := client.stateFns
+		client.mu.Unlock()
+		for _, fn := range fns {
+			fn(c.old, c.new)
+		}
+		client.mu.Lock()
+	}
+	client.notifying = false
+	client.mu.Unlock()
+}
@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stateEvents collects the transitions of client, reading its state from
+// the callback to make sure it isn't called with the client locked.
+func stateEvents(client *Client) chan []State {
+	ch := make(chan []State, 10)
+	client.OnStateChange(func(old, new State) {
+		_ = client.State()
+		ch <- []State{old, new}
+	})
+	return ch
+}
+
+func expectStates(t *testing.T, ch chan []State, want ...[]State) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-ch:
+			_assert(reflect.DeepEqual(got, w), "expect transition %v, got %v", w, got)
+		case <-time.After(time.Second):
+			t.Fatalf("expect transition %v", w)
+		}
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected transition %v", got)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestClient_OnStateChange(t *testing.T) {
+	t.Parallel()
+	t.Run("close", func(t *testing.T) {
+		var foo Foo
+		server := NewServer()
+		_ = server.Register(&foo)
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+
+		client, _ := Dial("tcp", l.Addr().String())
+		_assert(client.State() == StateConnected, "expect connected, got %v", client.State())
+		ch := stateEvents(client)
+		_ = client.Close()
+		_ = client.Close()
+		expectStates(t, ch, []State{StateConnected, StateClosing}, []State{StateClosing, StateShutdown})
+		_assert(client.State() == StateShutdown, "expect shutdown, got %v", client.State())
+	})
+	t.Run("server death", func(t *testing.T) {
+		addr := startRawServer(func(conn net.Conn) {
+			time.Sleep(time.Millisecond * 100)
+			_ = conn.Close()
+		})
+		client, _ := Dial("tcp", addr)
+		ch := stateEvents(client)
+		expectStates(t, ch, []State{StateConnected, StateShutdown})
+		_ = client.Close()
+		expectStates(t, ch)
+	})
+	t.Run("lazy", func(t *testing.T) {
+		client := NewLazyClient("tcp", "127.0.0.1:1")
+		_assert(client.State() == StateIdle, "expect idle, got %v", client.State())
+		ch := stateEvents(client)
+		_ = client.Close()
+		expectStates(t, ch, []State{StateIdle, StateClosing}, []State{StateClosing, StateShutdown})
+	})
+}
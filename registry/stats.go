@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// statsService 内置的统计服务名，客户端以_goRPC.Stats.Get取得各方法的调用统计
+const statsService = "_goRPC.Stats"
+
+// MethodStats 单个方法的调用统计，不包括单向请求，耗时只计方法本身的执行
+type MethodStats struct {
+	Calls    uint64 // 调用次数
+	InFlight int64  // 正在执行的调用数
+	Errors   uint64 // 返回错误或panic的调用数
+	Min      time.Duration
+	Avg      time.Duration
+	Max      time.Duration
+	// MaxConcurrent和MaxPerSecond 由Server.SetMethodLimit设置的限制，0表示不设限
+	MaxConcurrent int
+	MaxPerSecond  float64
+	// Limited 超出限制被拒绝的请求数，不计入Calls
+	Limited uint64
+}
+
+// callStats 方法调用的统计，全部使用原子操作，调用路径上没有锁
+type callStats struct {
+	inFlight int64
+	errors   uint64
+	count    uint64 // 已结束的调用数
+	total    int64  // 已结束的调用的总耗时，单位纳秒
+	min, max int64
+}
+
+// begin 开始一次调用，返回结束时调用的函数
+func (s *callStats) begin() func(failed bool) {
+	atomic.AddInt64(&s.inFlight, 1)
+	start := time.Now()
+	return func(failed bool) {
+		s.end(time.Since(start), failed)
+	}
+}
+
+// end 记录一次结束的调用
+func (s *callStats) end(d time.Duration, failed bool) {
+	atomic.AddInt64(&s.inFlight, -1)
+	if failed {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	ns := int64(d)
+	if ns <= 0 {
+		ns = 1
+	}
+	atomic.AddUint64(&s.count, 1)
+	atomic.AddInt64(&s.total, ns)
+	for old := atomic.LoadInt64(&s.min); old == 0 || ns < old; old = atomic.LoadInt64(&s.min) {
+		if atomic.CompareAndSwapInt64(&s.min, old, ns) {
+			break
+		}
+	}
+	for old := atomic.LoadInt64(&s.max); ns > old; old = atomic.LoadInt64(&s.max) {
+		if atomic.CompareAndSwapInt64(&s.max, old, ns) {
+			break
+		}
+	}
+}
+
+// snapshot 返回统计的快照，各计数分别读取，并发调用时彼此之间可能有细微出入
+func (s *callStats) snapshot(calls uint64) MethodStats {
+	st := MethodStats{
+		Calls:    calls,
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Errors:   atomic.LoadUint64(&s.errors),
+		Min:      time.Duration(atomic.LoadInt64(&s.min)),
+		Max:      time.Duration(atomic.LoadInt64(&s.max)),
+	}
+	if n := atomic.LoadUint64(&s.count); n > 0 {
+		st.Avg = time.Duration(atomic.LoadInt64(&s.total) / int64(n))
+	}
+	return st
+}
+
+// statsReceiver 统计服务的接收者
+type statsReceiver struct {
+	server *Server
+}
+
+// Get 返回各方法的调用统计，键为ServiceMethod，不包括内置服务
+func (r *statsReceiver) Get(_ int, reply *map[string]MethodStats) error {
+	stats := make(map[string]MethodStats)
+	r.server.serviceMap.Range(func(namei, svci interface{}) bool {
+		for name, m := range svci.(*service).method {
+			st := m.stats.snapshot(m.NumCalls())
+			r.server.methodLimit(namei.(string) + "." + name).fill(&st)
+			stats[namei.(string)+"."+name] = st
+		}
+		return true
+	})
+	r.server.funcMap.Range(func(namei, svci interface{}) bool {
+		for _, m := range svci.(*service).method {
+			st := m.stats.snapshot(m.NumCalls())
+			r.server.methodLimit(namei.(string)).fill(&st)
+			stats[namei.(string)] = st
+		}
+		return true
+	})
+	*reply = stats
+	return nil
+}
+
+// stats 返回统计服务，第一次调用时创建
+func (server *Server) stats() *service {
+	server.statsOnce.Do(func() {
+		server.statsSvc = newNamedService(statsService, &statsReceiver{server: server})
+	})
+	return server.statsSvc
+}
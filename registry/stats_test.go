@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_Stats(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	var s Slow
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(new(Shapes))
+	_ = server.Register(&s)
+	var p Panicky
+	_ = server.Register(&p)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		_ = client.Call(ctx, "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+	}
+	for i := 0; i < 2; i++ {
+		_ = client.Call(ctx, "Shapes.Fail", 0, &reply)
+	}
+	_ = client.Call(ctx, "Slow.Sleep", 20, &reply)
+	_ = client.Call(ctx, "Panicky.Boom", -1, &reply)
+	blocked := client.Go("Slow.Sleep", 200, new(int), nil)
+	time.Sleep(time.Millisecond * 50)
+
+	var stats map[string]MethodStats
+	err := client.Call(ctx, "_goRPC.Stats.Get", 0, &stats)
+	_assert(err == nil, "failed to get the stats: %v", err)
+	sum := stats["Foo.Sum"]
+	_assert(sum.Calls == 3 && sum.Errors == 0 && sum.InFlight == 0, "unexpected Foo.Sum stats %+v", sum)
+	_assert(sum.Min > 0 && sum.Min <= sum.Avg && sum.Avg <= sum.Max, "unexpected Foo.Sum latency %+v", sum)
+	fail := stats["Shapes.Fail"]
+	_assert(fail.Calls == 2 && fail.Errors == 2, "unexpected Shapes.Fail stats %+v", fail)
+	boom := stats["Panicky.Boom"]
+	_assert(boom.Calls == 1 && boom.Errors == 1 && boom.InFlight == 0, "expect the panic counted as an error, got %+v", boom)
+	sleep := stats["Slow.Sleep"]
+	_assert(sleep.Calls == 2 && sleep.InFlight == 1 && sleep.Max >= time.Millisecond*20, "unexpected Slow.Sleep stats %+v", sleep)
+	_, ok := stats["Shapes.Double"]
+	_assert(ok, "expect methods not called to be listed, got %v", stats)
+	<-blocked.Done
+}
@@ -0,0 +1,376 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"goRPC/client/codec"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// ErrStreamClosed 方法返回或处理超时后再调用Stream.Send或Stream.Recv时返回
+var ErrStreamClosed = errors.New("rpc server: stream closed")
+
+// errNotBidi 服务端流式方法的Stream只能发送
+var errNotBidi = errors.New("rpc server: stream can't receive, the method is not bidirectional")
+
+// streamEOF 流式帧的Error为该值时表示发送方正常结束发送（半关闭），其他非空值表示发送方放弃了流
+const streamEOF = "EOF"
+
+// Stream 流式方法的参数，方法通过Send向客户端发送多条消息
+// 服务端流式方法形如M(args, stream *Stream) error，双向流式方法形如M(stream *Stream) error，还可以通过Recv接收客户端的消息
+//
+// 帧格式：双向流由客户端NewStream发出的Stream标志为true的请求帧建立，之后双方的消息都是带有相同序号、Stream标志为true的帧
+// 客户端的消息帧不带ServiceMethod，Error非空的帧表示客户端结束发送，方法返回后服务端发送一个普通响应帧结束整个流
+// 流量控制：消息由连接的读取协程交给Recv直接解码进调用方的值，每个流同时只有一条消息在途
+// 方法不调用Recv时，该连接上的后续帧都会等待，直到方法调用Recv或返回，因此方法应持续接收直到io.EOF
+type Stream struct {
+	server  *Server
+	cc      codec.Codec
+	sending *sync.Mutex
+	mu      sync.Mutex // 保证close之后不再有Send
+	h       codec.Header
+	closed  bool
+	// 以下字段只用于双向流，见newBidiStream
+	incoming chan struct{} // 读取协程有一条消息等待Recv解码
+	consumed chan struct{} // Recv已读取消息，读取协程继续
+	ended    chan struct{} // 客户端结束了发送，之后Recv返回endErr
+	endErr   error
+	endOnce  sync.Once
+	done     chan struct{}   // 方法已返回，见close
+	connDone <-chan struct{} // 连接已断开
+	abort    context.CancelFunc
+	detach   func() // 从连接的流中移除
+}
+
+func newStream(server *Server, cc codec.Codec, h *codec.Header, sending *sync.Mutex) *Stream {
+	return &Stream{
+		server:  server,
+		cc:      cc,
+		sending: sending,
+		h:       codec.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Stream: true},
+	}
+}
+
+// newBidiStream 创建双向流，ctx为连接的上下文，abort取消方法的ctx
+func newBidiStream(server *Server, cc codec.Codec, h *codec.Header, sending *sync.Mutex, ctx context.Context, abort context.CancelFunc) *Stream {
+	s := newStream(server, cc, h, sending)
+	s.incoming = make(chan struct{})
+	s.consumed = make(chan struct{})
+	s.ended = make(chan struct{})
+	s.done = make(chan struct{})
+	s.connDone = ctx.Done()
+	s.abort = abort
+	return s
+}
+
+// Recv 接收客户端的下一条消息并解码进v，v为nil时丢弃，客户端结束发送后返回io.EOF
+// 客户端放弃流时返回其原因，同时方法的ctx被取消，只有双向流式方法可以调用
+// 同一时刻只应有一个协程调用Recv，可与Send并发
+func (s *Stream) Recv(v interface{}) error {
+	if s.incoming == nil {
+		return errNotBidi
+	}
+	select {
+	case <-s.incoming:
+		err := s.cc.ReadBody(v)
+		s.consumed <- struct{}{}
+		return err
+	case <-s.ended:
+		return s.endErr
+	case <-s.done:
+		return ErrStreamClosed
+	case <-s.connDone:
+		return ErrShutdown
+	}
+}
+
+// deliver 由连接的读取协程调用，将客户端的一帧交给Recv，方法已返回时丢弃
+// 解码失败只影响该次Recv，不中断连接
+func (s *Stream) deliver(h *codec.Header) error {
+	if h.Error != "" {
+		err := s.cc.ReadBody(nil)
+		s.endOnce.Do(func() {
+			s.endErr = io.EOF
+			if h.Error != streamEOF {
+				s.endErr = errors.New("rpc server: stream abandoned by client: " + h.Error)
+				s.abort()
+			}
+			close(s.ended)
+		})
+		return err
+	}
+	select {
+	case s.incoming <- struct{}{}:
+		<-s.consumed
+		return nil
+	case <-s.done:
+		return s.cc.ReadBody(nil)
+	}
+}
+
+// Send 向客户端发送一条消息，可被多个协程并发调用
+func (s *Stream) Send(msg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	h := s.h
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	err := s.cc.Write(&h, msg)
+	if err == nil {
+		err = s.cc.Flush()
+	}
+	return err
+}
+
+// close 结束流，之后的Send和Recv均失败，s为nil时为空操作
+func (s *Stream) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.done != nil {
+		close(s.done)
+		s.detach()
+		s.abort()
+	}
+}
+
+// GoStream 异步调用流式方法，items须为带缓冲的channel，如chan T或chan *T
+// 收到的每条消息解码为新的元素后发送到items，流结束或调用失败后items被关闭，Call.Error为调用的结果
+// 消息按顺序在接收协程中投递，items已满时会阻塞同一连接上的其他响应，调用方应及时接收
+func (client *Client) GoStream(serviceMethod string, args, items interface{}, done chan *Call) *Call {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Chan || v.Type().ChanDir()&reflect.SendDir == 0 {
+		log.Panic("rpc client: items must be a channel")
+	}
+	call := newCall(serviceMethod, args, nil, done)
+	call.items = v
+	block := client.opt == nil || !client.opt.FailOnMaxPending
+	if err := client.acquireSlot(context.Background(), block); err != nil {
+		call.Error = err
+		client.complete(call)
+		return call
+	}
+	// 流式调用不参与合并，见Option.SingleFlight
+	client.send(call)
+	return call
+}
+
+// handleStreamItem 读取流式响应中的一条消息并投递给对应的调用
+func (client *Client) handleStreamItem(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	client.mu.Unlock()
+	if call == nil {
+		// 与普通响应一样，从未发出的序号使连接失效
+		if err := client.cc.ReadBody(nil); err != nil {
+			return err
+		}
+		return client.orphan(h)
+	}
+	if !call.items.IsValid() && call.stream == nil {
+		return client.cc.ReadBody(nil)
+	}
+	if h.ServiceMethod != call.ServiceMethod {
+		if call = client.removeCall(h.Seq); call != nil {
+			call.Error = protocolError(h, call)
+			client.complete(call)
+		}
+		return client.cc.ReadBody(nil)
+	}
+	if call.stream != nil {
+		return call.stream.deliver()
+	}
+	typ := call.items.Type().Elem()
+	item := reflect.New(typ)
+	if typ.Kind() == reflect.Ptr {
+		item.Elem().Set(reflect.New(typ.Elem()))
+		if err := client.cc.ReadBody(item.Elem().Interface()); err != nil {
+			return err
+		}
+	} else if err := client.cc.ReadBody(item.Interface()); err != nil {
+		return err
+	}
+	call.itemsMu.Lock()
+	defer call.itemsMu.Unlock()
+	// 调用可能已经失败并关闭了items
+	client.mu.Lock()
+	_, ok := client.pending[h.Seq]
+	client.mu.Unlock()
+	if ok {
+		call.items.Send(item.Elem())
+	}
+	return nil
+}
+
+// errSendClosed CloseSend之后再调用ClientStream.Send时返回
+var errSendClosed = errors.New("rpc client: send on closed stream")
+
+// ClientStream 客户端一侧的双向流，由Client.NewStream建立，帧格式与流量控制见Stream
+type ClientStream struct {
+	client     *Client
+	call       *Call
+	mu         sync.Mutex // 保证CloseSend之后不再有Send
+	sendClosed bool
+	incoming   chan struct{} // 接收协程有一条消息等待Recv解码
+	consumed   chan struct{} // Recv已读取消息，接收协程继续
+	ended      chan struct{} // 调用已结束，结果为call.Error，见Client.complete
+	abandoned  chan struct{} // 流已放弃，之后的消息被丢弃
+	abortOnce  sync.Once
+}
+
+// NewStream 调用双向流式方法，返回的流通过Send和Recv与方法交换消息
+// ctx结束时放弃流，服务端方法的ctx随之取消，ctx的截止时间、追踪ID、优先级和令牌与Call一样随请求发送
+// 调用方应持续Recv直到返回io.EOF（方法返回nil）或方法的错误，否则同一连接上的其他响应都会等待
+func (client *Client) NewStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	if client.callback {
+		return nil, errors.New("rpc client: streams are not supported on callbacks")
+	}
+	call := newCall(serviceMethod, invalidRequest, nil, nil)
+	cs := &ClientStream{
+		client:    client,
+		call:      call,
+		incoming:  make(chan struct{}),
+		consumed:  make(chan struct{}),
+		ended:     make(chan struct{}),
+		abandoned: make(chan struct{}),
+	}
+	call.stream = cs
+	if id := TraceIDFromContext(ctx); id != "" {
+		call.TraceID = id
+	}
+	call.priority = callPriority(ctx)
+	call.deadline, _ = ctx.Deadline()
+	if token := callToken(ctx); token != "" {
+		client.mu.Lock()
+		secure := client.secure
+		client.mu.Unlock()
+		if err := checkToken(token, secure, client.opt); err != nil {
+			return nil, err
+		}
+		call.token = token
+	}
+	block := client.opt == nil || !client.opt.FailOnMaxPending
+	if err := client.acquireSlot(ctx, block); err != nil {
+		return nil, err
+	}
+	// 流式调用不参与合并，见Option.SingleFlight
+	client.send(call)
+	select {
+	case <-cs.ended:
+		if call.Error != nil {
+			return nil, call.Error
+		}
+	default:
+	}
+	go cs.watch(ctx)
+	return cs, nil
+}
+
+// watch ctx结束时放弃流
+func (cs *ClientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		cs.abort(errors.New("rpc client: stream aborted: " + ctx.Err().Error()))
+	case <-cs.ended:
+	}
+}
+
+// Send 向方法发送一条消息，可被多个协程并发调用，流结束后返回io.EOF，结果由Recv得到
+func (cs *ClientStream) Send(msg interface{}) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.sendClosed {
+		return errSendClosed
+	}
+	select {
+	case <-cs.ended:
+		return io.EOF
+	default:
+	}
+	return cs.write(msg, "")
+}
+
+// CloseSend 结束发送，方法的Recv随后返回io.EOF，之后仍可以Recv
+func (cs *ClientStream) CloseSend() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.sendClosed {
+		return nil
+	}
+	cs.sendClosed = true
+	select {
+	case <-cs.ended:
+		return nil
+	default:
+	}
+	return cs.write(invalidRequest, streamEOF)
+}
+
+// Recv 接收方法的下一条消息并解码进v，v为nil时丢弃
+// 方法返回nil后返回io.EOF，否则返回方法的错误或调用失败的原因，同一时刻只应有一个协程调用Recv
+func (cs *ClientStream) Recv(v interface{}) error {
+	select {
+	case <-cs.incoming:
+		err := cs.client.cc.ReadBody(v)
+		cs.consumed <- struct{}{}
+		return err
+	case <-cs.ended:
+		if cs.call.Error != nil {
+			return cs.call.Error
+		}
+		return io.EOF
+	}
+}
+
+// write 发送流上的一帧，errStr非空时为结束帧
+func (cs *ClientStream) write(body interface{}, errStr string) error {
+	client := cs.client
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	h := codec.Header{Seq: cs.call.Seq, Stream: true, Error: errStr}
+	err := client.cc.Write(&h, body)
+	if err == nil {
+		err = client.cc.Flush()
+	}
+	return err
+}
+
+// abort 放弃流，通知服务端取消方法，调用以err结束
+func (cs *ClientStream) abort(err error) {
+	cs.abortOnce.Do(func() {
+		close(cs.abandoned)
+		cs.mu.Lock()
+		cs.sendClosed = true
+		cs.mu.Unlock()
+		call := cs.client.removeCall(cs.call.Seq)
+		if call == nil {
+			return
+		}
+		_ = cs.write(invalidRequest, err.Error())
+		call.Error = err
+		cs.client.complete(call)
+	})
+}
+
+// deliver 由接收协程调用，将一条消息交给Recv，流已放弃时丢弃
+func (cs *ClientStream) deliver() error {
+	select {
+	case cs.incoming <- struct{}{}:
+		<-cs.consumed
+		return nil
+	case <-cs.abandoned:
+		return cs.client.cc.ReadBody(nil)
+	}
+}
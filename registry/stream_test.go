@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Items int
+
+type Item struct {
+	ID   int
+	Name string
+}
+
+func (i Items) List(n int, stream *Stream) error {
+	for id := 1; id <= n; id++ {
+		if err := stream.Send(&Item{ID: id, Name: "item"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i Items) Fail(n int, stream *Stream) error {
+	_ = stream.Send(&Item{ID: 1})
+	return errors.New("item failed")
+}
+
+func TestClient_GoStream(t *testing.T) {
+	t.Parallel()
+	var items Items
+	server := NewServer()
+	_ = server.Register(&items)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ch := make(chan *Item, 1)
+	call := client.GoStream("Items.List", 5, ch, nil)
+	var got []*Item
+	for item := range ch {
+		got = append(got, item)
+	}
+	_assert(len(got) == 5 && got[0].ID == 1 && got[4].ID == 5, "expect 5 items in order, got %v", got)
+	select {
+	case <-call.Done:
+		_assert(call.Error == nil, "expect a clean end of stream, got %v", call.Error)
+	case <-time.After(time.Second):
+		t.Fatal("expect the call to be done")
+	}
+
+	// errors end the stream too, and the connection stays usable
+	values := make(chan Item, 10)
+	call = client.GoStream("Items.Fail", 1, values, nil)
+	<-call.Done
+	_assert(call.Error != nil && call.Error.Error() == "item failed", "expect the method error, got %v", call.Error)
+	_, open := <-values
+	_assert(open, "expect the item sent before the error")
+	_, open = <-values
+	_assert(!open, "expect items to be closed")
+	_assert(client.IsAvailable(), "expect the client to stay usable")
+}
+
+type PingPong struct {
+	aborted chan struct{}
+}
+
+// Chat answers every number with its successor until the client stops sending
+func (p *PingPong) Chat(stream *Stream) error {
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(n + 1); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *PingPong) Wait(ctx context.Context, stream *Stream) error {
+	<-ctx.Done()
+	close(p.aborted)
+	return ctx.Err()
+}
+
+func (p *PingPong) Listen(n int, stream *Stream) error {
+	return stream.Recv(nil)
+}
+
+func TestClient_NewStream(t *testing.T) {
+	t.Parallel()
+	p := &PingPong{aborted: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(p)
+	_ = server.Register(new(Items))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	t.Run("ping pong", func(t *testing.T) {
+		stream, err := client.NewStream(ctx, "PingPong.Chat")
+		_assert(err == nil, "failed to open the stream: %v", err)
+		for i := 0; i < 5; i++ {
+			_assert(stream.Send(i) == nil, "failed to send %d", i)
+			var n int
+			err = stream.Recv(&n)
+			_assert(err == nil && n == i+1, "expect %d, got %d (%v)", i+1, n, err)
+		}
+		_assert(stream.CloseSend() == nil, "failed to close the stream")
+		_assert(stream.Send(5) != nil, "expect Send to fail after CloseSend")
+		err = stream.Recv(nil)
+		_assert(err == io.EOF, "expect io.EOF once the method returns, got %v", err)
+	})
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		a, _ := client.NewStream(ctx, "PingPong.Chat")
+		b, _ := client.NewStream(ctx, "PingPong.Chat")
+		_ = a.Send(10)
+		_ = b.Send(20)
+		// the receive loop delivers in arrival order, so both streams are read concurrently
+		var x, y int
+		done := make(chan struct{})
+		go func() {
+			_ = b.Recv(&y)
+			close(done)
+		}()
+		_ = a.Recv(&x)
+		<-done
+		_assert(x == 11 && y == 21, "expect frames routed by stream, got %d and %d", x, y)
+		_ = a.CloseSend()
+		_ = b.CloseSend()
+		_assert(a.Recv(nil) == io.EOF && b.Recv(nil) == io.EOF, "expect both streams to end")
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := client.NewStream(ctx, "PingPong.Wait")
+		_assert(err == nil, "failed to open the stream: %v", err)
+		cancel()
+		err = stream.Recv(nil)
+		_assert(err != nil && err != io.EOF, "expect the abort error, got %v", err)
+		select {
+		case <-p.aborted:
+		case <-time.After(time.Second):
+			t.Fatal("expect the method ctx to be canceled")
+		}
+	})
+
+	t.Run("shape mismatch", func(t *testing.T) {
+		err := client.Call(ctx, "PingPong.Chat", 1, nil)
+		_assert(err != nil && strings.Contains(err.Error(), "NewStream"), "expect Call to be refused, got %v", err)
+		stream, err := client.NewStream(ctx, "Items.List")
+		_assert(err == nil, "failed to open the stream: %v", err)
+		err = stream.Recv(nil)
+		_assert(err != nil && strings.Contains(err.Error(), "not a bidirectional"), "expect NewStream to be refused, got %v", err)
+		call := client.GoStream("PingPong.Listen", 1, make(chan int, 1), nil)
+		<-call.Done
+		_assert(call.Error != nil && call.Error.Error() == errNotBidi.Error(), "expect Recv to fail, got %v", call.Error)
+	})
+	_assert(client.IsAvailable(), "expect the client to stay usable")
+}
@@ -0,0 +1,32 @@
+package registry
+
+import "time"
+
+// TimeoutProvider 服务的接收者实现TimeoutProvider时，注册时以RPCTimeout(方法名)作为各方法的处理时限，0表示不设限
+// 由SetMethodTimeout设置的时限优先
+type TimeoutProvider interface {
+	RPCTimeout(method string) time.Duration
+}
+
+// SetMethodTimeout 限制serviceMethod的处理时间，超时后只发送一次错误码为CodeDeadlineExceeded的响应，方法的ctx被取消，之后的结果被丢弃
+// 与连接的HandleTimeout较小者为准。请求头中客户端ctx的截止时间只取消方法的ctx，它更早到期时客户端先放弃等待，不会收到超时响应
+// 可以在服务运行时调整，对之后的请求生效，d不大于0时取消设置，恢复为接收者的RPCTimeout
+func (server *Server) SetMethodTimeout(serviceMethod string, d time.Duration) {
+	if d <= 0 {
+		server.methodTimeouts.Delete(serviceMethod)
+		return
+	}
+	server.methodTimeouts.Store(serviceMethod, d)
+}
+
+// handleTimeout 返回请求的处理时限，方法的时限与连接的HandleTimeout较小者为准，0表示不设限
+func (server *Server) handleTimeout(req *request, connTimeout time.Duration) time.Duration {
+	d := req.mtype.timeout
+	if v, ok := server.methodTimeouts.Load(req.h.ServiceMethod); ok {
+		d = v.(time.Duration)
+	}
+	if d > 0 && (connTimeout <= 0 || d < connTimeout) {
+		return d
+	}
+	return connTimeout
+}
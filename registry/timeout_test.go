@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Patient 以RPCTimeout声明各方法的处理时限
+type Patient int
+
+func (p Patient) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func (p Patient) Wait(ms int, reply *int) error { return p.Sleep(ms, reply) }
+
+func (p Patient) RPCTimeout(method string) time.Duration {
+	if method == "Sleep" {
+		return 50 * time.Millisecond
+	}
+	return 0
+}
+
+func TestServer_SetMethodTimeout(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Slow))
+	_ = server.Register(new(Patient))
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	var reply int
+
+	expectTimeout := func(method string, ms int, within time.Duration) {
+		t.Helper()
+		start := time.Now()
+		err := client.Call(ctx, method, ms, &reply)
+		_assert(ErrorCodeOf(err) == CodeDeadlineExceeded, "expect %s(%d) to time out, got %v", method, ms, err)
+		_assert(strings.Contains(err.Error(), within.String()), "expect the timeout %s in %v", within, err)
+		_assert(time.Since(start) < time.Duration(ms)*time.Millisecond, "expect the timeout before %s returns", method)
+	}
+
+	// configured by the server
+	server.SetMethodTimeout("Slow.Sleep", 50*time.Millisecond)
+	expectTimeout("Slow.Sleep", 300, 50*time.Millisecond)
+	err := client.Call(ctx, "Slow.Sleep", 10, &reply)
+	_assert(err == nil && reply == 10, "expect a fast call to succeed, got %v", err)
+
+	// declared by the receiver, SetMethodTimeout takes precedence
+	expectTimeout("Patient.Sleep", 300, 50*time.Millisecond)
+	err = client.Call(ctx, "Patient.Wait", 100, &reply)
+	_assert(err == nil && reply == 100, "expect Patient.Wait to have no timeout, got %v", err)
+	server.SetMethodTimeout("Patient.Sleep", time.Second)
+	err = client.Call(ctx, "Patient.Sleep", 100, &reply)
+	_assert(err == nil && reply == 100, "expect the overridden timeout to apply, got %v", err)
+	server.SetMethodTimeout("Patient.Sleep", 0)
+	expectTimeout("Patient.Sleep", 300, 50*time.Millisecond)
+
+	// the smaller of HandleTimeout and the method timeout wins
+	server.SetMethodTimeout("Slow.Sleep", time.Second)
+	short, _ := Dial("tcp", l.Addr().String(), &Option{HandleTimeout: 30 * time.Millisecond})
+	defer func() { _ = short.Close() }()
+	err = short.Call(ctx, "Slow.Sleep", 300, &reply)
+	_assert(ErrorCodeOf(err) == CodeDeadlineExceeded && strings.Contains(err.Error(), "30ms"), "expect HandleTimeout to win, got %v", err)
+	err = short.Call(ctx, "Patient.Sleep", 300, &reply)
+	_assert(ErrorCodeOf(err) == CodeDeadlineExceeded && strings.Contains(err.Error(), "30ms"), "expect HandleTimeout to win, got %v", err)
+
+	// an earlier deadline of the caller gives up before the method timeout
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	err = client.Call(callCtx, "Slow.Sleep", 300, &reply)
+	_assert(err != nil && callCtx.Err() != nil, "expect the caller's deadline to win, got %v", err)
+}
+
+func TestServer_HandleTimeoutGoroutines(t *testing.T) {
+	// not parallel, the goroutines of other tests would be counted
+	server := NewServer()
+	_ = server.Register(new(Slow))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String(), &Option{HandleTimeout: 20 * time.Millisecond})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		err := client.Call(context.Background(), "Slow.Sleep", 100, &reply)
+		_assert(ErrorCodeOf(err) == CodeDeadlineExceeded, "expect a timeout, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the handlers to exit after a timeout, %d goroutines before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the timed out handlers sent nothing more on the connection
+	err = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "expect the connection to still work, got %v", err)
+}
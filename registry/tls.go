@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// defaultTLSHandshakeTimeout 未设置Server.TLSHandshakeTimeout时TLS握手的时限
+const defaultTLSHandshakeTimeout = 10 * time.Second
+
+// AcceptTLS 以TLS接受连接并为之提供服务，config须包含服务端证书
+// 需要双向认证时将config.ClientAuth设置为RequireAndVerifyClientCert并设置ClientCAs，
+// 经过校验的客户端证书的CommonName可以通过CommonNameFromContext取得，未设置SetAuthFunc时也作为连接的身份
+// 握手在处理连接的协程中完成，失败或超过TLSHandshakeTimeout只关闭该连接，不影响Accept
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		server.logger().Errorf("rpc server: accept tls error: %v", errNoServerCert)
+		return
+	}
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// AcceptTLS 默认的AcceptTLS
+func AcceptTLS(lis net.Listener, config *tls.Config) { DefaultServer.AcceptTLS(lis, config) }
+
+// errNoServerCert AcceptTLS的配置中没有服务端证书
+var errNoServerCert = errors.New("tls config has no server certificate")
+
+// tlsHandshakeTimeout 返回TLS握手的时限
+func (server *Server) tlsHandshakeTimeout() time.Duration {
+	if server.TLSHandshakeTimeout > 0 {
+		return server.TLSHandshakeTimeout
+	}
+	return defaultTLSHandshakeTimeout
+}
+
+// commonNameKey 在请求上下文中保存客户端证书CommonName的键
+type commonNameKey struct{}
+
+// connContext 返回连接的基础上下文，TLS连接在握手后附带客户端证书的身份
+// 握手须在timeout内完成，避免不完成握手的客户端一直占用连接
+func connContext(conn io.ReadWriteCloser, timeout time.Duration) (context.Context, error) {
+	ctx := withRemoteAddr(context.Background(), conn)
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ctx, nil
+	}
+	_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	// 只信任经过校验的证书链，服务端须设置ClientAuth为VerifyClientCertIfGiven或RequireAndVerifyClientCert
+	if chains := tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		ctx = context.WithValue(ctx, commonNameKey{}, chains[0][0].Subject.CommonName)
+	}
+	return ctx, nil
+}
+
+// CommonNameFromContext 返回客户端证书经过校验的Subject.CommonName
+// 连接不是TLS连接或客户端没有提供证书时ok为false，ctx须来自接收context.Context的服务方法
+func CommonNameFromContext(ctx context.Context) (cn string, ok bool) {
+	cn, ok = ctx.Value(commonNameKey{}).(string)
+	return
+}
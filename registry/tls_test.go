@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newCert 生成由parent签发的证书，parent为nil时生成自签名的CA
+func newCert(cn string, parent *tls.Certificate) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey.(*ecdsa.PrivateKey)
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type Identity int
+
+func (i Identity) CommonName(ctx context.Context, _ int, reply *string) error {
+	*reply, _ = CommonNameFromContext(ctx)
+	return nil
+}
+
+func TestServer_TLSCommonName(t *testing.T) {
+	t.Parallel()
+	ca := newCert("test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert, clientCert := newCert("server", &ca), newCert("alice", &ca)
+
+	var id Identity
+	server := NewServer()
+	_ = server.Register(&id)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}))
+
+	client, err := DialWith("tcp", l.Addr().String(), WithTLS(&tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+	}))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var cn string
+	err = client.Call(context.Background(), "Identity.CommonName", 0, &cn)
+	_assert(err == nil && cn == "alice", "expect the client CN, got %q: %v", cn, err)
+
+	// no client certificate, no identity
+	anonymous, err := DialWith("tcp", l.Addr().String(), WithTLS(&tls.Config{RootCAs: pool}))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = anonymous.Close() }()
+	err = anonymous.Call(context.Background(), "Identity.CommonName", 0, &cn)
+	_assert(err == nil && cn == "", "expect no CN, got %q: %v", cn, err)
+}
+
+func TestServer_AcceptTLS(t *testing.T) {
+	t.Parallel()
+	ca := newCert("test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert, clientCert := newCert("server", &ca), newCert("alice", &ca)
+	start := func(clientAuth tls.ClientAuthType, logger Logger) (string, *Server) {
+		server := &Server{Logger: logger, TLSHandshakeTimeout: 100 * time.Millisecond}
+		_ = server.Register(new(Identity))
+		_ = server.Register(new(AuthIdentity))
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go server.AcceptTLS(l, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    pool,
+			ClientAuth:   clientAuth,
+		})
+		return l.Addr().String(), server
+	}
+
+	t.Run("mutual", func(t *testing.T) {
+		t.Parallel()
+		logger := new(captureLogger)
+		addr, _ := start(tls.RequireAndVerifyClientCert, logger)
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		var cn, identity string
+		err = client.Call(context.Background(), "Identity.CommonName", 0, &cn)
+		_assert(err == nil && cn == "alice", "expect the client CN, got %q: %v", cn, err)
+		err = client.Call(context.Background(), "AuthIdentity.Get", 0, &identity)
+		_assert(err == nil && identity == "alice", "expect the CN as the identity, got %q: %v", identity, err)
+		for i := 0; i < 100 && len(logger.find(" access method=AuthIdentity.Get ")) < 1; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		_assert(len(logger.find(" access method=AuthIdentity.Get ", " identity=alice ")) == 1, "expect the identity in the access log, got %q", logger.lines)
+
+		_, err = DialTLS("tcp", addr, &tls.Config{RootCAs: pool})
+		_assert(err != nil, "expect a client without a certificate to be refused")
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		t.Parallel()
+		addr, _ := start(tls.NoClientCert, nil)
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		var cn string
+		err = client.Call(context.Background(), "Identity.CommonName", 0, &cn)
+		_assert(err == nil && cn == "", "expect no CN, got %q: %v", cn, err)
+		_, err = Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+		_assert(err != nil, "expect a plain client to be refused")
+	})
+
+	t.Run("handshake timeout", func(t *testing.T) {
+		t.Parallel()
+		addr, _ := start(tls.NoClientCert, nil)
+		// a client that never starts the handshake is dropped
+		conn, err := net.Dial("tcp", addr)
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		_assert(err != nil && !isTimeout(err), "expect the server to close the connection, got %v", err)
+		// and the listener keeps accepting
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool})
+		_assert(err == nil, "failed to dial after a stalled handshake: %v", err)
+		_ = client.Close()
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		t.Parallel()
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer func() { _ = l.Close() }()
+		done := make(chan struct{})
+		go func() {
+			NewServer().AcceptTLS(l, &tls.Config{})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expect AcceptTLS to return without a server certificate")
+		}
+	})
+}
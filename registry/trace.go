@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"goRPC/client/codec"
+	"strings"
+)
+
+// traceIDKey 在上下文中保存追踪ID的键
+type traceIDKey struct{}
+
+// WithTraceID 返回携带追踪ID的ctx，Client.Call使用它发起的调用沿用该ID而不是生成新的
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceIDFromContext 返回ctx中的追踪ID，没有时返回空字符串
+// 客户端为WithTraceID设置的ID，服务端为处理请求的ctx携带的调用方的ID
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// newTraceID 生成随机的16字节追踪ID的十六进制形式
+func newTraceID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// traceError 在服务端返回的错误信息前加上请求的追踪ID，便于在响应中关联日志
+func traceError(h *codec.Header, msg string) string {
+	if h.TraceID == "" {
+		return msg
+	}
+	return "[trace " + h.TraceID + "] " + msg
+}
+
+// untraceError 去掉traceError加上的前缀，客户端已在Call.TraceID中保存追踪ID
+func untraceError(h *codec.Header) string {
+	return strings.TrimPrefix(h.Error, "[trace "+h.TraceID+"] ")
+}
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"goRPC/client/codec"
+	"net"
+	"testing"
+)
+
+type Tracer int
+
+func (t *Tracer) Get(ctx context.Context, _ int, reply *string) error {
+	*reply = TraceIDFromContext(ctx)
+	return nil
+}
+
+func (t *Tracer) Fail(ctx context.Context, _ int, _ *int) error {
+	return errors.New("boom")
+}
+
+func TestTraceID(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var tr Tracer
+	_ = server.Register(&tr)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(WithTraceID(context.Background(), "abc"), "Tracer.Get", 0, &reply)
+	_assert(err == nil && reply == "abc", "expect the caller's trace ID on the server, got %q: %v", reply, err)
+
+	call := <-client.Go("Tracer.Get", 0, &reply, nil).Done
+	_assert(call.Error == nil && len(call.TraceID) == 32 && reply == call.TraceID,
+		"expect a generated trace ID on both sides, got %q and %q: %v", call.TraceID, reply, call.Error)
+	other := <-client.Go("Tracer.Get", 0, &reply, nil).Done
+	_assert(other.TraceID != call.TraceID, "expect a new trace ID per call")
+
+	err = client.Call(WithTraceID(context.Background(), "def"), "Tracer.Fail", 0, nil)
+	_assert(err != nil && err.Error() == "boom", "expect the method error without the trace prefix, got %v", err)
+}
+
+func TestTraceError(t *testing.T) {
+	t.Parallel()
+	h := &codec.Header{TraceID: "abc"}
+	h.Error = traceError(h, "boom")
+	_assert(h.Error == "[trace abc] boom", "unexpected error %q", h.Error)
+	_assert(untraceError(h) == "boom", "unexpected error %q", untraceError(h))
+	h.Error = "[trace other] boom"
+	_assert(untraceError(h) == h.Error, "expect another trace ID to be kept")
+	_assert(traceError(&codec.Header{}, "boom") == "boom", "expect no prefix without trace ID")
+}
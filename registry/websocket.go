@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket的帧类型，见RFC 6455
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsGUID 计算Sec-WebSocket-Accept时附加在Sec-WebSocket-Key之后的常量
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSControlPayload 控制帧负载的最大字节数
+const maxWSControlPayload = 125
+
+var (
+	errWSUnmasked = errors.New("websocket: client frame is not masked")
+	errWSClosed   = errors.New("websocket: close frame already sent")
+)
+
+// ServeWS 将WebSocket升级请求转为RPC连接并为之提供服务，可以注册在任意路径，例如http.HandleFunc("/ws", server.ServeWS)
+// 升级后按普通连接握手，客户端见DialWS，浏览器以二进制消息发送Option和之后的请求
+// 消息边界与请求无关，连接上的消息按顺序拼接为字节流交给Codec，因此一个请求可以跨多个消息，一个消息也可以包含多个请求
+// ServeWS不检查Origin，需要限制浏览器页面的来源时在外层的处理程序中检查
+func (server *Server) ServeWS(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "400 must upgrade to websocket", http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "426 unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		server.logger().Errorf("rpc server: hijacking %s: %v", req.RemoteAddr, err)
+		return
+	}
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+wsAccept(key)+"\r\n\r\n")
+	if err != nil {
+		server.logger().Errorf("rpc server: websocket upgrade of %s: %v", req.RemoteAddr, err)
+		_ = conn.Close()
+		return
+	}
+	server.ServeConn(newWSConn(conn, brw.Reader, false))
+}
+
+// headerContains 请求头key中以逗号分隔的某一项与token相同时返回true，不区分大小写
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAccept 返回对Sec-WebSocket-Key的应答
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsHandshake 在conn上以客户端身份发出WebSocket升级请求，成功时返回WebSocket连接
+func wsHandshake(conn net.Conn, host, path string) (net.Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	_, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+host+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+	return newWSConn(conn, br, true), nil
+}
+
+// wsConn 将WebSocket连接适配为字节流，每次Write发送一个二进制消息，Read按顺序读出各消息的负载
+// 对端的ping由Read回复pong，对端关闭时Read返回io.EOF，其余的net.Conn方法作用于底层连接
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool // 客户端发送的帧须加掩码，服务端收到的帧须有掩码
+
+	rmu       sync.Mutex
+	remaining uint64  // 当前帧尚未读出的负载字节数
+	mask      [4]byte // 当前帧的掩码
+	masked    bool
+	pos       int // 当前帧已读出的负载字节数，用于定位掩码
+
+	wmu    sync.Mutex
+	closed bool // 已发送关闭帧
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, br: br, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame 读取下一帧的头部，处理控制帧，数据帧的负载留给Read
+func (c *wsConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0F
+	c.masked = head[1]&0x80 != 0
+	if !c.client && !c.masked {
+		return errWSUnmasked
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	c.pos = 0
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+		if length > maxWSControlPayload {
+			return fmt.Errorf("websocket: control frame of %d bytes", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		c.unmask(payload)
+		switch opcode {
+		case wsClose:
+			_ = c.writeFrame(wsClose, payload)
+			return io.EOF
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("websocket: unknown opcode %d", opcode)
+	}
+}
+
+// unmask 以当前帧的掩码还原负载
+func (c *wsConn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[(c.pos+i)%4]
+	}
+	c.pos += len(p)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame 将payload作为一个完整的帧发送，头部和负载一次写入，发送关闭帧后不再发送
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(n))
+		frame = append(append(frame, maskBit|126), ext[:]...)
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+	if !c.client {
+		_, err := c.Conn.Write(append(frame, payload...))
+		return err
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close 发送关闭帧后关闭底层连接，不等待对端的关闭帧
+func (c *wsConn) Close() error {
+	_ = c.writeFrame(wsClose, []byte{0x03, 0xE8}) // 1000，正常关闭
+	return c.Conn.Close()
+}
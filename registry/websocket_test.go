@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Echo int
+
+func (e Echo) Say(s string, reply *string) error {
+	*reply = s
+	return nil
+}
+
+func TestServer_ServeWS(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	_ = server.Register(new(Echo))
+	web := httptest.NewServer(http.HandlerFunc(server.ServeWS))
+	defer web.Close()
+	addr := strings.TrimPrefix(web.URL, "http://")
+
+	for _, dial := range []func() (*Client, error){
+		func() (*Client, error) { return DialWS("ws://" + addr + "/ws") },
+		func() (*Client, error) { return XDial("ws@" + addr + "/ws") },
+	} {
+		client, err := dial()
+		_assert(err == nil, "failed to dial: %v", err)
+		var sum int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "failed to call Foo.Sum: %v", err)
+		// longer than a frame with a 16-bit length
+		long := strings.Repeat("goRPC", 20000)
+		var reply string
+		err = client.Call(context.Background(), "Echo.Say", long, &reply)
+		_assert(err == nil && reply == long, "failed to echo %d bytes: %v", len(long), err)
+		_ = client.Close()
+	}
+
+	resp, err := http.Get(web.URL)
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "expect a plain GET to be refused, got %v", err)
+	_ = resp.Body.Close()
+	_, err = DialWS("http://" + addr)
+	_assert(err != nil, "expect a scheme other than ws and wss to be refused")
+}
+
+func TestWSConn(t *testing.T) {
+	t.Parallel()
+	c1, c2 := net.Pipe()
+	defer func() { _ = c1.Close(); _ = c2.Close() }()
+	client := newWSConn(c1, bufio.NewReader(c1), true)
+	server := newWSConn(c2, bufio.NewReader(c2), false)
+
+	// the payloads of consecutive frames form one stream, a ping in between is answered
+	go func() {
+		_ = client.writeFrame(wsBinary, []byte("hello, "))
+		_ = client.writeFrame(wsPing, []byte("ping"))
+		_ = client.writeFrame(wsContinuation, []byte("world"))
+	}()
+	pong := make(chan []byte, 1)
+	go func() {
+		frame := make([]byte, 6)
+		_, _ = io.ReadFull(client.br, frame)
+		pong <- frame
+	}()
+	got := make([]byte, 12)
+	_, err := io.ReadFull(server, got)
+	_assert(err == nil && string(got) == "hello, world", "unexpected stream %q: %v", got, err)
+	frame := <-pong
+	_assert(frame[0] == 0x80|wsPong && frame[1] == 4 && string(frame[2:]) == "ping", "expect an unmasked pong, got %v", frame)
+
+	// frames from a client must be masked
+	go func() { _, _ = c1.Write([]byte{0x80 | wsBinary, 1}) }()
+	_, err = server.Read(got)
+	_assert(err == errWSUnmasked, "expect an unmasked frame to be refused, got %v", err)
+}
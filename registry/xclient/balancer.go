@@ -0,0 +1,189 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// errNoServers 服务器列表为空，与MultiServersDiscovery.Get的错误一致
+var errNoServers = errors.New("rpc discovery: no available servers")
+
+// Balancer 从发现的服务器列表中为一次调用选出服务器，设置后XClient不再按SelectMode调用Discovery.Get
+// servers非空且归调用方所有，实现须可以被并发调用，见XClient.SetBalancer
+type Balancer interface {
+	Pick(servers []string, ctx context.Context, serviceMethod string, args interface{}) (string, error)
+}
+
+// ObservingBalancer 需要调用结果反馈的Balancer，例如按连接数或延迟选择
+// Call、CallWithInfo和Broadcast向服务器发出调用前调用Begin，结束后调用End，Go不等待结果，不计入
+type ObservingBalancer interface {
+	Balancer
+	Begin(addr string)
+	End(addr string, d time.Duration, err error)
+}
+
+// SetBalancer 设置选择服务器的Balancer，须在发起调用之前设置，b为nil时恢复按SelectMode选择
+// 启用熔断器时，选中熔断中的服务器后将其从列表中去掉再次选择
+func (xc *XClient) SetBalancer(b Balancer) {
+	if b == nil && xc.mode == LeastLatencySelect {
+		b = NewLeastLatencyBalancer()
+	}
+	xc.balancer = b
+}
+
+// pick 用Balancer选出服务器，allow拒绝的服务器从列表中去掉后重新选择
+func (xc *XClient) pick(servers []string, ctx context.Context, serviceMethod string, args interface{}, allow func(addr string) bool) (string, error) {
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	for len(servers) > 0 {
+		addr, err := xc.balancer.Pick(servers, ctx, serviceMethod, args)
+		if err != nil {
+			return "", err
+		}
+		if allow(addr) {
+			return addr, nil
+		}
+		rest := make([]string, 0, len(servers)-1)
+		for _, s := range servers {
+			if s != addr {
+				rest = append(rest, s)
+			}
+		}
+		if len(rest) == len(servers) {
+			// 选出的不是列表中的服务器，避免死循环
+			return "", ErrCircuitOpen
+		}
+		servers = rest
+	}
+	return "", ErrCircuitOpen
+}
+
+// randomBalancer 随机选择
+type randomBalancer struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRandomBalancer 返回随机选择服务器的Balancer
+func NewRandomBalancer() Balancer {
+	return &randomBalancer{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) Pick(servers []string, _ context.Context, _ string, _ interface{}) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return servers[b.r.Intn(len(servers))], nil
+}
+
+// roundRobinBalancer 轮询选择
+type roundRobinBalancer struct {
+	mu    sync.Mutex
+	index int
+}
+
+// NewRoundRobinBalancer 返回轮询选择服务器的Balancer，起始位置随机
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{index: rand.Intn(math.MaxInt32 - 1)}
+}
+
+func (b *roundRobinBalancer) Pick(servers []string, _ context.Context, _ string, _ interface{}) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := servers[b.index%len(servers)]
+	b.index = (b.index + 1) % len(servers)
+	return s, nil
+}
+
+// leastConnBalancer 选择进行中调用最少的服务器，数量相同时轮流选择
+type leastConnBalancer struct {
+	mu     sync.Mutex
+	active map[string]int
+	next   int
+}
+
+// NewLeastConnBalancer 返回选择进行中调用最少的服务器的Balancer
+func NewLeastConnBalancer() ObservingBalancer {
+	return &leastConnBalancer{active: make(map[string]int)}
+}
+
+func (b *leastConnBalancer) Pick(servers []string, _ context.Context, _ string, _ interface{}) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(servers)
+	start := b.next % n
+	b.next = (b.next + 1) % n
+	best := servers[start]
+	for i := 1; i < n; i++ {
+		if s := servers[(start+i)%n]; b.active[s] < b.active[best] {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+func (b *leastConnBalancer) Begin(addr string) {
+	b.mu.Lock()
+	b.active[addr]++
+	b.mu.Unlock()
+}
+
+func (b *leastConnBalancer) End(addr string, _ time.Duration, _ error) {
+	b.mu.Lock()
+	if b.active[addr]--; b.active[addr] <= 0 {
+		delete(b.active, addr)
+	}
+	b.mu.Unlock()
+}
+
+// ewmaWeight 新观测的延迟在移动平均中的权重
+const ewmaWeight = 0.3
+
+// failureLatency 失败的调用按该延迟计入移动平均，避免快速失败的服务器吸引流量
+const failureLatency = time.Second
+
+// leastLatencyBalancer 选择调用延迟的指数加权移动平均最低的服务器
+// 还没有观测的服务器视为延迟为0，因此新服务器总会被尝试，延迟相同时轮流选择
+type leastLatencyBalancer struct {
+	mu   sync.Mutex
+	ewma map[string]time.Duration
+	next int
+}
+
+// NewLeastLatencyBalancer 返回按延迟选择服务器的Balancer，LeastLatencySelect模式使用它
+func NewLeastLatencyBalancer() ObservingBalancer {
+	return &leastLatencyBalancer{ewma: make(map[string]time.Duration)}
+}
+
+func (b *leastLatencyBalancer) Pick(servers []string, _ context.Context, _ string, _ interface{}) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(servers)
+	start := b.next % n
+	b.next = (b.next + 1) % n
+	best := servers[start]
+	for i := 1; i < n; i++ {
+		if s := servers[(start+i)%n]; b.ewma[s] < b.ewma[best] {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+func (b *leastLatencyBalancer) Begin(string) {}
+
+func (b *leastLatencyBalancer) End(addr string, d time.Duration, err error) {
+	if err != nil && d < failureLatency {
+		d = failureLatency
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if old, ok := b.ewma[addr]; ok {
+		d = time.Duration(ewmaWeight*float64(d) + (1-ewmaWeight)*float64(old))
+	}
+	b.ewma[addr] = d
+}
@@ -0,0 +1,132 @@
+package xclient
+
+import (
+	"context"
+	"goRPC/registry"
+	"net"
+	"testing"
+	"time"
+)
+
+// lastBalancer always picks the last server.
+type lastBalancer struct {
+	methods []string
+}
+
+func (b *lastBalancer) Pick(servers []string, _ context.Context, serviceMethod string, _ interface{}) (string, error) {
+	b.methods = append(b.methods, serviceMethod)
+	return servers[len(servers)-1], nil
+}
+
+func TestXClient_Balancer(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		l, _ := net.Listen("tcp", ":0")
+		h := &Health{addr: "tcp@" + l.Addr().String()}
+		server := registry.NewServer()
+		_ = server.Register(h)
+		go server.Accept(l)
+		addrs = append(addrs, h.addr)
+	}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	b := new(lastBalancer)
+	xc.SetBalancer(b)
+
+	for i := 0; i < 5; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Health.Check", 0, &reply); err != nil || reply != addrs[2] {
+			t.Fatalf("expect the last server %s, got %q: %v", addrs[2], reply, err)
+		}
+	}
+	call := xc.Go("Health.Check", 0, new(string), nil)
+	if <-call.Done; call.Error != nil || *call.Reply.(*string) != addrs[2] {
+		t.Fatalf("expect Go to use the balancer, got %v: %v", call.Reply, call.Error)
+	}
+	if len(b.methods) != 6 || b.methods[0] != "Health.Check" {
+		t.Fatalf("expect the method to be passed to Pick, got %v", b.methods)
+	}
+}
+
+func TestBalancers(t *testing.T) {
+	t.Parallel()
+	servers := []string{"a", "b", "c"}
+	ctx := context.Background()
+
+	rr := NewRoundRobinBalancer()
+	first, _ := rr.Pick(servers, ctx, "", nil)
+	seen := map[string]bool{first: true}
+	for i := 0; i < 2; i++ {
+		s, _ := rr.Pick(servers, ctx, "", nil)
+		seen[s] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expect round robin to visit every server, got %v", seen)
+	}
+
+	lc := NewLeastConnBalancer()
+	lc.Begin("a")
+	lc.Begin("c")
+	for i := 0; i < 3; i++ {
+		if s, _ := lc.Pick(servers, ctx, "", nil); s != "b" {
+			t.Fatalf("expect the idle server b, got %s", s)
+		}
+	}
+	lc.Begin("b")
+	lc.Begin("b")
+	lc.End("a", 0, nil)
+	if s, _ := lc.Pick(servers, ctx, "", nil); s != "a" {
+		t.Fatalf("expect a once it is idle, got %s", s)
+	}
+
+	r := NewRandomBalancer()
+	for i := 0; i < 10; i++ {
+		if s, _ := r.Pick(servers, ctx, "", nil); s != "a" && s != "b" && s != "c" {
+			t.Fatalf("expect one of the servers, got %s", s)
+		}
+	}
+}
+
+// Lag answers with the address of its server after delay.
+type Lag struct {
+	addr  string
+	delay time.Duration
+}
+
+func (l *Lag) Ping(_ int, reply *string) error {
+	time.Sleep(l.delay)
+	*reply = l.addr
+	return nil
+}
+
+func TestXClient_LeastLatency(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for _, delay := range []time.Duration{time.Millisecond * 30, 0} {
+		l, _ := net.Listen("tcp", ":0")
+		lag := &Lag{addr: "tcp@" + l.Addr().String(), delay: delay}
+		server := registry.NewServer()
+		_ = server.Register(lag)
+		go server.Accept(l)
+		addrs = append(addrs, lag.addr)
+	}
+	slow, fast := addrs[0], addrs[1]
+	xc := NewXClient(NewMultiServerDiscovery(addrs), LeastLatencySelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	counts := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Lag.Ping", 0, &reply); err != nil {
+			t.Fatal("failed to call:", err)
+		}
+		if i >= 20 {
+			counts[reply]++
+		}
+	}
+	// both servers are tried at first, then the traffic goes to the fast one
+	if counts[fast] < 18 || counts[slow] > 2 {
+		t.Fatalf("expect the traffic to shift to the fast server, got fast %d slow %d", counts[fast], counts[slow])
+	}
+}
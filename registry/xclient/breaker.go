@@ -0,0 +1,149 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"goRPC/registry"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 所有服务器的熔断器都处于打开状态，调用没有发出
+var ErrCircuitOpen = errors.New("rpc xclient: circuit open for every server")
+
+// breakerState 熔断器的状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常选择该服务器
+	breakerOpen                         // 连续失败达到阈值，冷却期内不选择该服务器
+	breakerHalfOpen                     // 冷却期已过，只放行一个探测调用
+)
+
+// breaker 单个服务器的熔断器
+type breaker struct {
+	state    breakerState
+	failures int       // 连续失败的次数
+	opened   time.Time // 进入打开状态的时间
+}
+
+// breakers 按服务器地址记录熔断器，threshold为0时不熔断
+type breakers struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	m         map[string]*breaker
+}
+
+// allow 返回是否可以向addr发出调用，probe为true时冷却期已过的打开状态转为半开并放行本次调用作为探测
+func (b *breakers) allow(addr string, now time.Time, probe bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.m[addr]
+	if br == nil {
+		return true
+	}
+	switch br.state {
+	case breakerOpen:
+		if !probe || now.Sub(br.opened) < b.cooldown {
+			return false
+		}
+		br.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// 探测调用尚未结束
+		return false
+	}
+	return true
+}
+
+// record 记录向addr发出的调用的结果，成功时关闭熔断器
+// 连续失败达到阈值或探测失败时打开熔断器，canceled为true时不计入失败，半开状态恢复为打开以便再次探测
+func (b *breakers) record(addr string, err error, canceled bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.m[addr]
+	if canceled {
+		if br != nil && br.state == breakerHalfOpen {
+			br.state = breakerOpen
+		}
+		return
+	}
+	if err == nil {
+		delete(b.m, addr)
+		return
+	}
+	if br == nil {
+		br = &breaker{}
+		b.m[addr] = br
+	}
+	br.failures++
+	if br.state == breakerHalfOpen || br.failures >= b.threshold {
+		br.state, br.opened = breakerOpen, now
+	}
+}
+
+// SetBreaker 为每个服务器启用熔断器，须在发起调用之前设置
+// 连续threshold次调用失败后，cooldown内Call和Go不再选择该服务器，之后放行一个调用探测
+// 探测成功则恢复，失败则再次冷却。调用方的ctx结束导致的失败不计入，threshold为0时关闭熔断
+// 服务端以调用方的错误拒绝的调用（NotFound、InvalidArgument、Unauthenticated）视为成功，见registry.ErrorCode
+// Go不等待调用结果，只跳过熔断中的服务器，不发出探测也不计入结果
+func (xc *XClient) SetBreaker(threshold int, cooldown time.Duration) {
+	xc.breakers = &breakers{threshold: threshold, cooldown: cooldown, m: make(map[string]*breaker)}
+	if threshold <= 0 {
+		xc.breakers = nil
+	}
+}
+
+// selectServer 按Balancer或选择模式选出服务器，启用熔断器时跳过熔断中的服务器，probe见breakers.allow
+func (xc *XClient) selectServer(ctx context.Context, serviceMethod string, args interface{}, probe bool) (string, error) {
+	b := xc.breakers
+	now := time.Now()
+	if xc.balancer != nil {
+		servers, err := xc.d.GetAll()
+		if err != nil {
+			return "", err
+		}
+		return xc.pick(servers, ctx, serviceMethod, args, func(addr string) bool {
+			return b == nil || b.allow(addr, now, probe)
+		})
+	}
+	if b == nil {
+		return xc.d.Get(xc.mode)
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	// 先尊重选择模式，随机选择可能一直选中熔断中的服务器，再依次检查
+	for i := 0; i < len(servers); i++ {
+		addr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return "", err
+		}
+		if b.allow(addr, now, probe) {
+			return addr, nil
+		}
+	}
+	for _, addr := range servers {
+		if b.allow(addr, now, probe) {
+			return addr, nil
+		}
+	}
+	if len(servers) == 0 {
+		return xc.d.Get(xc.mode)
+	}
+	return "", ErrCircuitOpen
+}
+
+// recordCall 将调用结果计入addr的熔断器
+// 服务端以NotFound、InvalidArgument或Unauthenticated拒绝的调用是调用方的错误，说明服务器正常，按成功计入
+func (xc *XClient) recordCall(ctx context.Context, addr string, err error) {
+	switch registry.ErrorCodeOf(err) {
+	case registry.CodeNotFound, registry.CodeInvalidArgument, registry.CodeUnauthenticated:
+		err = nil
+	}
+	if xc.breakers != nil {
+		xc.breakers.record(addr, err, err != nil && ctx.Err() != nil, time.Now())
+	}
+}
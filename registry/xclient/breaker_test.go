@@ -0,0 +1,135 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"goRPC/registry"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Health answers with the address of its server, or fails while sick.
+type Health struct {
+	addr  string
+	sick  int32
+	calls int32
+}
+
+func (h *Health) Check(_ int, reply *string) error {
+	atomic.AddInt32(&h.calls, 1)
+	if atomic.LoadInt32(&h.sick) == 1 {
+		return errors.New("sick")
+	}
+	*reply = h.addr
+	return nil
+}
+
+func TestXClient_Breaker(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	var healths []*Health
+	for i := 0; i < 2; i++ {
+		l, _ := net.Listen("tcp", ":0")
+		h := &Health{addr: "tcp@" + l.Addr().String()}
+		server := registry.NewServer()
+		_ = server.Register(h)
+		go server.Accept(l)
+		addrs = append(addrs, h.addr)
+		healths = append(healths, h)
+	}
+	sick, good := healths[0], healths[1]
+	atomic.StoreInt32(&sick.sick, 1)
+
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(3, time.Millisecond*200)
+	call := func() error {
+		var reply string
+		return xc.Call(context.Background(), "Health.Check", 0, &reply)
+	}
+	for atomic.LoadInt32(&sick.calls) < 3 {
+		_ = call()
+	}
+
+	// open: every call goes to the good server
+	for i := 0; i < 10; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("expect the sick server to be skipped, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&sick.calls); n != 3 {
+		t.Fatalf("expect no call to the open server, got %d", n)
+	}
+	if n := atomic.LoadInt32(&good.calls); n < 10 {
+		t.Fatalf("expect the good server to take the traffic, got %d calls", n)
+	}
+
+	// half-open: a failed probe opens the breaker again
+	time.Sleep(time.Millisecond * 250)
+	for i := 0; i < 4; i++ {
+		_ = call()
+	}
+	if n := atomic.LoadInt32(&sick.calls); n != 4 {
+		t.Fatalf("expect a single probe after the cooldown, got %d calls", n-3)
+	}
+
+	// a successful probe closes it
+	atomic.StoreInt32(&sick.sick, 0)
+	time.Sleep(time.Millisecond * 250)
+	for i := 0; i < 4; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("expect the recovered server to answer, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&sick.calls); n < 6 {
+		t.Fatalf("expect the recovered server to be selected again, got %d calls", n)
+	}
+}
+
+func TestXClient_BreakerAllOpen(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", ":0")
+	h := &Health{addr: "tcp@" + l.Addr().String(), sick: 1}
+	server := registry.NewServer()
+	_ = server.Register(h)
+	go server.Accept(l)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{h.addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(1, time.Minute)
+	var reply string
+	_ = xc.Call(context.Background(), "Health.Check", 0, &reply)
+	if err := xc.Call(context.Background(), "Health.Check", 0, &reply); err != ErrCircuitOpen {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+	if call := <-xc.Go("Health.Check", 0, &reply, nil).Done; call.Error != ErrCircuitOpen {
+		t.Fatalf("expect Go to skip the open server, got %v", call.Error)
+	}
+}
+
+func TestXClient_BreakerCallerErrors(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", ":0")
+	h := &Health{addr: "tcp@" + l.Addr().String()}
+	server := registry.NewServer()
+	_ = server.Register(h)
+	go server.Accept(l)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{h.addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(1, time.Minute)
+	// the server answers, the caller is at fault
+	for i := 0; i < 3; i++ {
+		var reply string
+		err := xc.Call(context.Background(), "Health.Missing", 0, &reply)
+		if registry.ErrorCodeOf(err) != registry.CodeNotFound {
+			t.Fatalf("expect a not found error, got %v", err)
+		}
+	}
+	var reply string
+	if err := xc.Call(context.Background(), "Health.Check", 0, &reply); err != nil {
+		t.Fatalf("expect the breaker to stay closed, got %v", err)
+	}
+}
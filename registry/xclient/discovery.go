@@ -1,6 +1,7 @@
 package xclient
 
 import (
+	"context"
 	"errors"
 	"math"
 	"math/rand"
@@ -13,6 +14,8 @@ type SelectMode int
 const (
 	RandomSelect     SelectMode = iota // 使用随机算法
 	RoundRobinSelect                   // 使用轮询算法
+	// LeastLatencySelect 选择调用延迟的移动平均最低的服务器，由XClient记录延迟，Discovery.Get不支持
+	LeastLatencySelect
 )
 
 type Discovery interface {
@@ -20,8 +23,14 @@ type Discovery interface {
 	Update(servers []string) error
 	Get(mode SelectMode) (string, error)
 	GetAll() ([]string, error)
+	// Watch 返回一个通道，注册中心的服务列表每次变化时推送新的列表，不支持时返回ErrWatchNotSupported
+	// ctx被取消后停止推送并关闭通道
+	Watch(ctx context.Context) (<-chan []string, error)
 }
 
+// ErrWatchNotSupported 服务发现不支持推送服务列表
+var ErrWatchNotSupported = errors.New("rpc discovery: watch not supported")
+
 // MultiServersDiscovery MultiServersDiscovery是一个不需要注册中心的多服务发现
 // 用户提供显式服务器地址
 type MultiServersDiscovery struct {
@@ -76,6 +85,11 @@ func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 	}
 }
 
+// Watch 多服务器发现的列表只由Update修改，不支持推送
+func (d *MultiServersDiscovery) Watch(context.Context) (<-chan []string, error) {
+	return nil, ErrWatchNotSupported
+}
+
 // GetAll 返回发现的所有服务器
 func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 	d.mu.RLock()
@@ -1,6 +1,8 @@
 package xclient
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -12,10 +14,18 @@ type GoRegistryDiscovery struct {
 	registry   string
 	timeout    time.Duration
 	lastUpdate time.Time
+	ctx        context.Context // Close后取消，结束所有Watch
+	cancel     context.CancelFunc
 }
 
 const defaultUpdateTimeout = time.Second * 10
 
+// watchRetryInterval Watch请求注册中心失败后的重试间隔
+const watchRetryInterval = time.Second
+
+// errWatchUnsupported 注册中心的响应没有版本号，不支持长轮询
+var errWatchUnsupported = errors.New("registry doesn't support watching")
+
 func NewGoRegistryDiscovery(registerAddr string, timeout time.Duration) *GoRegistryDiscovery {
 	if timeout == 0 {
 		timeout = defaultUpdateTimeout
@@ -25,6 +35,7 @@ func NewGoRegistryDiscovery(registerAddr string, timeout time.Duration) *GoRegis
 		registry:              registerAddr,
 		timeout:               timeout,
 	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
 	return d
 }
 
@@ -48,14 +59,123 @@ func (d *GoRegistryDiscovery) Refresh() error {
 		log.Println("rpc registry refresh err:", err)
 		return err
 	}
-	servers := strings.Split(resp.Header.Get("X-goRPC-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
+	d.servers = parseServers(resp.Header.Get("X-goRPC-Servers"))
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// parseServers 解析注册中心以逗号分隔的服务列表
+func parseServers(header string) []string {
+	servers := strings.Split(header, ",")
+	alive := make([]string, 0, len(servers))
 	for _, server := range servers {
 		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+			alive = append(alive, strings.TrimSpace(server))
 		}
 	}
-	d.lastUpdate = time.Now()
+	return alive
+}
+
+// Watch 通过长轮询注册中心推送服务列表，首先推送当前列表
+// 推送的同时更新发现的服务器，消费者来不及接收时只保留最新的列表，ctx被取消或Close后通道被关闭
+// 注册中心不支持长轮询时改为每隔timeout拉取一次列表，变化时推送
+func (d *GoRegistryDiscovery) Watch(ctx context.Context) (<-chan []string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-d.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ch := make(chan []string, 1)
+	go d.watch(ctx, cancel, ch)
+	return ch, nil
+}
+
+func (d *GoRegistryDiscovery) watch(ctx context.Context, cancel context.CancelFunc, ch chan []string) {
+	defer close(ch)
+	defer cancel()
+	version := ""
+	polling := false // 注册中心不支持长轮询，按timeout拉取
+	for {
+		if polling && !sleep(ctx, d.timeout) {
+			return
+		}
+		since := version
+		if polling {
+			since = ""
+		}
+		servers, v, err := d.poll(ctx, since)
+		if err == errWatchUnsupported {
+			if !polling {
+				log.Printf("rpc registry: %s doesn't support watching, poll every %s", d.registry, d.timeout)
+				polling = true
+			}
+			// 以列表本身作为版本，列表不变时不推送
+			v, err = strings.Join(servers, ","), nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("rpc registry watch err:", err)
+			if !polling && !sleep(ctx, watchRetryInterval) {
+				return
+			}
+			continue
+		}
+		if v == version {
+			continue
+		}
+		version = v
+		_ = d.Update(servers)
+		select {
+		case <-ch: // 丢弃未被接收的旧列表
+		default:
+		}
+		ch <- servers
+	}
+}
+
+// sleep 等待d，ctx先被取消时返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// poll 向注册中心发起一次长轮询，version为空时立即返回当前列表
+// 注册中心不支持长轮询时返回当前列表及errWatchUnsupported
+func (d *GoRegistryDiscovery) poll(ctx context.Context, version string) ([]string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.registry, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if version != "" {
+		req.Header.Set("X-goRPC-Watch", version)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	_ = resp.Body.Close()
+	servers := parseServers(resp.Header.Get("X-goRPC-Servers"))
+	v := resp.Header.Get("X-goRPC-Version")
+	if v == "" {
+		return servers, "", errWatchUnsupported
+	}
+	return servers, v, nil
+}
+
+// Close 结束所有Watch
+func (d *GoRegistryDiscovery) Close() error {
+	d.cancel()
 	return nil
 }
 
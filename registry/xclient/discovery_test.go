@@ -0,0 +1,115 @@
+package xclient
+
+import (
+	"context"
+	"goRPC/registry/regi"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGoRegistryDiscovery_Watch(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(regi.New(0))
+	defer ts.Close()
+	regi.Heartbeat(ts.URL, "tcp@a", time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, 0)
+	defer func() { _ = d.Close() }()
+	ch, err := d.Watch(context.Background())
+	if err != nil {
+		t.Fatal("failed to watch:", err)
+	}
+	next := func() []string {
+		select {
+		case servers := <-ch:
+			return servers
+		case <-time.After(time.Second * 5):
+			t.Fatal("expect the watcher to deliver the server list")
+			return nil
+		}
+	}
+	if servers := next(); !reflect.DeepEqual(servers, []string{"tcp@a"}) {
+		t.Fatalf("expect the current list, got %v", servers)
+	}
+	regi.Heartbeat(ts.URL, "tcp@b", time.Hour)
+	if servers := next(); !reflect.DeepEqual(servers, []string{"tcp@a", "tcp@b"}) {
+		t.Fatalf("expect the new list, got %v", servers)
+	}
+	if s, _ := d.Get(RoundRobinSelect); s != "tcp@a" && s != "tcp@b" {
+		t.Fatalf("expect the discovery to be updated, got %q", s)
+	}
+
+	_ = d.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expect no more lists after Close")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect the watcher to stop after Close")
+	}
+}
+
+func TestMultiServersDiscovery_Watch(t *testing.T) {
+	t.Parallel()
+	if _, err := NewMultiServerDiscovery(nil).Watch(context.Background()); err != ErrWatchNotSupported {
+		t.Fatalf("expect ErrWatchNotSupported, got %v", err)
+	}
+}
+
+func TestGoRegistryDiscovery_WatchLegacy(t *testing.T) {
+	t.Parallel()
+	// a registry before long polling answers without X-goRPC-Version
+	var requests int32
+	var servers atomic.Value
+	servers.Store("tcp@a")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-goRPC-Servers", servers.Load().(string))
+	}))
+	defer ts.Close()
+
+	const interval = 100 * time.Millisecond
+	d := NewGoRegistryDiscovery(ts.URL, interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal("failed to watch:", err)
+	}
+	next := func() []string {
+		select {
+		case servers := <-ch:
+			return servers
+		case <-time.After(time.Second * 5):
+			t.Fatal("expect the watcher to deliver the server list")
+			return nil
+		}
+	}
+	if got := next(); !reflect.DeepEqual(got, []string{"tcp@a"}) {
+		t.Fatalf("expect the current list, got %v", got)
+	}
+	servers.Store("tcp@a,tcp@b")
+	if got := next(); !reflect.DeepEqual(got, []string{"tcp@a", "tcp@b"}) {
+		t.Fatalf("expect the polled list, got %v", got)
+	}
+	time.Sleep(3 * interval)
+	// polled once per interval, not retried as a failure
+	if n, max := atomic.LoadInt32(&requests), int32(time.Since(start)/interval)+2; n > max {
+		t.Fatalf("expect at most %d requests, got %d", max, n)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expect no more lists after the ctx is canceled")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect the watcher to stop after the ctx is canceled")
+	}
+}
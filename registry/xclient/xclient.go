@@ -2,10 +2,15 @@ package xclient
 
 import (
 	"context"
+	"fmt"
 	"goRPC/registry"
 	"io"
+	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 
@@ -15,12 +20,17 @@ type XClient struct {
 	opt  *registry.Option
 	mu sync.Mutex
 	clients map[string]*registry.Client
+	breakers *breakers // 各服务器的熔断器，见SetBreaker
+	balancer Balancer  // 选择服务器的策略，见SetBalancer
+	stopWatch context.CancelFunc // 停止监听服务列表，见Close
 }
 
 
 var _ io.Closer = (*XClient)(nil)
+var _ registry.Caller = (*XClient)(nil)
 
 func (xc *XClient) Close() error {
+	xc.stopWatch()
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 	for key,client := range xc.clients {
@@ -31,8 +41,46 @@ func (xc *XClient) Close() error {
 	return nil
 }
 
+// NewXClient 创建XClient，所有连接使用同一个客户端标识，opt未设置ClientID时使用默认值
 func NewXClient(d Discovery,mode SelectMode,opt *registry.Option) *XClient {
-	return &XClient{d: d,mode: mode,opt: opt,clients: make(map[string]*registry.Client)}
+	if opt == nil {
+		opt = registry.DefaultOption
+	}
+	o := *opt
+	if o.ClientID == "" {
+		o.ClientID = registry.DefaultOption.ClientID
+	}
+	xc := &XClient{d: d,mode: mode,opt: &o,clients: make(map[string]*registry.Client)}
+	xc.SetBalancer(nil)
+	var ctx context.Context
+	ctx, xc.stopWatch = context.WithCancel(context.Background())
+	if ch, err := d.Watch(ctx); err == nil {
+		go xc.watch(ch)
+	}
+	return xc
+}
+
+// watch 服务列表变化时关闭已下线服务器的客户端
+func (xc *XClient) watch(ch <-chan []string) {
+	for servers := range ch {
+		alive := make(map[string]bool, len(servers))
+		for _, s := range servers {
+			alive[s] = true
+		}
+		xc.mu.Lock()
+		for key, client := range xc.clients {
+			if !alive[key] {
+				_ = client.Close()
+				delete(xc.clients, key)
+			}
+		}
+		xc.mu.Unlock()
+	}
+}
+
+// ID 返回XClient的各个连接在握手时声明的客户端标识
+func (xc *XClient) ID() string {
+	return xc.opt.ClientID
 }
 
 func (xc *XClient) dial(rpcAddr string) (*registry.Client,error) {
@@ -55,22 +103,67 @@ func (xc *XClient) dial(rpcAddr string) (*registry.Client,error) {
 	return client,nil
 }
 
-func (xc *XClient) call(rpcAddr string,ctx context.Context,serviceMethod string,args,reply interface{}) error {
+func (xc *XClient) call(rpcAddr string,ctx context.Context,serviceMethod string,args,reply interface{}) (err error) {
+	if ob, ok := xc.balancer.(ObservingBalancer); ok {
+		ob.Begin(rpcAddr)
+		defer func(start time.Time) { ob.End(rpcAddr, time.Since(start), err) }(time.Now())
+	}
 	client,err := xc.dial(rpcAddr)
-	if err != nil {
-		return err
+	if err == nil {
+		err = client.Call(ctx,serviceMethod,args,reply)
 	}
-	return client.Call(ctx,serviceMethod,args,reply)
+	xc.recordCall(ctx, rpcAddr, err)
+	return err
 }
 
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	rpcAddr, err := xc.selectServer(ctx, serviceMethod, args, true)
 	if err != nil {
 		return err
 	}
 	return xc.call(rpcAddr,ctx,serviceMethod,args,reply)
 }
 
+// Go 异步调用命名函数，服务器的选择与连接在返回前完成，失败时返回的Call已带有错误
+func (xc *XClient) Go(serviceMethod string, args, reply interface{}, done chan *registry.Call) *registry.Call {
+	rpcAddr, err := xc.selectServer(context.Background(), serviceMethod, args, false)
+	var client *registry.Client
+	if err == nil {
+		client, err = xc.dial(rpcAddr)
+	}
+	if err == nil {
+		return client.Go(serviceMethod, args, reply, done)
+	}
+	if done == nil {
+		done = make(chan *registry.Call, 1)
+	}
+	call := &registry.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+	select {
+	case done <- call:
+	default:
+		log.Println("rpc xclient: discarding Call reply due to insufficient Done chan capacity")
+	}
+	return call
+}
+
+// IsAvailable 发现中存在可用服务器时返回true
+func (xc *XClient) IsAvailable() bool {
+	servers, err := xc.d.GetAll()
+	return err == nil && len(servers) > 0
+}
+
+// CallWithInfo 与Call相同，同时返回调用的耗时信息及所选服务器的地址
+func (xc *XClient) CallWithInfo(ctx context.Context, serviceMethod string, args, reply interface{}) (registry.CallInfo, error) {
+	var info registry.CallInfo
+	rpcAddr, err := xc.selectServer(ctx, serviceMethod, args, true)
+	if err != nil {
+		return info, err
+	}
+	err = xc.call(rpcAddr, registry.WithCallInfo(ctx, &info), serviceMethod, args, reply)
+	info.Server = rpcAddr
+	return info, err
+}
+
 // Broadcast 广播为发现中所有注册的服务器调用命名函数
 func (xc *XClient) Broadcast(ctx context.Context,serviceMethod string,args,reply interface{}) error {
 	servers,err := xc.d.GetAll()
@@ -105,4 +198,58 @@ func (xc *XClient) Broadcast(ctx context.Context,serviceMethod string,args,reply
 	}
 	wg.Wait()
 	return e
-}
\ No newline at end of file
+}
+// BroadcastNoReply 为发现中所有注册的服务器调用命名函数并丢弃回复，用于只需副作用的广播
+// 与Broadcast不同，一台服务器失败不会取消其他调用，所有调用结束后返回*BroadcastError，列出每台失败的服务器
+func (xc *XClient) BroadcastNoReply(ctx context.Context, serviceMethod string, args interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			if err := xc.call(rpcAddr, ctx, serviceMethod, args, nil); err != nil {
+				mu.Lock()
+				failed[rpcAddr] = err
+				mu.Unlock()
+			}
+		}(rpcAddr)
+	}
+	wg.Wait()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BroadcastError{Failed: failed}
+}
+
+// BroadcastError 广播中失败的服务器及其错误
+type BroadcastError struct {
+	Failed map[string]error // 服务器地址到调用错误
+}
+
+func (e *BroadcastError) Error() string {
+	servers := make([]string, 0, len(e.Failed))
+	for s := range e.Failed {
+		servers = append(servers, s)
+	}
+	sort.Strings(servers)
+	msgs := make([]string, len(servers))
+	for i, s := range servers {
+		msgs[i] = s + ": " + e.Failed[s].Error()
+	}
+	return fmt.Sprintf("rpc xclient: broadcast failed on %d server(s): %s", len(servers), strings.Join(msgs, "; "))
+}
+
+// Unwrap 返回各服务器的错误，errors.Is和errors.As会逐个检查
+func (e *BroadcastError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
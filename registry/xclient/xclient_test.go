@@ -0,0 +1,179 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"goRPC/registry"
+	"goRPC/registry/regi"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Whoami int
+
+func (w Whoami) Get(ctx context.Context, _ int, reply *string) error {
+	*reply = registry.ClientIDFromContext(ctx)
+	return nil
+}
+
+func TestXClient_ClientID(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		var w Whoami
+		server := registry.NewServer()
+		_ = server.Register(&w)
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+		addrs = append(addrs, "tcp@"+l.Addr().String())
+	}
+
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, &registry.Option{ClientID: "worker"})
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 2; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Whoami.Get", 0, &reply); err != nil || reply != "worker" {
+			t.Fatalf("expect every pooled client to announce worker, got %q: %v", reply, err)
+		}
+	}
+	if xc.ID() != "worker" {
+		t.Fatalf("unexpected ID %q", xc.ID())
+	}
+	if id := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil).ID(); id != registry.DefaultOption.ClientID {
+		t.Fatalf("expect the default ID, got %q", id)
+	}
+}
+
+type Addr string
+
+func (a Addr) Get(_ int, reply *string) error {
+	*reply = string(a)
+	return nil
+}
+
+func TestXClient_CallWithInfo(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, _ := net.Listen("tcp", ":0")
+		addr := "tcp@" + l.Addr().String()
+		server := registry.NewServer()
+		_ = server.Register(Addr(addr))
+		go server.Accept(l)
+		addrs = append(addrs, addr)
+	}
+
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply string
+		info, err := xc.CallWithInfo(context.Background(), "Addr.Get", 0, &reply)
+		if err != nil || info.Server != reply {
+			t.Fatalf("expect the call to be attributed to %s, got %s: %v", reply, info.Server, err)
+		}
+		if info.Enqueued.IsZero() || info.Sent.Before(info.Enqueued) || info.Received.Before(info.Sent) {
+			t.Fatalf("unexpected timings %+v", info)
+		}
+	}
+}
+
+func TestXClient_Go(t *testing.T) {
+	t.Parallel()
+	var w Whoami
+	server := registry.NewServer()
+	_ = server.Register(&w)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	var xc registry.Caller = NewXClient(NewMultiServerDiscovery([]string{"tcp@" + l.Addr().String()}), RandomSelect, &registry.Option{ClientID: "go"})
+	defer func() { _ = xc.Close() }()
+	if !xc.IsAvailable() {
+		t.Fatal("expect the XClient to be available")
+	}
+	var reply string
+	if call := <-xc.Go("Whoami.Get", 0, &reply, nil).Done; call.Error != nil || reply != "go" {
+		t.Fatalf("unexpected reply %q: %v", reply, call.Error)
+	}
+
+	empty := NewXClient(NewMultiServerDiscovery(nil), RandomSelect, nil)
+	if empty.IsAvailable() {
+		t.Fatal("expect an XClient without servers to be unavailable")
+	}
+	if call := <-empty.Go("Whoami.Get", 0, &reply, nil).Done; call.Error == nil {
+		t.Fatal("expect an error without servers")
+	}
+}
+
+// Cache 在Broken为true时清空缓存失败
+type Cache struct{ Broken bool }
+
+func (c *Cache) Bust(_ string, reply *bool) error {
+	if c.Broken {
+		return errors.New("cache unavailable")
+	}
+	*reply = true
+	return nil
+}
+
+func TestXClient_BroadcastNoReply(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		server := registry.NewServer()
+		_ = server.Register(&Cache{Broken: i > 0})
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+		addrs = append(addrs, "tcp@"+l.Addr().String())
+	}
+
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	err := xc.BroadcastNoReply(context.Background(), "Cache.Bust", "users")
+	var be *BroadcastError
+	if !errors.As(err, &be) || len(be.Failed) != 2 {
+		t.Fatalf("expect both failures to be reported, got %v", err)
+	}
+	for _, addr := range addrs[1:] {
+		if e := be.Failed[addr]; e == nil || !strings.Contains(e.Error(), "cache unavailable") || !strings.Contains(err.Error(), addr) {
+			t.Fatalf("expect %s to be reported as failed, got %v", addr, err)
+		}
+	}
+	if _, ok := be.Failed[addrs[0]]; ok {
+		t.Fatalf("expect %s to succeed, got %v", addrs[0], err)
+	}
+
+	healthy := NewXClient(NewMultiServerDiscovery(addrs[:1]), RandomSelect, nil)
+	defer func() { _ = healthy.Close() }()
+	if err := healthy.BroadcastNoReply(context.Background(), "Cache.Bust", "users"); err != nil {
+		t.Fatalf("expect the broadcast to succeed, got %v", err)
+	}
+}
+
+func TestXClient_CloseStopsWatch(t *testing.T) {
+	// not parallel, the goroutines of other tests would be counted
+	ts := httptest.NewServer(regi.New(0))
+	defer ts.Close()
+	regi.Heartbeat(ts.URL, "tcp@a", time.Hour)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		d := NewGoRegistryDiscovery(ts.URL, 0)
+		xc := NewXClient(d, RandomSelect, nil)
+		// close once the watcher has delivered the list and is long polling
+		for servers, _ := d.MultiServersDiscovery.GetAll(); len(servers) == 0; servers, _ = d.MultiServersDiscovery.GetAll() {
+			time.Sleep(time.Millisecond)
+		}
+		_ = xc.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the watchers to stop after Close, %d goroutines before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}